@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/client"
+	"github.com/bakare-dev/gotunnel/internal/config"
+	"github.com/bakare-dev/gotunnel/internal/metrics"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/server"
+	"github.com/bakare-dev/gotunnel/internal/transport"
+)
+
+const (
+	// Connections still running when the duration ends get this long to
+	// finish before they count as failed.
+	benchGrace = 5 * time.Second
+
+	// Echo payloads are sent in chunks of this size, each read back before
+	// the next is written, so neither side can fill the other's buffers.
+	benchChunk = 32 * 1024
+
+	// A worker whose connection failed waits before dialing again, doubling
+	// up to the maximum while failures continue.
+	benchRetryMin = 10 * time.Millisecond
+	benchRetryMax = time.Second
+)
+
+type benchConfig struct {
+	serverAddr string
+	token      string
+	tls        client.TLSConfig
+
+	backend     string
+	conns       int
+	connections int
+	size        int
+	rounds      int
+	duration    time.Duration
+}
+
+type benchStats struct {
+	mu     sync.Mutex
+	setups []time.Duration
+
+	completed atomic.Int64
+	failed    atomic.Int64
+	bytes     atomic.Int64
+}
+
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+
+	serverAddr := fs.String("server", "", "Benchmark an existing server instead of an in-process one")
+	token := fs.String("token", "dev-token", "Authentication token")
+	tlsEnabled := fs.Bool("tls", false, "Connect to --server over TLS")
+	tlsCA := fs.String("tls-ca", "", "CA certificate to trust for --server")
+	tlsInsecure := fs.Bool("tls-insecure", false, "Skip certificate verification (testing only)")
+	backend := fs.String("backend", "echo", "Backend behind the tunnel: echo or http")
+	conns := fs.Int("conns", 50, "Concurrent public connections")
+	connections := fs.Int("connections", 1, "Control connections the tunnel client opens")
+	size := fs.Int("size", 64*1024, "Payload bytes per round trip")
+	rounds := fs.Int("rounds", 1, "Round trips per public connection")
+	duration := fs.Duration("duration", 10*time.Second, "How long to generate load")
+	verbose := fs.Bool("verbose", false, "Show server and client logs")
+
+	fs.Parse(args)
+
+	if *backend != "echo" && *backend != "http" {
+		fatalf("unknown --backend %q (use echo or http)", *backend)
+	}
+	if *conns < 1 || *size < 1 || *rounds < 1 || *duration <= 0 {
+		fatalf("--conns, --size, --rounds and --duration must be positive")
+	}
+	if *connections < 1 || *connections > protocol.MaxLanes {
+		fatalf("--connections must be between 1 and %d", protocol.MaxLanes)
+	}
+	if *tlsEnabled && *serverAddr == "" {
+		fatalf("--tls requires --server")
+	}
+	if strings.HasPrefix(*serverAddr, "wss://") {
+		*tlsEnabled = true
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	benchMain(benchConfig{
+		serverAddr: *serverAddr,
+		token:      *token,
+		tls: client.TLSConfig{
+			Enabled:  *tlsEnabled,
+			CAFile:   *tlsCA,
+			Insecure: *tlsInsecure,
+		},
+		backend:     *backend,
+		conns:       *conns,
+		connections: *connections,
+		size:        *size,
+		rounds:      *rounds,
+		duration:    *duration,
+	})
+}
+
+func benchMain(cfg benchConfig) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := make([]byte, cfg.size)
+	rand.Read(payload)
+
+	backendAddr, err := startBenchBackend(ctx, cfg.backend, payload)
+	if err != nil {
+		fatalf("failed to start backend: %v", err)
+	}
+
+	serverAddr := cfg.serverAddr
+	if serverAddr == "" {
+		if serverAddr, err = startBenchServer(ctx, server.PublicConfig{}); err != nil {
+			fatalf("failed to start server: %v", err)
+		}
+	}
+
+	reconnect := client.DefaultReconnectConfig()
+	reconnect.MaxRetries = 3
+
+	creds := client.Credentials{Token: cfg.token}
+	conn, sess, port, err := client.ConnectWithRetry(ctx, serverAddr, backendAddr, creds, protocol.BindOptions{}, cfg.tls, transport.Dialer{}, reconnect)
+	if err != nil {
+		fatalf("failed to open tunnel: %v", err)
+	}
+	join := func() (*protocol.Lane, error) {
+		return client.JoinLane(serverAddr, backendAddr, creds, protocol.BindOptions{}, cfg.tls, transport.Dialer{}, sess)
+	}
+	go runClientSession(ctx, conn, sess, backendAddr, client.ForwarderConfig{}, cfg.connections, join)
+
+	// Give extra lanes a moment to join so the load is spread from the start.
+	for wait := time.Now(); sess.LaneCount() < cfg.connections && time.Since(wait) < benchGrace; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hostPort, _ := transport.HostPort(serverAddr)
+	host, _, _ := net.SplitHostPort(hostPort)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	publicAddr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	where := "in-process server"
+	if cfg.serverAddr != "" {
+		where = "server " + cfg.serverAddr
+	}
+	fmt.Printf("Benchmarking %s through %s (public %s)\n", cfg.backend, where, publicAddr)
+	fmt.Printf("%d connections, %s x %d per connection, for %s", cfg.conns, metrics.FormatBytes(int64(cfg.size)), cfg.rounds, cfg.duration)
+	if cfg.connections > 1 {
+		fmt.Printf(", over %d tunnel connections", cfg.connections)
+	}
+	fmt.Print("\n\n")
+
+	runtime.GC()
+	var memBefore runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	cpuBefore, cpuOK := processCPUTime()
+
+	stats := &benchStats{}
+	loadCtx, stop := context.WithTimeout(ctx, cfg.duration)
+	defer stop()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range cfg.conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchWorker(loadCtx, cfg, publicAddr, payload, stats)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	cpuAfter, _ := processCPUTime()
+	var memAfter runtime.MemStats
+	runtime.ReadMemStats(&memAfter)
+
+	printBenchReport(cfg, stats, elapsed, cpuAfter-cpuBefore, cpuOK, &memBefore, &memAfter)
+}
+
+func benchWorker(ctx context.Context, cfg benchConfig, addr string, payload []byte, stats *benchStats) {
+	buf := make([]byte, min(len(payload), benchChunk))
+	retry := benchRetryMin
+
+	for ctx.Err() == nil {
+		setup, n, err := benchConnection(ctx, cfg, addr, payload, buf)
+		stats.bytes.Add(n)
+		if err != nil {
+			stats.failed.Add(1)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(retry):
+			}
+			retry = min(retry*2, benchRetryMax)
+			continue
+		}
+		retry = benchRetryMin
+
+		stats.completed.Add(1)
+		stats.mu.Lock()
+		stats.setups = append(stats.setups, setup)
+		stats.mu.Unlock()
+	}
+}
+
+// benchConnection opens one public connection and runs the configured round
+// trips over it. Setup is the time from dialing to the first response byte.
+func benchConnection(ctx context.Context, cfg benchConfig, addr string, payload, buf []byte) (time.Duration, int64, error) {
+	start := time.Now()
+
+	conn, err := net.DialTimeout("tcp", addr, benchGrace)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline.Add(benchGrace))
+	}
+
+	var (
+		setup time.Duration
+		total int64
+	)
+	br := bufio.NewReader(conn)
+
+	for round := range cfg.rounds {
+		if cfg.backend == "http" {
+			if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: gotunnel-bench\r\n\r\n"); err != nil {
+				return 0, total, err
+			}
+			if _, err := br.Peek(1); err != nil {
+				return 0, total, err
+			}
+			if round == 0 {
+				setup = time.Since(start)
+			}
+
+			n, err := readBenchResponse(br)
+			total += n
+			if err != nil {
+				return 0, total, err
+			}
+			continue
+		}
+
+		for sent := 0; sent < len(payload); {
+			chunk := payload[sent:min(sent+len(buf), len(payload))]
+			if _, err := conn.Write(chunk); err != nil {
+				return 0, total, err
+			}
+			if round == 0 && sent == 0 {
+				if _, err := br.Peek(1); err != nil {
+					return 0, total, err
+				}
+				setup = time.Since(start)
+			}
+
+			n, err := io.ReadFull(br, buf[:len(chunk)])
+			total += int64(n)
+			if err != nil {
+				return 0, total, err
+			}
+			sent += len(chunk)
+		}
+	}
+
+	return setup, total, nil
+}
+
+func readBenchResponse(br *bufio.Reader) (int64, error) {
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return n, err
+}
+
+func startBenchBackend(ctx context.Context, kind string, payload []byte) (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	if kind == "http" {
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.Write(payload)
+		})}
+		go srv.Serve(ln)
+		return ln.Addr().String(), nil
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+// startBenchServer runs a tunnel server with default settings on loopback.
+func startBenchServer(ctx context.Context, public server.PublicConfig) (string, error) {
+	startPort, err := freePort()
+	if err != nil {
+		return "", err
+	}
+
+	router := server.NewRouter(startPort)
+	srv := &tunnelServer{
+		authMode: protocol.AuthToken,
+		guard:    server.NewAuthGuard(config.LockoutConfig{}),
+		router:   router,
+		public:   server.NewPublicListener(router, public),
+		limiter:  server.NewRateLimiter(config.RateLimitConfig{}),
+		joins:    server.NewJoinRegistry(),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleClient(conn, ctx)
+		}
+	}()
+
+	return ln.Addr().String(), nil
+}
+
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+func printBenchReport(cfg benchConfig, stats *benchStats, elapsed, cpu time.Duration, cpuOK bool, before, after *runtime.MemStats) {
+	transferred := stats.bytes.Load()
+	perSecond := int64(float64(transferred) / elapsed.Seconds())
+
+	throughput := metrics.FormatBytes(perSecond) + "/s down"
+	if cfg.backend == "echo" {
+		throughput += ", same up"
+	}
+
+	fmt.Println("Results")
+	fmt.Println("─────────────────────────────────────────────────────────────")
+	fmt.Printf("Connections        %d ok, %d failed (%.0f/s)\n", stats.completed.Load(), stats.failed.Load(), float64(stats.completed.Load())/elapsed.Seconds())
+	fmt.Printf("Transferred        %s in %s\n", metrics.FormatBytes(transferred), elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput         %s\n", throughput)
+
+	if setups := stats.setups; len(setups) > 0 {
+		slices.Sort(setups)
+		fmt.Printf("Setup latency      p50 %s  p90 %s  p99 %s  max %s\n",
+			percentile(setups, 0.50), percentile(setups, 0.90), percentile(setups, 0.99), setups[len(setups)-1].Round(time.Microsecond))
+	}
+
+	// Server, client and backend share this process, so CPU and memory are
+	// totals for all of them rather than figures for the server alone.
+	if cpuOK {
+		fmt.Printf("Process CPU        %s (%.0f%% of one core)\n", cpu.Round(time.Millisecond), 100*cpu.Seconds()/elapsed.Seconds())
+	}
+	fmt.Printf("Process memory     %s heap in use, %s allocated, %d GCs\n",
+		metrics.FormatBytes(int64(after.HeapInuse)),
+		metrics.FormatBytes(int64(after.TotalAlloc-before.TotalAlloc)),
+		after.NumGC-before.NumGC)
+
+	if cfg.serverAddr != "" {
+		fmt.Println("\nProcess CPU and memory cover the client and backend only, not the server.")
+	} else {
+		fmt.Println("\nProcess CPU and memory cover the server, client and backend together.")
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i].Round(time.Microsecond)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/pki"
+)
+
+const day = 24 * time.Hour
+
+func runCert(args []string) {
+	if len(args) < 1 {
+		printCertUsage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "init":
+		runCertInit(args[1:])
+	case "issue":
+		runCertIssue(args[1:])
+	default:
+		printCertUsage()
+		os.Exit(1)
+	}
+}
+
+func printCertUsage() {
+	fmt.Println(`Usage:
+  gotunnel cert init  [--dir certs] [--key-type ecdsa|ed25519] [--days 3650] [--force]
+  gotunnel cert issue [--dir certs] --host localhost,127.0.0.1 [--client] [--name name] [--out name]`)
+}
+
+func runCertInit(args []string) {
+	fs := flag.NewFlagSet("cert init", flag.ExitOnError)
+
+	dir := fs.String("dir", "certs", "Directory to write the CA to")
+	name := fs.String("name", "GoTunnel CA", "CA common name")
+	keyType := fs.String("key-type", "ecdsa", "Key type: ecdsa or ed25519")
+	days := fs.Int("days", 3650, "Validity in days")
+	force := fs.Bool("force", false, "Overwrite an existing CA")
+
+	fs.Parse(args)
+
+	kt, err := pki.ParseKeyType(*keyType)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	certFile := filepath.Join(*dir, "ca-cert.pem")
+	keyFile := filepath.Join(*dir, "ca-key.pem")
+
+	if _, err := os.Stat(keyFile); err == nil && !*force {
+		fatalf("%s already exists (use --force to replace it; certificates signed by the old CA will stop verifying)", keyFile)
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fatalf("%v", err)
+	}
+
+	ca, err := pki.NewCA(*name, kt, time.Duration(*days)*day)
+	if err != nil {
+		fatalf("failed to create CA: %v", err)
+	}
+	if err := pki.WriteFiles(ca.Cert, ca.Key, certFile, keyFile); err != nil {
+		fatalf("failed to write CA: %v", err)
+	}
+
+	fmt.Printf("✓ CA created in %s/\n\n", *dir)
+	fmt.Printf("  - ca-cert.pem   (give to clients: --tls-ca=%s)\n", certFile)
+	fmt.Printf("  - ca-key.pem    (keep private, mode 0600)\n\n")
+	fmt.Println("Next: gotunnel cert issue --host localhost,127.0.0.1")
+}
+
+func runCertIssue(args []string) {
+	fs := flag.NewFlagSet("cert issue", flag.ExitOnError)
+
+	dir := fs.String("dir", "certs", "Directory holding the CA and receiving the certificate")
+	hosts := fs.String("host", "", "Comma-separated DNS names, IPs, URIs or emails for the SAN")
+	isClient := fs.Bool("client", false, "Issue a client certificate for mutual TLS")
+	name := fs.String("name", "", "Common name (default: first --host)")
+	out := fs.String("out", "", "Output file prefix (default: server or client)")
+	keyType := fs.String("key-type", "ecdsa", "Key type: ecdsa or ed25519")
+	days := fs.Int("days", 825, "Validity in days")
+
+	fs.Parse(args)
+
+	kt, err := pki.ParseKeyType(*keyType)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	sans := splitList(*hosts)
+	if len(sans) == 0 && *name == "" {
+		if *isClient {
+			fatalf("--client requires --name or --host to identify the client")
+		}
+		sans = []string{"localhost", "127.0.0.1", "::1"}
+	}
+
+	prefix := *out
+	if prefix == "" {
+		prefix = "server"
+		if *isClient {
+			prefix = "client"
+		}
+	}
+
+	ca, err := pki.LoadCA(filepath.Join(*dir, "ca-cert.pem"), filepath.Join(*dir, "ca-key.pem"))
+	if err != nil {
+		fatalf("%v (run 'gotunnel cert init' first)", err)
+	}
+
+	cert, key, err := ca.Issue(pki.Request{
+		CommonName: *name,
+		Hosts:      sans,
+		Client:     *isClient,
+		KeyType:    kt,
+		Validity:   time.Duration(*days) * day,
+	})
+	if err != nil {
+		fatalf("failed to issue certificate: %v", err)
+	}
+
+	certFile := filepath.Join(*dir, prefix+"-cert.pem")
+	keyFile := filepath.Join(*dir, prefix+"-key.pem")
+	if err := pki.WriteFiles(cert, key, certFile, keyFile); err != nil {
+		fatalf("failed to write certificate: %v", err)
+	}
+
+	fmt.Printf("✓ Issued %s certificate for %s\n\n", prefix, cert.Subject.CommonName)
+	fmt.Printf("  - %s\n", certFile)
+	fmt.Printf("  - %s   (mode 0600)\n\n", keyFile)
+	if *isClient {
+		fmt.Printf("Client usage: --tls-cert=%s --tls-key=%s\n", certFile, keyFile)
+	} else {
+		fmt.Printf("Server usage: --tls-cert=%s --tls-key=%s\n", certFile, keyFile)
+	}
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Printf("Error: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -12,9 +12,10 @@ import (
 
 	"github.com/bakare-dev/gotunnel/internal/client"
 	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/transport"
 )
 
-func clientMain(serverAddr, localAddr, token string, tlsEnabled bool, tlsCA string, noReconnect bool) {
+func clientMain(serverAddr, localAddr string, creds client.Credentials, bind protocol.BindOptions, fwdConfig client.ForwarderConfig, tlsConfig client.TLSConfig, dialer transport.Dialer, connections int, noReconnect bool) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -28,9 +29,15 @@ func clientMain(serverAddr, localAddr, token string, tlsEnabled bool, tlsCA stri
 	}()
 
 	reconnectConfig := client.DefaultReconnectConfig()
-	tlsConfig := client.TLSConfig{
-		Enabled: tlsEnabled,
-		CAFile:  tlsCA,
+
+	if tlsConfig.Enabled && tlsConfig.Insecure {
+		log.Println("│ WARN  │ TLS certificate verification disabled - do not use in production")
+	}
+	if !tlsConfig.Enabled && (bind.BasicAuth != "" || bind.BearerToken != "") {
+		log.Println("│ WARN  │ Public endpoint credentials are sent to the server unencrypted - use --tls")
+	}
+	if dialer.Proxy != nil {
+		log.Printf("│ INFO  │ Connecting through proxy %s", dialer.Proxy.Redacted())
 	}
 
 	for {
@@ -41,15 +48,18 @@ func clientMain(serverAddr, localAddr, token string, tlsEnabled bool, tlsCA stri
 		default:
 		}
 
-		conn, sess, publicPort, err := client.ConnectWithRetry(ctx, serverAddr, localAddr, token, tlsConfig, reconnectConfig)
+		conn, sess, publicPort, err := client.ConnectWithRetry(ctx, serverAddr, localAddr, creds, bind, tlsConfig, dialer, reconnectConfig)
 		if err != nil {
 			log.Printf("│ ERROR │ Failed to connect: %v", err)
 			return
 		}
 
-		printClientBanner(serverAddr, publicPort, localAddr, !noReconnect, tlsEnabled)
+		printClientBanner(serverAddr, publicPort, localAddr, !noReconnect, tlsConfig.Enabled, bind)
 
-		err = runClientSession(ctx, conn, sess, localAddr)
+		join := func() (*protocol.Lane, error) {
+			return client.JoinLane(serverAddr, localAddr, creds, bind, tlsConfig, dialer, sess)
+		}
+		err = runClientSession(ctx, conn, sess, localAddr, fwdConfig, connections, join)
 
 		fmt.Println("\n" + sess.Metrics.Summary())
 
@@ -74,42 +84,43 @@ func clientMain(serverAddr, localAddr, token string, tlsEnabled bool, tlsCA stri
 	}
 }
 
-func runClientSession(ctx context.Context, conn *net.Conn, sess *protocol.Session, localAddr string) error {
+func runClientSession(ctx context.Context, conn *net.Conn, sess *protocol.Session, localAddr string, fwdConfig client.ForwarderConfig, connections int, join func() (*protocol.Lane, error)) error {
 	defer (*conn).Close()
 	defer sess.Close()
 
-	forwarder := client.NewForwarder(sess, localAddr)
+	forwarder := client.NewForwarder(sess, localAddr, fwdConfig)
 	defer forwarder.Close()
 
 	done := make(chan error, 1)
 
-	go func() {
-		for {
+	handle := func(frame *protocol.Frame) {
+		if frame.Type == protocol.MsgError {
 			select {
-			case <-ctx.Done():
-				done <- ctx.Err()
-				return
+			case done <- fmt.Errorf("server closed tunnel: %s", frame.Payload):
 			default:
 			}
-
-			frame, err := sess.ReadFrame()
-			if err != nil {
-				done <- err
-				return
-			}
-
-			if frame.Type == protocol.MsgHeartbeat {
-				continue
-			}
-
-			forwarder.HandleFrame(frame)
+			return
 		}
-	}()
+		forwarder.HandleFrame(frame)
+	}
 
-	return <-done
+	// Losing one connection only ends the session when no other lanes are
+	// left to carry it.
+	ended := client.KeepLanes(ctx, sess, connections, join, handle)
+
+	select {
+	case err := <-done:
+		return err
+	case err := <-ended:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sess.Done():
+		return protocol.ErrSessionExpired
+	}
 }
 
-func printClientBanner(server string, publicPort uint16, localAddr string, reconnectEnabled, tlsEnabled bool) {
+func printClientBanner(server string, publicPort uint16, localAddr string, reconnectEnabled, tlsEnabled bool, bind protocol.BindOptions) {
 	reconnectStatus := "enabled"
 	if !reconnectEnabled {
 		reconnectStatus = "disabled"
@@ -120,23 +131,33 @@ func printClientBanner(server string, publicPort uint16, localAddr string, recon
 		tlsStatus = "enabled ✓"
 	}
 
+	scheme := "tcp"
+	if bind.HTTPS {
+		scheme = "https"
+	}
+
+	sessionStatus := "online"
+	if bind.Standby {
+		sessionStatus = "online (standby)"
+	}
+
 	banner := `
 ╔════════════════════════════════════════════════════════════╗
 ║                   GoTunnel v%s                         ║
 ║                 Secure TCP Tunneling                       ║
 ╚════════════════════════════════════════════════════════════╝
 
-Session Status         online
+Session Status         %s
 Version                %s
 Tunnel Server          %s
 TLS Encryption         %s
 Auto-Reconnect         %s
 
-Forwarding             tcp://localhost:%d → %s
+Forwarding             %s://localhost:%d → %s
 
 HTTP Requests
 ─────────────────────────────────────────────────────────────
 `
-	fmt.Printf(banner, version, version, server, tlsStatus, reconnectStatus, publicPort, localAddr)
+	fmt.Printf(banner, version, sessionStatus, version, server, tlsStatus, reconnectStatus, scheme, publicPort, localAddr)
 	fmt.Printf("Connected at %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bakare-dev/gotunnel/internal/auth"
+)
+
+func runKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+
+	path := fs.String("f", defaultKeyPath(), "Private key file (public key is written to <file>.pub)")
+	comment := fs.String("C", defaultKeyComment(), "Comment added to the public key")
+	force := fs.Bool("force", false, "Overwrite an existing key")
+
+	fs.Parse(args)
+
+	if _, err := os.Stat(*path); err == nil && !*force {
+		fatalf("%s already exists (use --force to replace it)", *path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*path), 0700); err != nil {
+		fatalf("%v", err)
+	}
+
+	pub, priv, err := auth.GenerateKey()
+	if err != nil {
+		fatalf("failed to generate key: %v", err)
+	}
+	if err := auth.WriteKeyPair(*path, pub, priv, *comment); err != nil {
+		fatalf("failed to write key: %v", err)
+	}
+
+	fmt.Printf("✓ Ed25519 key written to %s (mode 0600)\n", *path)
+	fmt.Printf("  Public key: %s.pub\n", *path)
+	fmt.Printf("  Fingerprint: %s\n\n", auth.Fingerprint(pub))
+	fmt.Println("Add this line to the server's authorized_keys file:")
+	fmt.Println(auth.MarshalPublicKey(pub, *comment))
+}
+
+func defaultKeyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "id_ed25519"
+	}
+	return filepath.Join(home, ".gotunnel", "id_ed25519")
+}
+
+func defaultKeyComment() string {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = os.Getenv("USERNAME")
+	}
+	host, _ := os.Hostname()
+	if user == "" || host == "" {
+		return ""
+	}
+	return user + "@" + host
+}
@@ -3,59 +3,175 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/bakare-dev/gotunnel/internal/auth"
+	"github.com/bakare-dev/gotunnel/internal/config"
+	"github.com/bakare-dev/gotunnel/internal/pki"
+	"github.com/bakare-dev/gotunnel/internal/policy"
 	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/quota"
 	"github.com/bakare-dev/gotunnel/internal/server"
+	"github.com/bakare-dev/gotunnel/internal/transport"
+	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
-func serverMain(tunnelAddr string, startPort int, tlsEnabled bool, tlsCert, tlsKey string) {
-	printServerBanner(tlsEnabled)
+func serverMain(cfg *config.Config) {
+	printServerBanner(cfg.TLS.Enabled)
 
-	router := server.NewRouter(startPort)
-	public := server.NewPublicListener(router)
+	access, err := server.ParseAccessList(cfg.Access.Allow, cfg.Access.Deny)
+	if err != nil {
+		log.Fatalf("Invalid access list: %v", err)
+	}
 
-	var ln net.Listener
-	var err error
+	var certs *server.CertStore
+	if cfg.PublicTLS.Enabled() {
+		certs, err = loadCertStore(cfg.PublicTLS)
+		if err != nil {
+			log.Fatalf("Failed to load public certificates: %v", err)
+		}
+		log.Println("│ INFO  │ Public HTTPS termination available ✓")
+	}
 
-	if tlsEnabled {
-		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+	middleware, err := tunnel.LookupMiddlewares(cfg.Middleware)
+	if err != nil {
+		log.Fatalf("Invalid middleware: %v", err)
+	}
+	if len(middleware) > 0 {
+		log.Printf("│ INFO  │ Stream middleware: %s", strings.Join(cfg.Middleware, ", "))
+	}
+
+	router := server.NewRouter(cfg.StartPort)
+	public := server.NewPublicListener(router, server.PublicConfig{
+		Access:     access,
+		Certs:      certs,
+		Middleware: middleware,
+	})
+	limiter := server.NewRateLimiter(cfg.RateLimits)
+
+	authMode, err := parseAuthConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var keys *auth.KeyStore
+	if cfg.Auth.AuthorizedKeys != "" {
+		keys, err = auth.OpenKeyStore(cfg.Auth.AuthorizedKeys)
+		if err != nil {
+			log.Fatalf("Failed to load authorized keys: %v", err)
+		}
+		log.Printf("│ INFO  │ Loaded %d authorized keys from %s", keys.Len(), cfg.Auth.AuthorizedKeys)
+	}
+
+	guard := server.NewAuthGuard(cfg.Auth.Lockout)
+
+	if err := cfg.Policies.Validate(); err != nil {
+		log.Fatalf("Invalid policies: %v", err)
+	}
+	if n := len(cfg.Policies.Tokens); n > 0 {
+		log.Printf("│ INFO  │ Loaded %d client policies", n)
+	}
+
+	var quotas *quota.Manager
+	if cfg.Quotas.Store != "" {
+		quotas, err = quota.NewManager(cfg.Quotas)
+		if err != nil {
+			log.Fatalf("Failed to open quota store: %v", err)
+		}
+		defer quotas.Close()
+		log.Printf("│ INFO  │ Quota usage stored in %s", cfg.Quotas.Store)
+	}
+
+	srv := &tunnelServer{
+		authMode:         authMode,
+		requireChallenge: cfg.Auth.RequireChallenge,
+		keys:             keys,
+		guard:            guard,
+		router:           router,
+		public:           public,
+		limiter:          limiter,
+		quotas:           quotas,
+		policies:         cfg.Policies,
+		joins:            server.NewJoinRegistry(),
+	}
+
+	if cfg.AdminAddr != "" {
+		go server.NewAdmin(router, quotas, guard).ListenAndServe(cfg.AdminAddr)
+	}
+
+	var (
+		ln        net.Listener
+		tlsConfig *tls.Config
+	)
+
+	if cfg.TLS.Enabled {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
 		if err != nil {
 			log.Fatalf("Failed to load TLS certificate: %v", err)
 		}
 
-		config := &tls.Config{
+		tlsConfig = &tls.Config{
 			Certificates: []tls.Certificate{cert},
 			MinVersion:   tls.VersionTLS12,
 		}
 
-		ln, err = tls.Listen("tcp", tunnelAddr, config)
+		if cfg.TLS.ClientCAFile != "" {
+			clientCAs, err := server.LoadClientCAs(cfg.TLS.ClientCAFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			log.Println("│ INFO  │ Client certificates required ✓")
+		}
+
+		ln, err = tls.Listen("tcp", cfg.ListenAddr, tlsConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
 
 		log.Println("│ INFO  │ TLS enabled ✓")
 	} else {
-		ln, err = net.Listen("tcp", tunnelAddr)
+		ln, err = net.Listen("tcp", cfg.ListenAddr)
 		if err != nil {
 			log.Fatal(err)
 		}
 		log.Println("│ WARN  │ TLS disabled - connection is NOT encrypted")
 	}
 
+	listeners := []net.Listener{ln}
+
+	if cfg.WebSocket.Addr != "" {
+		wsLn, err := startWebSocket(cfg.WebSocket, tlsConfig)
+		if err != nil {
+			log.Fatalf("Failed to start WebSocket listener: %v", err)
+		}
+		listeners = append(listeners, wsLn)
+	}
+
 	log.Println("│ INFO  │ Server started")
-	log.Printf("│ INFO  │ Tunnel port: %s\n", tunnelAddr)
+	log.Printf("│ INFO  │ Tunnel port: %s\n", cfg.ListenAddr)
 	log.Println("│ INFO  │ Ready for connections")
 	log.Println("─────────────────────────────────────────────────────────────")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if certs != nil {
+		go certs.Watch(ctx)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -63,36 +179,95 @@ func serverMain(tunnelAddr string, startPort int, tlsEnabled bool, tlsCert, tlsK
 		<-sigChan
 		log.Println("\n│ INFO  │ Received shutdown signal")
 		cancel()
-		ln.Close()
-	}()
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			conn, err := ln.Accept()
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					continue
-				}
-			}
-			go handleServerClient(conn, router, public, ctx)
+		for _, l := range listeners {
+			l.Close()
 		}
 	}()
 
+	for _, l := range listeners {
+		go srv.serve(ctx, l)
+	}
+
 	<-ctx.Done()
 	log.Println("│ INFO  │ Shutting down server...")
 	router.CloseAll()
 	log.Println("│ INFO  │ Server shutdown complete")
 }
 
+// parseAuthConfig reads the auth mode and checks that the TLS settings it
+// depends on are present.
+func parseAuthConfig(cfg *config.Config) (protocol.AuthMode, error) {
+	authMode, err := protocol.ParseAuthMode(cfg.Auth.Mode)
+	if err != nil {
+		return authMode, err
+	}
+	if authMode == protocol.AuthToken {
+		return authMode, nil
+	}
+	if !cfg.TLS.Enabled {
+		return authMode, fmt.Errorf("auth mode %q requires --tls: client certificates are only presented during the TLS handshake", cfg.Auth.Mode)
+	}
+	if cfg.TLS.ClientCAFile == "" {
+		return authMode, fmt.Errorf("auth mode %q requires --tls-client-ca", cfg.Auth.Mode)
+	}
+	return authMode, nil
+}
+
+// startWebSocket serves WebSocket upgrades on their own HTTP listener and
+// returns a net.Listener that yields the upgraded connections.
+func startWebSocket(cfg config.WebSocketConfig, tlsConfig *tls.Config) (net.Listener, error) {
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if !strings.HasPrefix(cfg.Path, "/") || strings.ContainsAny(cfg.Path, "{} ") {
+		return nil, fmt.Errorf("websocket path %q must be a plain URL path starting with /", cfg.Path)
+	}
+
+	raw, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	wsLn, err := transport.NewWebSocketListener(raw.Addr(), cfg.TrustedProxies)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, wsLn)
+
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+
+	scheme := "ws"
+	if tlsConfig != nil {
+		raw = tls.NewListener(raw, tlsConfig)
+		scheme = "wss"
+	}
+	go httpServer.Serve(raw)
+
+	log.Printf("│ INFO  │ WebSocket endpoint: %s://%s%s", scheme, cfg.Addr, cfg.Path)
+	return wsLn, nil
+}
+
+func loadCertStore(cfg config.PublicTLSConfig) (*server.CertStore, error) {
+	var ca *pki.Authority
+	if cfg.CACert != "" {
+		var err error
+		if ca, err = pki.LoadCA(cfg.CACert, cfg.CAKey); err != nil {
+			return nil, err
+		}
+		if len(cfg.Domains) == 0 {
+			log.Println("│ WARN  │ No public_tls.domains set; the CA only signs names allowed by tunnel host restrictions")
+		}
+	}
+	return server.NewCertStore(cfg.CertDir, ca, cfg.Domains)
+}
+
 func printServerBanner(tlsEnabled bool) {
 	tlsStatus := ""
 	if tlsEnabled {
@@ -107,10 +282,62 @@ func printServerBanner(tlsEnabled bool) {
 	fmt.Println(banner)
 }
 
-func handleServerClient(conn net.Conn, router *server.Router, public *server.PublicListener, ctx context.Context) {
+type tunnelServer struct {
+	authMode         protocol.AuthMode
+	requireChallenge bool
+	keys             *auth.KeyStore
+	guard            *server.AuthGuard
+	router           *server.Router
+	public           *server.PublicListener
+	limiter          *server.RateLimiter
+	quotas           *quota.Manager
+	policies         policy.Config
+	joins            *server.JoinRegistry
+}
+
+func (t *tunnelServer) serve(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, transport.ErrListenerClosed) {
+				return
+			}
+			continue
+		}
+		go t.handleClient(conn, ctx)
+	}
+}
+
+func (t *tunnelServer) handleClient(conn net.Conn, ctx context.Context) {
 	defer conn.Close()
 
+	ipKey := "ip:" + server.RemoteIP(conn).String()
+	if t.guard.Locked(ipKey) {
+		return
+	}
+
+	// Connections must finish the TLS handshake, MsgHandshake and MsgAuth
+	// within the timeout instead of holding a goroutine forever.
+	conn.SetReadDeadline(time.Now().Add(t.guard.HandshakeTimeout))
+
+	identity, err := server.PeerIdentity(conn)
+	if err != nil {
+		log.Printf("│ ERROR │ TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
 	sess := protocol.NewSession(conn, conn)
+	defer sess.Close()
+	sess.AuthMode = t.authMode
+	sess.RequireChallenge = t.requireChallenge
+	login := server.NewKeyLogin(t.keys)
+	sess.VerifyKey = login.Verify
+	sess.PeerIdentity = identity
 
 	for {
 		select {
@@ -122,6 +349,9 @@ func handleServerClient(conn net.Conn, router *server.Router, public *server.Pub
 
 		frame, err := sess.ReadFrame()
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("│ DEBUG │ Handshake timeout from %s", conn.RemoteAddr())
+			}
 			return
 		}
 
@@ -131,45 +361,106 @@ func handleServerClient(conn net.Conn, router *server.Router, public *server.Pub
 				log.Printf("│ ERROR │ Handshake failed: %v", err)
 				return
 			}
-			_ = sess.WriteFrame(&protocol.Frame{Type: protocol.MsgHandshakeAck})
+
+			var nonce []byte
+			if sess.Capabilities&protocol.CapChallenge != 0 {
+				if nonce, err = sess.IssueChallenge(); err != nil {
+					log.Printf("│ ERROR │ Failed to create auth challenge: %v", err)
+					return
+				}
+			}
+			_ = sess.WriteFrame(&protocol.Frame{Type: protocol.MsgHandshakeAck, Payload: nonce})
 
 		case protocol.MsgAuth:
-			if err := sess.ProcessAuth(frame); err != nil {
-				log.Printf("│ ERROR │ Auth failed: %v", err)
+			credentials := authSubjects(frame, sess)
+			if t.guard.Locked(credentials...) {
+				log.Printf("│ WARN  │ Rejected locked-out credential from %s", conn.RemoteAddr())
 				return
 			}
-			_ = sess.WriteFrame(&protocol.Frame{Type: protocol.MsgAuthOK})
 
-			port := router.AllocatePort(sess)
-			go public.Listen(port)
+			err := sess.ProcessAuth(frame)
+			if subject := sess.MatchedTokenSubject(); subject != "" && !slices.Contains(credentials, subject) {
+				credentials = append(credentials, subject)
+				if err == nil && t.guard.Locked(subject) {
+					log.Printf("│ WARN  │ Rejected locked-out credential from %s", conn.RemoteAddr())
+					return
+				}
+			}
+			if err != nil {
+				t.guard.Failure(append(credentials, ipKey)...)
+				log.Printf("│ ERROR │ Auth failed from %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			t.guard.Success(credentials...)
+			conn.SetReadDeadline(time.Time{})
 
-			_ = sess.WriteFrame(&protocol.Frame{
-				Type:    protocol.MsgBindOK,
-				Payload: protocol.EncodeUint16(uint16(port)),
-			})
+			_ = sess.WriteFrame(&protocol.Frame{Type: protocol.MsgAuthOK})
+
+			if sess.PeerIdentity != "" {
+				log.Printf("│ INFO  │ Client authenticated as %s", sess.PeerIdentity)
+			}
+			if login.Key != nil {
+				log.Printf("│ INFO  │ Client authenticated with key %s %s", login.Key.Fingerprint(), login.Key.Comment)
+			}
 
-			log.Printf("│ INFO  │ Client bound to public port %d", port)
-			log.Printf("│ INFO  │ Exposing: %s → :%d", sess.ExposeAddr, port)
-			log.Println("─────────────────────────────────────────────────────────────")
+			if len(sess.Bind.Join) > 0 {
+				t.join(conn, sess)
+				return
+			}
+			if !t.bind(conn, sess, login.Key) {
+				return
+			}
 			goto FORWARD
 		}
 	}
 
 FORWARD:
-	for {
-		select {
-		case <-ctx.Done():
-			router.Remove(sess.PublicPort)
-			sess.Close()
-			log.Printf("│ INFO  │ Client session closed (port %d)", sess.PublicPort)
-			return
-		default:
-		}
+	stop := context.AfterFunc(ctx, func() { sess.Close() })
+	defer stop()
 
-		frame, err := sess.ReadFrame()
+	// The session outlives its first connection while other lanes remain.
+	t.serveLane(sess, sess.Primary())
+	<-sess.Done()
+	t.unbind(sess)
+
+	if ctx.Err() != nil {
+		log.Printf("│ INFO  │ Client session closed (port %d)", sess.PublicPort)
+	} else {
+		log.Printf("│ INFO  │ Client disconnected (port %d)", sess.PublicPort)
+	}
+}
+
+// join attaches an authenticated connection to the session named by its
+// join ticket and serves it as another lane of that tunnel.
+func (t *tunnelServer) join(conn net.Conn, temp *protocol.Session) {
+	sess, err := t.joins.Join(temp.Bind.Join, temp.Identity)
+	if err != nil {
+		rejectBind(temp, err)
+		return
+	}
+
+	_ = temp.WriteFrame(&protocol.Frame{
+		Type:    protocol.MsgBindOK,
+		Payload: protocol.EncodeBindOK(uint16(sess.PublicPort), nil),
+	})
+	temp.Detach()
+
+	lane, err := sess.AddLane(conn, conn)
+	if err != nil {
+		log.Printf("│ WARN  │ Connection could not join tunnel on port %d: %v", sess.PublicPort, err)
+		return
+	}
+	log.Printf("│ INFO  │ Connection joined tunnel on port %d (%d connections)", sess.PublicPort, sess.LaneCount())
+
+	t.serveLane(sess, lane)
+}
+
+func (t *tunnelServer) serveLane(sess *protocol.Session, lane *protocol.Lane) {
+	defer lane.Close()
+
+	for {
+		frame, err := lane.ReadFrame()
 		if err != nil {
-			router.Remove(sess.PublicPort)
-			log.Printf("│ INFO  │ Client disconnected (port %d)", sess.PublicPort)
 			return
 		}
 
@@ -180,3 +471,147 @@ FORWARD:
 		_ = sess.HandleFrame(frame)
 	}
 }
+
+func (t *tunnelServer) bind(conn net.Conn, sess *protocol.Session, key *auth.AuthorizedKey) bool {
+	if t.quotas != nil {
+		if err := t.quotas.Check(sess.Identity); err != nil {
+			rejectBind(sess, err)
+			return false
+		}
+	}
+
+	if sess.Bind.TunnelProtocol() == protocol.ProtoUDP {
+		rejectBind(sess, &protocol.BindError{
+			Code:    protocol.CodeProtocolNotAllowed,
+			Message: "udp tunnels are not supported by this server",
+		})
+		return false
+	}
+
+	policy, err := server.NewTunnelPolicy(sess.Bind)
+	if err != nil {
+		rejectBind(sess, err)
+		return false
+	}
+	if policy.TLS && !t.public.TLSEnabled() {
+		rejectBind(sess, server.ErrPublicTLSUnavailable)
+		return false
+	}
+
+	clientPolicy := t.policies.For(sess.Identity)
+	policy.MaxStreams = clientPolicy.MaxStreams
+	policy.RequireHosts(clientPolicy.Hosts)
+	if policy.Middleware, err = tunnel.LookupMiddlewares(clientPolicy.Middleware); err != nil {
+		rejectBind(sess, err)
+		return false
+	}
+
+	if key != nil {
+		policy.RequireHosts(key.Options.Hosts)
+	}
+
+	// The limits are checked under the router lock, so concurrent binds
+	// cannot both fit under max_tunnels.
+	limit := func(active int) error {
+		if err := clientPolicy.CheckBind(sess.Bind, active); err != nil {
+			return err
+		}
+		if key != nil {
+			return server.CheckKeyOptions(key, sess.Bind, active)
+		}
+		return nil
+	}
+
+	port, err := t.router.BindLimited(sess, sess.Bind, limit)
+	if err != nil {
+		rejectBind(sess, err)
+		return false
+	}
+	t.limiter.Attach(sess)
+	t.public.Register(sess, policy)
+	go t.public.Listen(port)
+
+	go func() {
+		<-sess.Done()
+		conn.Close()
+	}()
+
+	if key != nil && !key.Options.Expires.IsZero() {
+		expiry := time.AfterFunc(time.Until(key.Options.Expires), func() {
+			log.Printf("│ WARN  │ Closing tunnel on port %d: key %s expired", port, key.Fingerprint())
+			_ = sess.WriteFrame(&protocol.Frame{
+				Type:    protocol.MsgError,
+				Payload: []byte(protocol.ErrKeyExpired.Error()),
+			})
+			sess.Close()
+		})
+		go func() {
+			<-sess.Done()
+			expiry.Stop()
+		}()
+	}
+
+	if t.quotas != nil {
+		go t.quotas.Track(sess.Identity, sess.Metrics, sess.Done(), func(err error) {
+			log.Printf("│ WARN  │ Closing tunnel on port %d: %v", port, err)
+			_ = sess.WriteFrame(&protocol.Frame{
+				Type:    protocol.MsgError,
+				Payload: []byte(err.Error()),
+			})
+			sess.Close()
+		})
+	}
+
+	var ticket *protocol.JoinTicket
+	if sess.Capabilities&protocol.CapStriping != 0 {
+		if ticket, err = t.joins.Register(sess); err != nil {
+			log.Printf("│ WARN  │ Failed to issue join ticket: %v", err)
+		}
+	}
+
+	_ = sess.WriteFrame(&protocol.Frame{
+		Type:    protocol.MsgBindOK,
+		Payload: protocol.EncodeBindOK(uint16(port), ticket),
+	})
+
+	if sess.Bind.Standby {
+		log.Printf("│ INFO  │ Client registered as standby on public port %d", port)
+	} else {
+		log.Printf("│ INFO  │ Client bound to public port %d", port)
+	}
+	if policy.Auth.Enabled() {
+		log.Printf("│ INFO  │ Public endpoint on port %d requires authentication", port)
+	}
+	if policy.TLS {
+		log.Printf("│ INFO  │ Public endpoint on port %d serves HTTPS", port)
+	}
+	log.Printf("│ INFO  │ Exposing: %s → :%d", sess.ExposeAddr, port)
+	log.Println("─────────────────────────────────────────────────────────────")
+	return true
+}
+
+func (t *tunnelServer) unbind(sess *protocol.Session) {
+	t.router.Remove(sess)
+	t.public.Unregister(sess)
+	sess.Close()
+}
+
+func authSubjects(frame *protocol.Frame, sess *protocol.Session) []string {
+	var subjects []string
+	if subject := protocol.AuthSubject(frame.Payload); subject != "" {
+		subjects = append(subjects, subject)
+	}
+	if sess.PeerIdentity != "" {
+		subjects = append(subjects, "cert:"+sess.PeerIdentity)
+	}
+	return subjects
+}
+
+func rejectBind(sess *protocol.Session, err error) {
+	bindErr := server.BindErrorFor(err)
+	log.Printf("│ ERROR │ Bind failed: %v", bindErr)
+	_ = sess.WriteFrame(&protocol.Frame{
+		Type:    protocol.MsgError,
+		Payload: []byte(bindErr.Error()),
+	})
+}
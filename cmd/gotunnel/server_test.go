@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/client"
+	"github.com/bakare-dev/gotunnel/internal/config"
+	"github.com/bakare-dev/gotunnel/internal/pki"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/server"
+	"github.com/bakare-dev/gotunnel/internal/transport"
+)
+
+// TestHTTPSTunnelHTTP2 runs a visitor through an --https tunnel to an h2c
+// backend, with and without --h2.
+func TestHTTPSTunnelHTTP2(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+	backendAddr := backend.Listener.Addr().String()
+
+	ca, err := pki.NewCA("Test CA", pki.KeyECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := server.NewCertStore("", ca, []string{"*.tunnel.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverAddr, err := startBenchServer(ctx, server.PublicConfig{Certs: certs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	for _, tc := range []struct {
+		http2 bool
+		want  string
+	}{
+		{false, "HTTP/1.1"},
+		{true, "HTTP/2.0"},
+	} {
+		bind := protocol.BindOptions{HTTPS: true, HTTP2: tc.http2}
+		reconnect := client.DefaultReconnectConfig()
+		reconnect.MaxRetries = 1
+
+		conn, sess, port, err := client.ConnectWithRetry(ctx, serverAddr, backendAddr, client.Credentials{Token: "dev-token"}, bind, client.TLSConfig{}, transport.Dialer{}, reconnect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go runClientSession(ctx, conn, sess, backendAddr, client.ForwarderConfig{}, 1, nil)
+
+		public := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
+		visitor := &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: roots},
+				ForceAttemptHTTP2: true,
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, public)
+				},
+			},
+		}
+
+		// Two requests, so HTTP/2 multiplexing over one stream is covered.
+		for range 2 {
+			resp, err := visitor.Get("https://app.tunnel.test/")
+			if err != nil {
+				t.Fatalf("h2=%v: request failed: %v", tc.http2, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.Proto != tc.want || string(body) != tc.want {
+				t.Fatalf("h2=%v: expected %s end to end, got %s to the visitor and %q to the backend", tc.http2, tc.want, resp.Proto, body)
+			}
+		}
+		sess.Close()
+	}
+}
+
+// TestFirstConnectionIsRedialed drops the connection a striped tunnel was
+// opened on and checks that the client replaces it and keeps serving.
+func TestFirstConnectionIsRedialed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	backendAddr := backend.Listener.Addr().String()
+
+	serverAddr, err := startBenchServer(ctx, server.PublicConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds := client.Credentials{Token: "dev-token"}
+	reconnect := client.DefaultReconnectConfig()
+	reconnect.MaxRetries = 1
+
+	conn, sess, port, err := client.ConnectWithRetry(ctx, serverAddr, backendAddr, creds, protocol.BindOptions{}, client.TLSConfig{}, transport.Dialer{}, reconnect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	join := func() (*protocol.Lane, error) {
+		return client.JoinLane(serverAddr, backendAddr, creds, protocol.BindOptions{}, client.TLSConfig{}, transport.Dialer{}, sess)
+	}
+	go runClientSession(ctx, conn, sess, backendAddr, client.ForwarderConfig{}, 2, join)
+
+	for deadline := time.Now().Add(5 * time.Second); sess.LaneCount() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("second connection never joined")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	(*conn).Close()
+	for deadline := time.Now().Add(5 * time.Second); slices.Contains(sess.Lanes(), sess.Primary()) || sess.LaneCount() != 2; {
+		if time.Now().After(deadline) || sess.IsClosed() {
+			t.Fatalf("first connection was not replaced (%d connections, closed %v)", sess.LaneCount(), sess.IsClosed())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	visitor := &http.Client{Timeout: 5 * time.Second}
+	for range 4 {
+		resp, err := visitor.Get("http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))) + "/")
+		if err != nil {
+			t.Fatalf("request after losing the first connection failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+	sess.Close()
+}
+
+func TestParseAuthConfig(t *testing.T) {
+	tests := []struct {
+		mode     string
+		tls      bool
+		clientCA string
+		ok       bool
+	}{
+		{"token", false, "", true},
+		{"cert", true, "ca.pem", true},
+		{"cert+token", true, "ca.pem", true},
+		{"cert", false, "ca.pem", false},
+		{"cert+token", false, "ca.pem", false},
+		{"cert", true, "", false},
+		{"password", true, "ca.pem", false},
+	}
+
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.Auth.Mode = tt.mode
+		cfg.TLS.Enabled = tt.tls
+		cfg.TLS.ClientCAFile = tt.clientCA
+
+		if _, err := parseAuthConfig(cfg); (err == nil) != tt.ok {
+			t.Errorf("mode %q, tls %v, client CA %q: got %v", tt.mode, tt.tls, tt.clientCA, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuthorizedKey struct {
+	Key     ed25519.PublicKey
+	Comment string
+	Options Options
+}
+
+func (k *AuthorizedKey) Fingerprint() string {
+	return Fingerprint(k.Key)
+}
+
+// ParseAuthorizedKeys reads lines of the form
+//
+//	[options] ssh-ed25519 <base64> [comment]
+//
+// where options are ports="10080,10100-10110", hosts="*.dev.example.com",
+// max-tunnels=2 and expires="2026-12-31".
+func ParseAuthorizedKeys(data []byte) ([]*AuthorizedKey, error) {
+	var keys []*AuthorizedKey
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := parseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, scanner.Err()
+}
+
+func parseAuthorizedKey(line string) (*AuthorizedKey, error) {
+	var optionStr string
+	if !strings.HasPrefix(line, keyType+" ") {
+		optionStr, line = cutOptions(line)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, ErrInvalidPublicKey
+	}
+
+	pub, err := ParsePublicKey(fields[0], fields[1])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := parseOptions(optionStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorizedKey{
+		Key:     pub,
+		Comment: strings.Join(fields[2:], " "),
+		Options: opts,
+	}, nil
+}
+
+func cutOptions(line string) (string, string) {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			return line[:i], strings.TrimSpace(line[i:])
+		}
+	}
+	return line, ""
+}
+
+// KeyStore serves an authorized_keys file, re-reading it when it changes so
+// keys can be added or revoked without restarting the server.
+type KeyStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    map[string]*AuthorizedKey
+}
+
+func OpenKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *KeyStore) Lookup(pub ed25519.PublicKey) (*AuthorizedKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, err := os.Stat(s.path); err == nil && !info.ModTime().Equal(s.modTime) {
+		if err := s.reload(); err != nil {
+			log.Printf("│ WARN  │ Failed to reload %s, keeping previous keys: %v", s.path, err)
+		}
+	}
+
+	key, ok := s.keys[string(pub)]
+	return key, ok
+}
+
+func (s *KeyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+func (s *KeyStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseAuthorizedKeys(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	keys := make(map[string]*AuthorizedKey, len(parsed))
+	for _, key := range parsed {
+		keys[string(key.Key)] = key
+	}
+
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return nil
+}
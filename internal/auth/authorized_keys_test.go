@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	pub, _, _ := GenerateKey()
+	line := MarshalPublicKey(pub, "alice@laptop")
+
+	data := "# team keys\n\n" +
+		line + "\n" +
+		`ports="10080,10100-10110",hosts="api.example.com,*.dev.example.com",max-tunnels=2,expires="2030-01-01" ` + line + "\n"
+
+	keys, err := ParseAuthorizedKeys([]byte(data))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if !keys[0].Key.Equal(pub) || keys[0].Comment != "alice@laptop" {
+		t.Fatalf("unexpected first key: %+v", keys[0])
+	}
+
+	opts := keys[1].Options
+	if !opts.AllowsPort(10080) || !opts.AllowsPort(10105) || opts.AllowsPort(10090) {
+		t.Fatalf("unexpected port ranges: %+v", opts.Ports)
+	}
+	if !opts.AllowsHost("api.example.com") || !opts.AllowsHost("app.dev.example.com") ||
+		opts.AllowsHost("a.b.dev.example.com") || opts.AllowsHost("evil.com") {
+		t.Fatalf("unexpected host matching: %v", opts.Hosts)
+	}
+	if opts.MaxTunnels != 2 {
+		t.Fatalf("expected max-tunnels 2, got %d", opts.MaxTunnels)
+	}
+	if opts.Expired(time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC)) || !opts.Expired(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected expiry: %v", opts.Expires)
+	}
+}
+
+func TestParseAuthorizedKeysRejectsBadLines(t *testing.T) {
+	pub, _, _ := GenerateKey()
+	line := MarshalPublicKey(pub, "")
+
+	for _, bad := range []string{
+		"ssh-rsa AAAAB3NzaC1yc2E",
+		"ssh-ed25519 not-base64",
+		"no-pty " + line,
+		`ports="0-10" ` + line,
+	} {
+		if _, err := ParseAuthorizedKeys([]byte(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestKeyStoreReloadsAndSigns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	pub, priv, _ := GenerateKey()
+	os.WriteFile(path, nil, 0600)
+
+	store, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Lookup(pub); ok {
+		t.Fatal("key should not be authorized yet")
+	}
+
+	os.WriteFile(path, []byte(MarshalPublicKey(pub, "bob")+"\n"), 0600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+
+	key, ok := store.Lookup(pub)
+	if !ok {
+		t.Fatal("expected key after reload")
+	}
+
+	serverNonce, clientNonce := []byte("server-nonce"), []byte("client-nonce")
+	sig := Sign(priv, serverNonce, clientNonce)
+	if !Verify(key.Key, serverNonce, clientNonce, sig) {
+		t.Fatal("signature should verify")
+	}
+	if Verify(key.Key, []byte("other-nonce"), clientNonce, sig) {
+		t.Fatal("signature must not verify for another nonce")
+	}
+}
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	pub, priv, _ := GenerateKey()
+
+	if err := WriteKeyPair(path, pub, priv, "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadPrivateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(priv) {
+		t.Fatal("loaded key differs")
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected 0600, got %v", info.Mode().Perm())
+	}
+
+	line, _ := os.ReadFile(path + ".pub")
+	keys, err := ParseAuthorizedKeys(line)
+	if err != nil || len(keys) != 1 || !keys[0].Key.Equal(pub) {
+		t.Fatalf("public key file not parseable: %v", err)
+	}
+}
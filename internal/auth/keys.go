@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const keyType = "ssh-ed25519"
+
+var ErrInvalidPublicKey = errors.New("auth: invalid ed25519 public key")
+
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// MarshalPublicKey renders a key in the authorized_keys line format used by
+// OpenSSH, so keys can be pasted between the two.
+func MarshalPublicKey(pub ed25519.PublicKey, comment string) string {
+	var blob []byte
+	blob = appendSSHString(blob, []byte(keyType))
+	blob = appendSSHString(blob, pub)
+
+	line := keyType + " " + base64.StdEncoding.EncodeToString(blob)
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+func ParsePublicKey(typ, encoded string) (ed25519.PublicKey, error) {
+	if typ != keyType {
+		return nil, fmt.Errorf("auth: unsupported key type %q (only %s)", typ, keyType)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	name, rest, ok := readSSHString(blob)
+	if !ok || string(name) != keyType {
+		return nil, ErrInvalidPublicKey
+	}
+	key, rest, ok := readSSHString(rest)
+	if !ok || len(rest) != 0 || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Fingerprint matches the "SHA256:..." form printed by ssh-keygen -l.
+func Fingerprint(pub ed25519.PublicKey) string {
+	var blob []byte
+	blob = appendSSHString(blob, []byte(keyType))
+	blob = appendSSHString(blob, pub)
+
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func WriteKeyPair(path string, pub ed25519.PublicKey, priv ed25519.PrivateKey, comment string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path+".pub", []byte(MarshalPublicKey(pub, comment)+"\n"), 0644)
+}
+
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		if strings.Contains(string(data), "OPENSSH PRIVATE KEY") {
+			return nil, fmt.Errorf("%s is an OpenSSH key; create one with 'gotunnel keygen'", path)
+		}
+		return nil, fmt.Errorf("no private key found in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+func appendSSHString(buf, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+func readSSHString(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
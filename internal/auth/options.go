@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PortRange struct {
+	From, To int
+}
+
+// Options restrict what a key may do. Zero values mean no restriction.
+type Options struct {
+	Ports      []PortRange
+	Hosts      []string
+	MaxTunnels int
+	Expires    time.Time
+}
+
+func (o Options) AllowsPort(port int) bool {
+	if len(o.Ports) == 0 {
+		return true
+	}
+	for _, r := range o.Ports {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Options) RestrictsPorts() bool {
+	return len(o.Ports) > 0
+}
+
+// AllowsHost matches exact names and single-label wildcards (*.example.com).
+func (o Options) AllowsHost(host string) bool {
+	if len(o.Hosts) == 0 {
+		return true
+	}
+	return MatchHost(o.Hosts, host)
+}
+
+func MatchHost(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, parent, found := strings.Cut(host, ".")
+			if found && label != "" && parent == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o Options) Expired(now time.Time) bool {
+	return !o.Expires.IsZero() && !now.Before(o.Expires)
+}
+
+func parseOptions(s string) (Options, error) {
+	var opts Options
+
+	for _, opt := range splitOptions(s) {
+		name, value, _ := strings.Cut(opt, "=")
+		value = strings.Trim(value, `"`)
+
+		switch strings.ToLower(name) {
+		case "ports":
+			for _, item := range strings.Split(value, ",") {
+				r, err := ParsePortRange(strings.TrimSpace(item))
+				if err != nil {
+					return opts, err
+				}
+				opts.Ports = append(opts.Ports, r)
+			}
+		case "hosts":
+			for _, host := range strings.Split(value, ",") {
+				if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+					opts.Hosts = append(opts.Hosts, host)
+				}
+			}
+		case "max-tunnels":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("invalid max-tunnels %q", value)
+			}
+			opts.MaxTunnels = n
+		case "expires":
+			t, err := parseExpiry(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Expires = t
+		default:
+			return opts, fmt.Errorf("unknown option %q", name)
+		}
+	}
+
+	return opts, nil
+}
+
+func ParsePortRange(s string) (PortRange, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	if !isRange {
+		to = from
+	}
+
+	lo, err1 := strconv.Atoi(from)
+	hi, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || lo < 1 || hi > 65535 || lo > hi {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return PortRange{From: lo, To: hi}, nil
+}
+
+// parseExpiry accepts a date (the key stops working at 00:00 UTC that day)
+// or a full RFC 3339 timestamp.
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expires %q (use YYYY-MM-DD or RFC 3339)", s)
+}
+
+// splitOptions splits on commas outside double quotes, as sshd does.
+func splitOptions(s string) []string {
+	var (
+		opts   []string
+		quoted bool
+		start  int
+	)
+
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			opts = append(opts, s[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		opts = append(opts, s[start:])
+	}
+	return opts
+}
@@ -0,0 +1,22 @@
+package auth
+
+import "crypto/ed25519"
+
+const signatureLabel = "gotunnel-pubkey-v1"
+
+// Sign proves possession of a key for one handshake. Both nonces are
+// covered so the signature is tied to this connection and cannot be replayed.
+func Sign(priv ed25519.PrivateKey, serverNonce, clientNonce []byte) []byte {
+	return ed25519.Sign(priv, signedData(serverNonce, clientNonce))
+}
+
+func Verify(pub ed25519.PublicKey, serverNonce, clientNonce, sig []byte) bool {
+	return ed25519.Verify(pub, signedData(serverNonce, clientNonce), sig)
+}
+
+func signedData(serverNonce, clientNonce []byte) []byte {
+	data := make([]byte, 0, len(signatureLabel)+len(serverNonce)+len(clientNonce))
+	data = append(data, signatureLabel...)
+	data = append(data, serverNonce...)
+	return append(data, clientNonce...)
+}
@@ -1,5 +1,7 @@
 package client
 
+import "github.com/bakare-dev/gotunnel/internal/protocol"
+
 func (f *Forwarder) closeStream(streamID uint32) {
 	f.mu.Lock()
 	conn, ok := f.conns[streamID]
@@ -8,5 +10,11 @@ func (f *Forwarder) closeStream(streamID uint32) {
 		delete(f.conns, streamID)
 	}
 	f.mu.Unlock()
+}
 
+func (f *Forwarder) sendClose(streamID uint32) {
+	_ = f.sess.WriteFrame(&protocol.Frame{
+		Type:     protocol.MsgStreamClose,
+		StreamID: streamID,
+	})
 }
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/transport"
+)
+
+const dialTimeout = 10 * time.Second
+
+// dialServer connects to the tunnel server over raw TCP, TLS or, for ws://
+// and wss:// addresses, a WebSocket. Any proxy is traversed first, so TLS
+// and the tunnel handshake run end to end through it.
+func dialServer(serverAddr string, tlsCfg TLSConfig, dialer transport.Dialer) (net.Conn, error) {
+	dialer.Timeout = dialTimeout
+
+	if transport.IsWebSocketURL(serverAddr) {
+		return dialWebSocket(serverAddr, tlsCfg, dialer)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", serverAddr)
+	if err != nil || !tlsCfg.Enabled {
+		return conn, err
+	}
+
+	config, err := tlsCfg.clientConfig(serverAddr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Println("│ INFO  │ TLS connection established")
+	return tlsConn, nil
+}
+
+func dialWebSocket(serverURL string, tlsCfg TLSConfig, dialer transport.Dialer) (net.Conn, error) {
+	addr, err := transport.HostPort(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *tls.Config
+	if tlsCfg.Enabled {
+		if config, err = tlsCfg.clientConfig(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	conn, err := transport.DialWebSocket(ctx, dialer, serverURL, config)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("│ INFO  │ WebSocket connection established")
+	return conn, nil
+}
@@ -9,22 +9,59 @@ import (
 	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
+type ForwarderConfig struct {
+	ProxyProtocol tunnel.ProxyProtocolVersion
+	HTTPHeaders   tunnel.HeaderRewriteConfig
+
+	// WebSocketText logs the text messages of upgraded streams.
+	WebSocketText bool
+
+	// Decoders names the database and cache protocols to decode; empty
+	// leaves stream contents alone.
+	Decoders []string
+
+	// Middleware wraps the built-in logging, decoders and header
+	// rewriting, outermost first.
+	Middleware tunnel.MiddlewareChain
+}
+
 type Forwarder struct {
 	sess       *protocol.Session
 	targetAddr string
+	config     ForwarderConfig
+	chain      tunnel.MiddlewareChain
 
-	mu       sync.Mutex
-	conns    map[uint32]net.Conn
-	httpLogs map[uint32]*tunnel.HTTPLog
+	mu        sync.Mutex
+	conns     map[uint32]net.Conn
+	pipelines map[uint32]*tunnel.StreamPipeline
 }
 
-func NewForwarder(sess *protocol.Session, targetAddr string) *Forwarder {
-	return &Forwarder{
+func NewForwarder(sess *protocol.Session, targetAddr string, config ForwarderConfig) *Forwarder {
+	f := &Forwarder{
 		sess:       sess,
 		targetAddr: targetAddr,
+		config:     config,
+		chain:      config.chain(),
 		conns:      make(map[uint32]net.Conn),
-		httpLogs:   make(map[uint32]*tunnel.HTTPLog),
+		pipelines:  make(map[uint32]*tunnel.StreamPipeline),
+	}
+	return f
+}
+
+// chain puts the configured middlewares in front of the built-in ones.
+func (c ForwarderConfig) chain() tunnel.MiddlewareChain {
+	chain := append(tunnel.MiddlewareChain{}, c.Middleware...)
+	chain = append(chain, tunnel.InspectMiddleware(tunnel.InspectConfig{
+		WebSocketEvents: true,
+		WebSocketText:   c.WebSocketText,
+	}))
+	if len(c.Decoders) > 0 {
+		chain = append(chain, tunnel.DecoderMiddleware(c.Decoders))
+	}
+	if c.HTTPHeaders.Enabled() {
+		chain = append(chain, tunnel.HeaderRewriteMiddleware(c.HTTPHeaders))
 	}
+	return chain
 }
 
 func (f *Forwarder) Close() {
@@ -39,5 +76,5 @@ func (f *Forwarder) Close() {
 	}
 
 	f.conns = make(map[uint32]net.Conn)
-	f.httpLogs = make(map[uint32]*tunnel.HTTPLog)
+	f.pipelines = make(map[uint32]*tunnel.StreamPipeline)
 }
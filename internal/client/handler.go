@@ -1,27 +1,31 @@
 package client
 
 import (
-	"time"
+	"log"
 
 	"github.com/bakare-dev/gotunnel/internal/protocol"
-	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
 func (f *Forwarder) HandleFrame(frame *protocol.Frame) {
+	// Payloads are fully consumed before returning, so the buffer goes
+	// straight back to the pool.
+	defer frame.Release()
+
 	switch frame.Type {
 
 	case protocol.MsgStreamOpen:
-		f.mu.Lock()
-		f.httpLogs[frame.StreamID] = &tunnel.HTTPLog{
-			StartTime: time.Now(),
+		open, err := protocol.DecodeStreamOpen(frame.Payload)
+		if err != nil {
+			log.Printf("│ ERROR │ [Stream %d] Invalid StreamOpen payload: %v", frame.StreamID, err)
+			return
 		}
-		f.mu.Unlock()
-		f.openStream(frame.StreamID)
+
+		f.openStream(frame.StreamID, open)
 
 	case protocol.MsgStreamData:
 		f.writeToLocal(frame.StreamID, frame.Payload)
 
 	case protocol.MsgStreamClose:
-		f.closeStream(frame.StreamID)
+		f.endStream(frame.StreamID)
 	}
 }
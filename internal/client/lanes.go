@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/transport"
+)
+
+var ErrStripingUnsupported = errors.New("server does not support multiple connections per tunnel")
+
+// JoinLane opens another connection to the server and attaches it to sess
+// with the join ticket from its MsgBindOK.
+func JoinLane(serverAddr, localAddr string, creds Credentials, bind protocol.BindOptions, tlsCfg TLSConfig, dialer transport.Dialer, sess *protocol.Session) (*protocol.Lane, error) {
+	if sess.Ticket == nil {
+		return nil, ErrStripingUnsupported
+	}
+	bind.Join = sess.Ticket.Encode()
+
+	conn, temp, _, err := attemptConnection(serverAddr, localAddr, creds, bind, tlsCfg, dialer)
+	if err != nil {
+		return nil, err
+	}
+	temp.Detach()
+
+	lane, err := sess.AddLane(*conn, *conn)
+	if err != nil {
+		(*conn).Close()
+		return nil, err
+	}
+	return lane, nil
+}
+
+// KeepLanes serves every connection of sess and holds it at the given number
+// until the session ends, redialing any lane that drops, the first one
+// included. Frames read from the lanes are passed to handle. The returned
+// channel receives the error of the lane whose loss ended the session.
+func KeepLanes(ctx context.Context, sess *protocol.Session, connections int, join func() (*protocol.Lane, error), handle func(*protocol.Frame)) <-chan error {
+	ended := make(chan error, 1)
+	go keepLane(ctx, sess, sess.Primary(), join, handle, ended)
+	for range connections - 1 {
+		go keepLane(ctx, sess, nil, join, handle, ended)
+	}
+	return ended
+}
+
+func keepLane(ctx context.Context, sess *protocol.Session, lane *protocol.Lane, join func() (*protocol.Lane, error), handle func(*protocol.Frame), ended chan<- error) {
+	config := DefaultReconnectConfig()
+	backoff := config.InitialBackoff
+
+	for ctx.Err() == nil && !sess.IsClosed() {
+		if lane == nil {
+			var err error
+			if lane, err = join(); err != nil {
+				var bindErr *protocol.BindError
+				if errors.Is(err, ErrStripingUnsupported) || errors.As(err, &bindErr) && !bindErr.Retryable() {
+					log.Printf("│ WARN  │ Tunnel connection refused: %v", err)
+					return
+				}
+				log.Printf("│ DEBUG │ Tunnel connection failed, retrying in %v: %v", backoff, err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-sess.Done():
+					return
+				case <-time.After(backoff):
+				}
+
+				backoff = min(time.Duration(float64(backoff)*config.BackoffFactor), config.MaxBackoff)
+				continue
+			}
+			backoff = config.InitialBackoff
+		}
+
+		err := ServeLane(lane, handle)
+		lane = nil
+		if sess.IsClosed() {
+			select {
+			case ended <- err:
+			default:
+			}
+		}
+	}
+}
+
+// ServeLane reads frames from lane until it fails, then closes it so its
+// streams move over and the session carries on over the remaining lanes.
+func ServeLane(lane *protocol.Lane, handle func(*protocol.Frame)) error {
+	defer lane.Close()
+
+	for {
+		frame, err := lane.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if frame.Type == protocol.MsgHeartbeat {
+			continue
+		}
+		handle(frame)
+	}
+}
@@ -3,17 +3,46 @@ package client
 import (
 	"log"
 	"net"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
-func (f *Forwarder) openStream(streamID uint32) {
+func (f *Forwarder) openStream(streamID uint32, open *protocol.StreamOpen) {
+	pipeline, err := f.chain.Open(&tunnel.StreamInfo{
+		ID:         streamID,
+		RemoteAddr: open.RemoteAddr,
+		LocalAddr:  open.LocalAddr,
+		TLS:        open.TLS,
+		ServerName: open.ServerName,
+		Metrics:    f.sess.Metrics,
+	})
+	if err != nil {
+		log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", streamID, err)
+		f.sendClose(streamID)
+		return
+	}
+
 	conn, err := net.Dial("tcp", f.targetAddr)
 	if err != nil {
 		log.Printf("│ ERROR │ [Stream %d] Failed to connect to %s: %v", streamID, f.targetAddr, err)
+		pipeline.Close()
 		return
 	}
 
+	if f.config.ProxyProtocol != tunnel.ProxyProtocolOff {
+		header := tunnel.ProxyHeader(f.config.ProxyProtocol, open.RemoteAddr, open.LocalAddr)
+		if _, err := conn.Write(header); err != nil {
+			log.Printf("│ ERROR │ [Stream %d] Failed to send PROXY header: %v", streamID, err)
+			conn.Close()
+			pipeline.Close()
+			return
+		}
+	}
+
 	f.mu.Lock()
 	f.conns[streamID] = conn
+	f.pipelines[streamID] = pipeline
 	f.mu.Unlock()
 
 	go f.pipeLocalToTunnel(streamID, conn)
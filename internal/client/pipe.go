@@ -5,87 +5,87 @@ import (
 	"log"
 	"net"
 	"strings"
-	"time"
 
 	"github.com/bakare-dev/gotunnel/internal/protocol"
-	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
 func (f *Forwarder) pipeLocalToTunnel(streamID uint32, conn net.Conn) {
+	f.mu.Lock()
+	pipeline := f.pipelines[streamID]
+	f.mu.Unlock()
+
+	// Whoever removes the connection closes the stream. If closeStream got
+	// there first, the server closed the stream or it was rejected, and
+	// MsgStreamClose was already sent when needed.
 	defer func() {
 		f.mu.Lock()
 		_, exists := f.conns[streamID]
 		if exists {
 			delete(f.conns, streamID)
 		}
-		delete(f.httpLogs, streamID)
+		delete(f.pipelines, streamID)
 		f.mu.Unlock()
 
 		if exists {
 			conn.Close()
+			if !f.sess.IsClosed() {
+				f.sendClose(streamID)
+			}
 		}
+		pipeline.Close()
 
 		f.sess.Metrics.StreamClosed()
 	}()
 
 	f.sess.Metrics.StreamOpened()
 
-	buf := make([]byte, 4096)
-	isFirstPacket := true
+	buf := protocol.NewReadBuffer()
+	defer buf.Release()
 
 	for {
 		if f.sess.IsClosed() {
 			return
 		}
 
-		n, err := conn.Read(buf)
+		data, err := buf.Read(conn)
+		if err == io.EOF {
+			// Send what the middlewares still hold before closing.
+			if data, err = pipeline.FlushOutbound(); err != nil {
+				log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", streamID, err)
+			} else if len(data) > 0 {
+				_ = f.sess.WriteFrame(&protocol.Frame{
+					Type:     protocol.MsgStreamData,
+					StreamID: streamID,
+					Payload:  data,
+				})
+			}
+			return
+		}
 		if err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") {
-				return
-			} else if err == io.EOF {
-				// Silent EOF
-			} else {
+			if !strings.Contains(err.Error(), "use of closed network connection") {
 				log.Printf("│ ERROR │ [Stream %d] Local error: %v", streamID, err)
 			}
-			break
+			return
 		}
 
-		if n > 0 {
-			if isFirstPacket {
-				isFirstPacket = false
-				f.mu.Lock()
-				if httpLog, ok := f.httpLogs[streamID]; ok && httpLog.Request != nil {
-					httpLog.Response = tunnel.ParseHTTPResponse(buf[:n])
-					httpLog.Duration = time.Since(httpLog.StartTime)
-
-					if httpLog.Response != nil {
-						f.sess.Metrics.RecordHTTPRequest(httpLog.Response.StatusCode, httpLog.Duration)
-					}
-
-					if logStr := httpLog.String(); logStr != "" {
-						log.Printf("│ HTTP  │ %s", logStr)
-					}
-				}
-				f.mu.Unlock()
-			}
+		data, err = pipeline.Outbound(data)
+		if err != nil {
+			log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", streamID, err)
+			return
+		}
 
+		if len(data) > 0 {
 			err = f.sess.WriteFrame(&protocol.Frame{
 				Type:     protocol.MsgStreamData,
 				StreamID: streamID,
-				Payload:  buf[:n],
+				Payload:  data,
 			})
 			if err != nil {
-				if err == protocol.ErrSessionExpired {
-					return
+				if err != protocol.ErrSessionExpired {
+					log.Printf("│ ERROR │ [Stream %d] Tunnel write failed: %v", streamID, err)
 				}
-				log.Printf("│ ERROR │ [Stream %d] Tunnel write failed: %v", streamID, err)
-				break
+				return
 			}
 		}
 	}
-
-	_ = f.sess.WriteFrame(&protocol.Frame{
-		Type:     protocol.MsgStreamClose,
-		StreamID: streamID,
-	})
 }
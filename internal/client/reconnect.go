@@ -2,17 +2,23 @@ package client
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-	"os"
 	"time"
 
+	"github.com/bakare-dev/gotunnel/internal/auth"
 	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/transport"
 )
 
+type Credentials struct {
+	Token string
+	Key   ed25519.PrivateKey
+}
+
 type ReconnectConfig struct {
 	MaxRetries     int
 	InitialBackoff time.Duration
@@ -29,12 +35,7 @@ func DefaultReconnectConfig() ReconnectConfig {
 	}
 }
 
-type TLSConfig struct {
-	Enabled bool
-	CAFile  string
-}
-
-func ConnectWithRetry(ctx context.Context, serverAddr, localAddr, token string, tlsCfg TLSConfig, config ReconnectConfig) (*net.Conn, *protocol.Session, uint16, error) {
+func ConnectWithRetry(ctx context.Context, serverAddr, localAddr string, creds Credentials, bind protocol.BindOptions, tlsCfg TLSConfig, dialer transport.Dialer, config ReconnectConfig) (*net.Conn, *protocol.Session, uint16, error) {
 	backoff := config.InitialBackoff
 
 	for attempt := 1; attempt <= config.MaxRetries; attempt++ {
@@ -46,7 +47,7 @@ func ConnectWithRetry(ctx context.Context, serverAddr, localAddr, token string,
 
 		log.Printf("│ INFO  │ Connection attempt %d/%d...", attempt, config.MaxRetries)
 
-		conn, sess, port, err := attemptConnection(serverAddr, localAddr, token, tlsCfg)
+		conn, sess, port, err := attemptConnection(serverAddr, localAddr, creds, bind, tlsCfg, dialer)
 		if err == nil {
 			log.Printf("│ INFO  │ Connected successfully")
 			return conn, sess, port, nil
@@ -54,6 +55,12 @@ func ConnectWithRetry(ctx context.Context, serverAddr, localAddr, token string,
 
 		log.Printf("│ WARN  │ Connection failed: %v", err)
 
+		// Policy rejections will not change on a retry.
+		var bindErr *protocol.BindError
+		if errors.As(err, &bindErr) && !bindErr.Retryable() {
+			return nil, nil, 0, err
+		}
+
 		if attempt < config.MaxRetries {
 			log.Printf("│ INFO  │ Retrying in %v...", backoff)
 
@@ -73,51 +80,27 @@ func ConnectWithRetry(ctx context.Context, serverAddr, localAddr, token string,
 	return nil, nil, 0, fmt.Errorf("failed to connect after %d attempts", config.MaxRetries)
 }
 
-func attemptConnection(serverAddr, localAddr, token string, tlsCfg TLSConfig) (*net.Conn, *protocol.Session, uint16, error) {
-	var conn net.Conn
-	var err error
-
-	if tlsCfg.Enabled {
-		caCert, err := os.ReadFile(tlsCfg.CAFile)
-		if err != nil {
-			return nil, nil, 0, fmt.Errorf("failed to read CA cert: %w", err)
-		}
-
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, nil, 0, fmt.Errorf("failed to parse CA cert")
-		}
-
-		config := &tls.Config{
-			RootCAs:    caCertPool,
-			ServerName: "localhost",
-		}
-
-		conn, err = tls.DialWithDialer(
-			&net.Dialer{Timeout: 10 * time.Second},
-			"tcp",
-			serverAddr,
-			config,
-		)
-		if err != nil {
-			return nil, nil, 0, err
-		}
-
-		log.Println("│ INFO  │ TLS connection established")
-	} else {
-
-		conn, err = net.DialTimeout("tcp", serverAddr, 10*time.Second)
-		if err != nil {
-			return nil, nil, 0, err
-		}
+func attemptConnection(serverAddr, localAddr string, creds Credentials, bind protocol.BindOptions, tlsCfg TLSConfig, dialer transport.Dialer) (*net.Conn, *protocol.Session, uint16, error) {
+	conn, err := dialServer(serverAddr, tlsCfg, dialer)
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
 	sess := protocol.NewSession(conn, conn)
 
+	// Stop the session's writer if the connection never gets bound.
+	bound := false
+	defer func() {
+		if !bound {
+			sess.Close()
+		}
+	}()
+
 	hs := &protocol.Handshake{
 		Role:         protocol.RoleClient,
-		Capabilities: protocol.CapHeartbeat,
+		Capabilities: protocol.CapHeartbeat | protocol.CapChallenge | protocol.CapStriping,
 		ExposeAddr:   localAddr,
+		Bind:         bind,
 	}
 
 	payload, err := hs.Encode()
@@ -140,9 +123,15 @@ func attemptConnection(serverAddr, localAddr, token string, tlsCfg TLSConfig) (*
 		return nil, nil, 0, fmt.Errorf("handshake rejected")
 	}
 
+	authPayload, err := authPayload(creds, frame.Payload, tlsCfg.Enabled)
+	if err != nil {
+		conn.Close()
+		return nil, nil, 0, err
+	}
+
 	if err := sess.WriteFrame(&protocol.Frame{
 		Type:    protocol.MsgAuth,
-		Payload: protocol.EncodeAuth(token),
+		Payload: authPayload,
 	}); err != nil {
 		conn.Close()
 		return nil, nil, 0, err
@@ -155,12 +144,51 @@ func attemptConnection(serverAddr, localAddr, token string, tlsCfg TLSConfig) (*
 	}
 
 	frame, err = sess.ReadFrame()
+	if err == nil && frame.Type == protocol.MsgError {
+		conn.Close()
+		return nil, nil, 0, fmt.Errorf("bind rejected: %w", protocol.DecodeBindError(frame.Payload))
+	}
 	if err != nil || frame.Type != protocol.MsgBindOK {
 		conn.Close()
 		return nil, nil, 0, fmt.Errorf("failed to bind")
 	}
 
-	publicPort := protocol.DecodeUint16(frame.Payload)
+	publicPort, ticket, err := protocol.DecodeBindOK(frame.Payload)
+	if err != nil {
+		conn.Close()
+		return nil, nil, 0, fmt.Errorf("failed to bind: %w", err)
+	}
+	sess.Ticket = ticket
+	sess.StartHeartbeat()
+	bound = true
 
 	return &conn, sess, publicPort, nil
 }
+
+// authPayload answers the server's challenge when it sent one, signing it
+// with the client key if there is one. Servers that predate
+// challenge-response get the plaintext token, but only over TLS.
+func authPayload(creds Credentials, serverNonce []byte, tlsEnabled bool) ([]byte, error) {
+	if len(serverNonce) == protocol.NonceSize {
+		clientNonce, err := protocol.NewNonce()
+		if err != nil {
+			return nil, err
+		}
+		if creds.Key != nil {
+			sig := auth.Sign(creds.Key, serverNonce, clientNonce)
+			return protocol.EncodeAuthPublicKey(creds.Key.Public().(ed25519.PublicKey), clientNonce, sig), nil
+		}
+		response := protocol.TokenResponse(creds.Token, serverNonce, clientNonce)
+		return protocol.EncodeAuthResponse(clientNonce, response), nil
+	}
+
+	if creds.Key != nil {
+		return nil, fmt.Errorf("server does not support public key auth")
+	}
+
+	token := creds.Token
+	if !tlsEnabled && token != "" {
+		return nil, fmt.Errorf("server did not offer challenge-response auth; refusing to send token without TLS")
+	}
+	return protocol.EncodeAuth(token), nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+var ErrPinMismatch = errors.New("server certificate does not match any pinned key")
+
+type TLSConfig struct {
+	Enabled  bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the name verified against the server
+	// certificate. It defaults to the host part of the server address.
+	ServerName string
+	Pins       []string
+	Insecure   bool
+}
+
+// ParsePin decodes an SPKI pin in the "sha256/<base64>" form used by HPKP
+// and most certificate tooling.
+func ParsePin(pin string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(pin, "sha256/")
+	if !ok {
+		return nil, fmt.Errorf("invalid pin %q: expected sha256/<base64>", pin)
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid pin %q: not a base64 SHA-256 digest", pin)
+	}
+	return hash, nil
+}
+
+func SPKIPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func (c TLSConfig) clientConfig(serverAddr string) (*tls.Config, error) {
+	serverName := c.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(serverAddr)
+		if err != nil {
+			host = serverAddr
+		}
+		serverName = host
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+
+	if c.CAFile != "" {
+		caCert, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA cert: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA cert")
+		}
+	}
+
+	config := &tls.Config{
+		RootCAs:            roots,
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(c.Pins) > 0 {
+		pins := make([][]byte, 0, len(c.Pins))
+		for _, pin := range c.Pins {
+			hash, err := ParsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, hash)
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			chains := cs.VerifiedChains
+			if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+				// Nothing was verified (--tls-insecure), so only the
+				// leaf's own key can be trusted.
+				chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+			}
+			return verifyPins(chains, pins)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyPins accepts the connection if a certificate in one of the verified
+// chains matches a pin, so pinning the leaf, an intermediate or the root
+// works. Extra certificates the server sent outside those chains are
+// ignored, since anyone can append them.
+func verifyPins(chains [][]*x509.Certificate, pins [][]byte) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(hash[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrPinMismatch
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/pki"
+)
+
+func TestParsePin(t *testing.T) {
+	cert := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("server-key")}
+
+	pin, err := ParsePin(SPKIPin(cert))
+	if err != nil {
+		t.Fatalf("parse pin: %v", err)
+	}
+	if err := verifyPins([][]*x509.Certificate{{cert}}, [][]byte{pin}); err != nil {
+		t.Fatalf("expected pin to match: %v", err)
+	}
+
+	other := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("other-key")}
+	if err := verifyPins([][]*x509.Certificate{{other}}, [][]byte{pin}); err != ErrPinMismatch {
+		t.Fatalf("expected ErrPinMismatch, got %v", err)
+	}
+
+	for _, bad := range []string{"abc", "sha1/AAAA", "sha256/not-base64", "sha256/AAAA"} {
+		if _, err := ParsePin(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestClientConfigServerName(t *testing.T) {
+	tests := []struct {
+		cfg  TLSConfig
+		addr string
+		want string
+	}{
+		{TLSConfig{}, "tunnel.example.com:9000", "tunnel.example.com"},
+		{TLSConfig{}, "[::1]:9000", "::1"},
+		{TLSConfig{ServerName: "edge.example.com"}, "10.0.0.5:9000", "edge.example.com"},
+	}
+
+	for _, tt := range tests {
+		config, err := tt.cfg.clientConfig(tt.addr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.addr, err)
+		}
+		if config.ServerName != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.addr, config.ServerName, tt.want)
+		}
+	}
+}
+
+func TestPinsIgnoreUnverifiedCertificates(t *testing.T) {
+	ca, err := pki.NewCA("test CA", pki.KeyECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, leafKey, err := ca.Issue(pki.Request{Hosts: []string{"tunnel.test"}, KeyType: pki.KeyECDSA, Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelated, err := pki.NewCA("pinned", pki.KeyECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := pki.WriteFiles(ca.Cert, ca.Key, caFile, filepath.Join(dir, "ca-key.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A valid chain with the pinned certificate appended after it.
+	serverCert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, unrelated.Cert.Raw},
+		PrivateKey:  leafKey,
+	}
+
+	handshake := func(pin string) error {
+		config, err := TLSConfig{CAFile: caFile, ServerName: "tunnel.test", Pins: []string{pin}}.clientConfig("tunnel.test:9000")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		go func() {
+			defer serverConn.Close()
+			tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{serverCert}}).Handshake()
+		}()
+		return tls.Client(clientConn, config).Handshake()
+	}
+
+	if err := handshake(SPKIPin(unrelated.Cert)); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expected ErrPinMismatch for a certificate outside the verified chain, got %v", err)
+	}
+	if err := handshake(SPKIPin(ca.Cert)); err != nil {
+		t.Fatalf("expected the CA pin to match: %v", err)
+	}
+}
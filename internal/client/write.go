@@ -1,26 +1,47 @@
 package client
 
-import (
-	"log"
-
-	"github.com/bakare-dev/gotunnel/internal/tunnel"
-)
+import "log"
 
 func (f *Forwarder) writeToLocal(streamID uint32, data []byte) {
 	f.mu.Lock()
 	conn, ok := f.conns[streamID]
-	httpLog, hasLog := f.httpLogs[streamID]
+	pipeline := f.pipelines[streamID]
 	f.mu.Unlock()
 
 	if !ok {
 		return
 	}
 
-	if hasLog && httpLog.Request == nil {
-		httpLog.Request = tunnel.ParseHTTPRequest(data)
+	data, err := pipeline.Inbound(data)
+	if err != nil {
+		log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", streamID, err)
+		f.closeStream(streamID)
+		f.sendClose(streamID)
+		return
+	}
+	if len(data) == 0 {
+		return
 	}
 
 	if _, err := conn.Write(data); err != nil {
 		log.Printf("│ ERROR │ [Stream %d] Failed to write to local: %v", streamID, err)
 	}
 }
+
+// endStream handles the server closing a stream: data the middlewares
+// still hold is written to the local service before it is disconnected.
+func (f *Forwarder) endStream(streamID uint32) {
+	f.mu.Lock()
+	conn, ok := f.conns[streamID]
+	pipeline := f.pipelines[streamID]
+	f.mu.Unlock()
+
+	if ok {
+		if data, err := pipeline.FlushInbound(); err != nil {
+			log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", streamID, err)
+		} else if len(data) > 0 {
+			conn.Write(data)
+		}
+	}
+	f.closeStream(streamID)
+}
@@ -1 +1,120 @@
 package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bakare-dev/gotunnel/internal/policy"
+	"github.com/bakare-dev/gotunnel/internal/quota"
+	"github.com/bakare-dev/gotunnel/internal/ratelimit"
+)
+
+type Config struct {
+	ListenAddr string          `yaml:"listen_addr"`
+	AdminAddr  string          `yaml:"admin_addr"`
+	WebSocket  WebSocketConfig `yaml:"websocket"`
+	StartPort  int             `yaml:"start_port"`
+	TLS        TLSConfig       `yaml:"tls"`
+	Auth       AuthConfig      `yaml:"auth"`
+	Limits     LimitsConfig    `yaml:"limits"`
+	Access     AccessConfig    `yaml:"access"`
+	RateLimits RateLimitConfig `yaml:"rate_limits"`
+	Quotas     quota.Config    `yaml:"quotas"`
+	PublicTLS  PublicTLSConfig `yaml:"public_tls"`
+	Policies   policy.Config   `yaml:"policies"`
+	Middleware []string        `yaml:"middleware"`
+}
+
+type TLSConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// WebSocketConfig accepts tunnel clients over WebSocket, for networks that
+// only let HTTP(S) out. The listener uses the tls settings when enabled.
+type WebSocketConfig struct {
+	Addr string `yaml:"addr"`
+	Path string `yaml:"path"`
+
+	// TrustedProxies are load balancers whose X-Forwarded-For header is
+	// believed when recording the client address.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type PublicTLSConfig struct {
+	CertDir string `yaml:"cert_dir"`
+	CACert  string `yaml:"ca_cert"`
+	CAKey   string `yaml:"ca_key"`
+
+	// Domains are the host patterns the CA signs for any tunnel; other
+	// names are signed only when a tunnel's host restrictions allow them.
+	Domains []string `yaml:"domains"`
+}
+
+func (c PublicTLSConfig) Enabled() bool {
+	return c.CertDir != "" || c.CACert != ""
+}
+
+type AuthConfig struct {
+	Mode             string        `yaml:"mode"`
+	RequireChallenge bool          `yaml:"require_challenge"`
+	AuthorizedKeys   string        `yaml:"authorized_keys"`
+	TokenTTLMinutes  int           `yaml:"token_ttl_minutes"`
+	Lockout          LockoutConfig `yaml:"lockout"`
+}
+
+type LockoutConfig struct {
+	MaxFailures             int `yaml:"max_failures"`
+	LockoutSeconds          int `yaml:"lockout_seconds"`
+	MaxLockoutSeconds       int `yaml:"max_lockout_seconds"`
+	HandshakeTimeoutSeconds int `yaml:"handshake_timeout_seconds"`
+}
+
+type LimitsConfig struct {
+	MaxConnections           int `yaml:"max_connections"`
+	MaxTunnelDurationMinutes int `yaml:"max_tunnel_duration_minutes"`
+}
+
+type AccessConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+type RateLimitConfig struct {
+	Server ratelimit.Limits            `yaml:"server"`
+	Token  ratelimit.Limits            `yaml:"token"`
+	Tunnel ratelimit.Limits            `yaml:"tunnel"`
+	Tokens map[string]ratelimit.Limits `yaml:"tokens"`
+}
+
+func Default() *Config {
+	return &Config{
+		ListenAddr: ":9000",
+		StartPort:  10000,
+		WebSocket: WebSocketConfig{
+			Path: "/tunnel",
+		},
+		TLS: TLSConfig{
+			CertFile: "certs/server-cert.pem",
+			KeyFile:  "certs/server-key.pem",
+		},
+	}
+}
+
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	cfg := Default()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
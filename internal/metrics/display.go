@@ -45,7 +45,14 @@ func (m *Metrics) Summary() string {
 
 	sb.WriteString(fmt.Sprintf("Active Streams     %d\n", m.GetActiveStreams()))
 	sb.WriteString(fmt.Sprintf("Total Streams      %d\n", m.GetTotalStreams()))
-	sb.WriteString(fmt.Sprintf("Total Connections  %d\n\n", m.TotalConnections))
+	sb.WriteString(fmt.Sprintf("Total Connections  %d\n", m.TotalConnections))
+	if denied := m.GetDeniedConnections(); denied > 0 {
+		sb.WriteString(fmt.Sprintf("Denied Connections %d\n", denied))
+	}
+	if limited := m.GetRateLimited(); limited > 0 {
+		sb.WriteString(fmt.Sprintf("Rate Limited       %d\n", limited))
+	}
+	sb.WriteString("\n")
 
 	sb.WriteString(fmt.Sprintf("Data Sent          %s\n", FormatBytes(sent)))
 	sb.WriteString(fmt.Sprintf("Data Received      %s\n", FormatBytes(recv)))
@@ -65,6 +72,27 @@ func (m *Metrics) Summary() string {
 			}
 			sb.WriteString("\n")
 		}
+
+		grpc := m.GetGRPCStatusCounts()
+		if len(grpc) > 0 {
+			sb.WriteString("gRPC Status\n")
+			for code, count := range grpc {
+				sb.WriteString(fmt.Sprintf("  %d: %d calls\n", code, count))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	if commands, errors, avg := m.GetCommandStats(); commands > 0 {
+		sb.WriteString(fmt.Sprintf("Commands           %d\n", commands))
+		sb.WriteString(fmt.Sprintf("Command Errors     %d\n", errors))
+		sb.WriteString(fmt.Sprintf("Avg Command Time   %dms\n\n", avg.Milliseconds()))
+
+		sb.WriteString("Command Types\n")
+		for name, count := range m.GetCommandCounts() {
+			sb.WriteString(fmt.Sprintf("  %s: %d\n", name, count))
+		}
+		sb.WriteString("\n")
 	}
 
 	sb.WriteString(fmt.Sprintf("Uptime             %s\n", FormatUptime(uptime)))
@@ -8,9 +8,11 @@ import (
 type Metrics struct {
 	mu sync.RWMutex
 
-	TotalConnections int64
-	ActiveStreams    int
-	TotalStreams     int64
+	TotalConnections  int64
+	ActiveStreams     int
+	TotalStreams      int64
+	DeniedConnections int64
+	RateLimited       int64
 
 	BytesSent     int64
 	BytesReceived int64
@@ -21,12 +23,21 @@ type Metrics struct {
 	MinLatency         time.Duration
 	MaxLatency         time.Duration
 
+	GRPCCallsByStatus map[int]int64
+
+	Commands       int64
+	CommandsByName map[string]int64
+	CommandErrors  int64
+	CommandLatency time.Duration
+
 	SessionStart time.Time
 }
 
 func New() *Metrics {
 	return &Metrics{
 		HTTPRequestsByCode: make(map[int]int64),
+		GRPCCallsByStatus:  make(map[int]int64),
+		CommandsByName:     make(map[string]int64),
 		SessionStart:       time.Now(),
 		MinLatency:         time.Duration(1<<63 - 1),
 	}
@@ -49,6 +60,18 @@ func (m *Metrics) StreamClosed() {
 	}
 }
 
+func (m *Metrics) RecordDenied() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.DeniedConnections++
+}
+
+func (m *Metrics) RecordRateLimited() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.RateLimited++
+}
+
 func (m *Metrics) AddBytesSent(n int64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -77,6 +100,26 @@ func (m *Metrics) RecordHTTPRequest(statusCode int, latency time.Duration) {
 	}
 }
 
+func (m *Metrics) RecordGRPCStatus(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.GRPCCallsByStatus[code]++
+}
+
+// RecordCommand counts a database or cache command seen by a stream
+// decoder, keyed by protocol and statement type.
+func (m *Metrics) RecordCommand(protocol, name string, latency time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Commands++
+	m.CommandsByName[protocol+" "+name]++
+	m.CommandLatency += latency
+	if failed {
+		m.CommandErrors++
+	}
+}
+
 func (m *Metrics) GetActiveStreams() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -89,6 +132,18 @@ func (m *Metrics) GetTotalStreams() int64 {
 	return m.TotalStreams
 }
 
+func (m *Metrics) GetDeniedConnections() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.DeniedConnections
+}
+
+func (m *Metrics) GetRateLimited() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.RateLimited
+}
+
 func (m *Metrics) GetBandwidth() (sent, received int64) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -121,3 +176,35 @@ func (m *Metrics) GetStatusCodeCounts() map[int]int64 {
 	}
 	return counts
 }
+
+func (m *Metrics) GetGRPCStatusCounts() map[int]int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[int]int64)
+	for code, count := range m.GRPCCallsByStatus {
+		counts[code] = count
+	}
+	return counts
+}
+
+func (m *Metrics) GetCommandStats() (total, errors int64, avg time.Duration) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.Commands == 0 {
+		return 0, 0, 0
+	}
+	return m.Commands, m.CommandErrors, m.CommandLatency / time.Duration(m.Commands)
+}
+
+func (m *Metrics) GetCommandCounts() map[string]int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for name, count := range m.CommandsByName {
+		counts[name] = count
+	}
+	return counts
+}
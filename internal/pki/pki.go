@@ -0,0 +1,242 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type KeyType string
+
+const (
+	KeyECDSA   KeyType = "ecdsa"
+	KeyEd25519 KeyType = "ed25519"
+)
+
+var ErrNotCA = errors.New("certificate is not a CA")
+
+func ParseKeyType(s string) (KeyType, error) {
+	switch KeyType(strings.ToLower(s)) {
+	case "", KeyECDSA:
+		return KeyECDSA, nil
+	case KeyEd25519:
+		return KeyEd25519, nil
+	}
+	return "", fmt.Errorf("unknown key type %q (want ecdsa or ed25519)", s)
+}
+
+func GenerateKey(kt KeyType) (crypto.Signer, error) {
+	switch kt {
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+}
+
+type Authority struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+func NewCA(name string, kt KeyType, validity time.Duration) (*Authority, error) {
+	key, err := GenerateKey(kt)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"GoTunnel"}, CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, Key: key}, nil
+}
+
+func LoadCA(certFile, keyFile string) (*Authority, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA cert: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, ErrNotCA
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found in %s", keyFile)
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authority{Cert: cert, Key: key}, nil
+}
+
+// Request describes a leaf certificate. Hosts may mix DNS names, IP
+// addresses, URIs (e.g. spiffe://) and email addresses; each lands in the
+// matching SAN field.
+type Request struct {
+	CommonName string
+	Hosts      []string
+	Client     bool
+	KeyType    KeyType
+	Validity   time.Duration
+}
+
+func (a *Authority) Issue(req Request) (*x509.Certificate, crypto.Signer, error) {
+	key, err := GenerateKey(req.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commonName := req.CommonName
+	if commonName == "" && len(req.Hosts) > 0 {
+		commonName = req.Hosts[0]
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"GoTunnel"}, CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(req.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if req.Client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	for _, host := range req.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if u, err := url.Parse(host); err == nil && u.Scheme != "" && u.Host != "" {
+			template.URIs = append(template.URIs, u)
+		} else if strings.Contains(host, "@") {
+			template.EmailAddresses = append(template.EmailAddresses, host)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Cert, key.Public(), a.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// WriteFiles stores a certificate (0644) and its private key (0600). Keys
+// are written in PKCS#8 so ECDSA and Ed25519 share one format.
+func WriteFiles(cert *x509.Certificate, key crypto.Signer, certFile, keyFile string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := writeFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return writeFile(certFile, certPEM, 0644)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file; force it so a re-issued
+	// key never ends up world-readable.
+	return os.Chmod(path, perm)
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var (
+		key any
+		err error
+	)
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
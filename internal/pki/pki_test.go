@@ -0,0 +1,90 @@
+package pki
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIssueChainsToCA(t *testing.T) {
+	for _, kt := range []KeyType{KeyECDSA, KeyEd25519} {
+		ca, err := NewCA("Test CA", kt, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: new CA: %v", kt, err)
+		}
+
+		cert, _, err := ca.Issue(Request{
+			Hosts:    []string{"tunnel.example.com", "127.0.0.1", "spiffe://example.org/agent", "ops@example.org"},
+			KeyType:  kt,
+			Validity: time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("%s: issue: %v", kt, err)
+		}
+
+		if len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 1 || len(cert.URIs) != 1 || len(cert.EmailAddresses) != 1 {
+			t.Fatalf("%s: unexpected SANs: %v %v %v %v", kt, cert.DNSNames, cert.IPAddresses, cert.URIs, cert.EmailAddresses)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Cert)
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName:   "tunnel.example.com",
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			t.Fatalf("%s: verify: %v", kt, err)
+		}
+	}
+}
+
+func TestClientCertUsage(t *testing.T) {
+	ca, _ := NewCA("Test CA", KeyECDSA, time.Hour)
+
+	cert, _, err := ca.Issue(Request{CommonName: "agent-1", Client: true, Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Fatalf("client verify: %v", err)
+	}
+
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if _, err := cert.Verify(opts); err == nil {
+		t.Fatal("client certificate must not verify for server auth")
+	}
+}
+
+func TestWriteAndLoadCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca-cert.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+
+	ca, _ := NewCA("Test CA", KeyEd25519, time.Hour)
+	if err := WriteFiles(ca.Cert, ca.Key, certFile, keyFile); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected key mode 0600, got %v", info.Mode().Perm())
+	}
+
+	loaded, err := LoadCA(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !loaded.Cert.Equal(ca.Cert) {
+		t.Fatal("loaded CA differs from written CA")
+	}
+}
@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bakare-dev/gotunnel/internal/auth"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/tunnel"
+)
+
+// Policy limits what an authenticated client may bind. Zero values mean no
+// restriction.
+type Policy struct {
+	Ports             []string `yaml:"ports"`
+	Hosts             []string `yaml:"hosts"`
+	MaxTunnels        int      `yaml:"max_tunnels"`
+	MaxStreams        int      `yaml:"max_streams"`
+	Protocols         []string `yaml:"protocols"`
+	RequireAccessList bool     `yaml:"require_access_list"`
+	RequirePublicAuth bool     `yaml:"require_public_auth"`
+	Middleware        []string `yaml:"middleware"`
+}
+
+// Config maps client identities (token, certificate identity or key
+// fingerprint) to policies, with Default for everyone else.
+type Config struct {
+	Default Policy            `yaml:"default"`
+	Tokens  map[string]Policy `yaml:"tokens"`
+}
+
+func (c Config) For(identity string) Policy {
+	if p, ok := c.Tokens[identity]; ok {
+		return p
+	}
+	return c.Default
+}
+
+func (c Config) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return fmt.Errorf("default policy: %w", err)
+	}
+	for identity, p := range c.Tokens {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policy for %s: %w", maskIdentity(identity), err)
+		}
+	}
+	return nil
+}
+
+func (p Policy) Validate() error {
+	if _, err := p.portRanges(); err != nil {
+		return err
+	}
+	for _, proto := range p.Protocols {
+		switch proto {
+		case protocol.ProtoTCP, protocol.ProtoHTTP:
+		case protocol.ProtoUDP:
+			return fmt.Errorf("udp tunnels are not supported by this server")
+		default:
+			return fmt.Errorf("unknown protocol %q (use tcp or http)", proto)
+		}
+	}
+	if _, err := tunnel.LookupMiddlewares(p.Middleware); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CheckBind evaluates a bind request. active is the number of tunnels the
+// identity already holds. Violations are returned as *protocol.BindError.
+func (p Policy) CheckBind(bind protocol.BindOptions, active int) error {
+	ranges, err := p.portRanges()
+	if err != nil {
+		return err
+	}
+
+	if len(ranges) > 0 {
+		allowed := auth.Options{Ports: ranges}
+		if bind.Port == 0 {
+			return &protocol.BindError{
+				Code:    protocol.CodePortNotAllowed,
+				Message: "a port must be requested with --port (allowed: " + strings.Join(p.Ports, ", ") + ")",
+			}
+		}
+		if !allowed.AllowsPort(int(bind.Port)) {
+			return &protocol.BindError{
+				Code:    protocol.CodePortNotAllowed,
+				Message: fmt.Sprintf("port %d is outside the allowed ports %s", bind.Port, strings.Join(p.Ports, ", ")),
+			}
+		}
+	}
+
+	proto := bind.TunnelProtocol()
+	if len(p.Protocols) > 0 && !slices.Contains(p.Protocols, proto) {
+		return &protocol.BindError{
+			Code:    protocol.CodeProtocolNotAllowed,
+			Message: fmt.Sprintf("%s tunnels are not allowed (allowed: %s)", proto, strings.Join(p.Protocols, ", ")),
+		}
+	}
+
+	if len(p.Hosts) > 0 && !bind.HTTPS {
+		return &protocol.BindError{
+			Code:    protocol.CodeHostNotAllowed,
+			Message: "this client is limited to hostnames " + strings.Join(p.Hosts, ", ") + "; request an --https tunnel",
+		}
+	}
+
+	if p.MaxTunnels > 0 && active >= p.MaxTunnels {
+		return &protocol.BindError{
+			Code:    protocol.CodeTooManyTunnels,
+			Message: fmt.Sprintf("limit of %d concurrent tunnels reached", p.MaxTunnels),
+		}
+	}
+
+	if p.RequireAccessList && len(bind.AllowCIDRs) == 0 {
+		return &protocol.BindError{
+			Code:    protocol.CodeAccessListRequired,
+			Message: "an IP allow list is required (--allow-cidr)",
+		}
+	}
+
+	if p.RequirePublicAuth && bind.BasicAuth == "" && bind.BearerToken == "" {
+		return &protocol.BindError{
+			Code:    protocol.CodePublicAuthRequired,
+			Message: "public endpoint authentication is required (--basic-auth or --bearer-token)",
+		}
+	}
+
+	return nil
+}
+
+func (p Policy) AllowsHost(name string) bool {
+	return len(p.Hosts) == 0 || auth.MatchHost(p.Hosts, name)
+}
+
+func (p Policy) portRanges() ([]auth.PortRange, error) {
+	ranges := make([]auth.PortRange, 0, len(p.Ports))
+	for _, entry := range p.Ports {
+		r, err := auth.ParsePortRange(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func maskIdentity(identity string) string {
+	if len(identity) <= 8 {
+		return "****"
+	}
+	return identity[:4] + "****"
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+)
+
+func TestCheckBind(t *testing.T) {
+	p := Policy{
+		Ports:             []string{"10080", "10100-10110"},
+		MaxTunnels:        2,
+		Protocols:         []string{protocol.ProtoHTTP},
+		RequireAccessList: true,
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	ok := protocol.BindOptions{Port: 10105, Protocol: protocol.ProtoHTTP, AllowCIDRs: []string{"10.0.0.0/8"}}
+	if err := p.CheckBind(ok, 1); err != nil {
+		t.Fatalf("expected bind to pass, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(b *protocol.BindOptions)
+		active int
+		code   protocol.ErrorCode
+	}{
+		{"no port", func(b *protocol.BindOptions) { b.Port = 0 }, 0, protocol.CodePortNotAllowed},
+		{"port outside ranges", func(b *protocol.BindOptions) { b.Port = 9999 }, 0, protocol.CodePortNotAllowed},
+		{"tcp tunnel", func(b *protocol.BindOptions) { b.Protocol = "" }, 0, protocol.CodeProtocolNotAllowed},
+		{"too many tunnels", func(b *protocol.BindOptions) {}, 2, protocol.CodeTooManyTunnels},
+		{"no access list", func(b *protocol.BindOptions) { b.AllowCIDRs = nil }, 0, protocol.CodeAccessListRequired},
+	}
+
+	for _, tt := range tests {
+		bind := ok
+		tt.mutate(&bind)
+
+		var bindErr *protocol.BindError
+		err := p.CheckBind(bind, tt.active)
+		if !errors.As(err, &bindErr) || bindErr.Code != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.name, tt.code, err)
+		}
+	}
+}
+
+func TestCheckBindHostsAndPublicAuth(t *testing.T) {
+	p := Policy{Hosts: []string{"*.dev.example.com"}, RequirePublicAuth: true}
+
+	var bindErr *protocol.BindError
+	if err := p.CheckBind(protocol.BindOptions{BearerToken: "s3cret"}, 0); !errors.As(err, &bindErr) || bindErr.Code != protocol.CodeHostNotAllowed {
+		t.Fatalf("expected host_not_allowed for a plain tunnel, got %v", err)
+	}
+	if err := p.CheckBind(protocol.BindOptions{HTTPS: true}, 0); !errors.As(err, &bindErr) || bindErr.Code != protocol.CodePublicAuthRequired {
+		t.Fatalf("expected public_auth_required, got %v", err)
+	}
+	if err := p.CheckBind(protocol.BindOptions{HTTPS: true, BasicAuth: "user:pass"}, 0); err != nil {
+		t.Fatalf("expected bind to pass, got %v", err)
+	}
+
+	if !p.AllowsHost("app.dev.example.com") || p.AllowsHost("example.com") {
+		t.Fatalf("unexpected host matching: %v", p.Hosts)
+	}
+}
+
+func TestConfigFor(t *testing.T) {
+	cfg := Config{
+		Default: Policy{MaxTunnels: 1},
+		Tokens:  map[string]Policy{"ci-token": {MaxTunnels: 5}},
+	}
+
+	if got := cfg.For("ci-token").MaxTunnels; got != 5 {
+		t.Fatalf("expected token policy, got max_tunnels %d", got)
+	}
+	if got := cfg.For("other").MaxTunnels; got != 1 {
+		t.Fatalf("expected default policy, got max_tunnels %d", got)
+	}
+}
+
+func TestValidateRejectsBadPolicies(t *testing.T) {
+	for _, p := range []Policy{
+		{Ports: []string{"abc"}},
+		{Ports: []string{"20-10"}},
+		{Protocols: []string{"quic"}},
+		{Protocols: []string{"udp"}},
+		{Middleware: []string{"no-such-middleware"}},
+	} {
+		if err := (Config{Tokens: map[string]Policy{"token-1234": p}}).Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", p)
+		}
+	}
+}
@@ -1,17 +1,125 @@
 package protocol
 
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+type AuthMode uint8
+
+const (
+	AuthToken AuthMode = iota
+	AuthCert
+	AuthCertAndToken
+)
+
+func ParseAuthMode(s string) (AuthMode, error) {
+	switch s {
+	case "", "token":
+		return AuthToken, nil
+	case "cert":
+		return AuthCert, nil
+	case "cert+token":
+		return AuthCertAndToken, nil
+	}
+	return AuthToken, fmt.Errorf("unknown auth mode %q (use token, cert or cert+token)", s)
+}
+
+// Markers for the binary auth forms. Plaintext tokens never begin with a
+// control byte, so all forms share MsgAuth.
+const (
+	authChallengeMarker = 0x00
+	authPublicKeyMarker = 0x01
+)
+
+// KeyVerifier checks that pub is authorized and signed the auth nonces,
+// returning the identity of the key.
+type KeyVerifier func(pub ed25519.PublicKey, serverNonce, clientNonce, sig []byte) (string, error)
+
 type Auth struct {
 	Token string
+
+	ClientNonce []byte
+	Response    []byte
+
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+func (a *Auth) IsChallengeResponse() bool {
+	return a.Response != nil
+}
+
+func (a *Auth) IsPublicKey() bool {
+	return a.PublicKey != nil
 }
 
 func EncodeAuth(token string) []byte {
 	return []byte(token)
 }
 
+func EncodeAuthResponse(clientNonce, response []byte) []byte {
+	buf := make([]byte, 0, 1+len(clientNonce)+len(response))
+	buf = append(buf, authChallengeMarker)
+	buf = append(buf, clientNonce...)
+	return append(buf, response...)
+}
+
+func EncodeAuthPublicKey(pub ed25519.PublicKey, clientNonce, sig []byte) []byte {
+	buf := make([]byte, 0, 1+len(pub)+len(clientNonce)+len(sig))
+	buf = append(buf, authPublicKeyMarker)
+	buf = append(buf, pub...)
+	buf = append(buf, clientNonce...)
+	return append(buf, sig...)
+}
+
 func DecodeAuth(payload []byte) (*Auth, error) {
 	if len(payload) == 0 {
 		return nil, ErrInvalidLength
 	}
 
+	if payload[0] == authPublicKeyMarker {
+		if len(payload) != 1+ed25519.PublicKeySize+NonceSize+ed25519.SignatureSize {
+			return nil, ErrInvalidLength
+		}
+		payload = payload[1:]
+		return &Auth{
+			PublicKey:   ed25519.PublicKey(payload[:ed25519.PublicKeySize]),
+			ClientNonce: payload[ed25519.PublicKeySize : ed25519.PublicKeySize+NonceSize],
+			Signature:   payload[ed25519.PublicKeySize+NonceSize:],
+		}, nil
+	}
+
+	if payload[0] == authChallengeMarker {
+		if len(payload) != 1+NonceSize+sha256.Size {
+			return nil, ErrInvalidLength
+		}
+		return &Auth{
+			ClientNonce: payload[1 : 1+NonceSize],
+			Response:    payload[1+NonceSize:],
+		}, nil
+	}
+
 	return &Auth{Token: string(payload)}, nil
 }
+
+// AuthSubject names the credential an auth payload claims so failures can
+// be counted against it. Challenge responses do not name their token, and
+// public keys are public, so counting failures against a key would let
+// anyone lock its holder out; both return "".
+func AuthSubject(payload []byte) string {
+	msg, err := DecodeAuth(payload)
+	if err != nil || msg.IsChallengeResponse() || msg.IsPublicKey() {
+		return ""
+	}
+	return TokenSubject(msg.Token)
+}
+
+// TokenSubject names a token for failure counting. Tokens are hashed to
+// keep them out of memory and logs.
+func TokenSubject(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:8])
+}
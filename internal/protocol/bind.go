@@ -0,0 +1,142 @@
+package protocol
+
+import "encoding/binary"
+
+type BindOption uint8
+
+const (
+	OptPort BindOption = iota + 1
+	OptStandby
+	OptAllowCIDR
+	OptDenyCIDR
+	OptBasicAuth
+	OptBearerToken
+	OptHTTPS
+	OptProtocol
+	OptJoin
+	OptHTTP2
+)
+
+const (
+	ProtoTCP  = "tcp"
+	ProtoHTTP = "http"
+	ProtoUDP  = "udp"
+)
+
+type BindOptions struct {
+	Port       uint16
+	Standby    bool
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	BasicAuth   string
+	BearerToken string
+	HTTPS       bool
+	Protocol    string
+
+	// HTTP2 says the local service speaks HTTP/2 without TLS (h2c), so
+	// HTTPS visitors may negotiate h2.
+	HTTP2 bool
+
+	// Join is an encoded JoinTicket. A connection that carries one becomes
+	// an extra lane of an existing session instead of a new tunnel.
+	Join []byte
+}
+
+// TunnelProtocol is the declared tunnel type. HTTPS tunnels always carry
+// HTTP; anything unspecified is raw TCP.
+func (b *BindOptions) TunnelProtocol() string {
+	switch {
+	case b.HTTPS:
+		return ProtoHTTP
+	case b.Protocol == "":
+		return ProtoTCP
+	}
+	return b.Protocol
+}
+
+func (b *BindOptions) Encode() []byte {
+	var buf []byte
+
+	if b.Port != 0 {
+		buf = appendOption(buf, OptPort, EncodeUint16(b.Port))
+	}
+	if b.Standby {
+		buf = appendOption(buf, OptStandby, []byte{1})
+	}
+	for _, cidr := range b.AllowCIDRs {
+		buf = appendOption(buf, OptAllowCIDR, []byte(cidr))
+	}
+	for _, cidr := range b.DenyCIDRs {
+		buf = appendOption(buf, OptDenyCIDR, []byte(cidr))
+	}
+	if b.BasicAuth != "" {
+		buf = appendOption(buf, OptBasicAuth, []byte(b.BasicAuth))
+	}
+	if b.BearerToken != "" {
+		buf = appendOption(buf, OptBearerToken, []byte(b.BearerToken))
+	}
+	if b.HTTPS {
+		buf = appendOption(buf, OptHTTPS, []byte{1})
+	}
+	if b.Protocol != "" {
+		buf = appendOption(buf, OptProtocol, []byte(b.Protocol))
+	}
+	if len(b.Join) > 0 {
+		buf = appendOption(buf, OptJoin, b.Join)
+	}
+	if b.HTTP2 {
+		buf = appendOption(buf, OptHTTP2, []byte{1})
+	}
+
+	return buf
+}
+
+func DecodeBindOptions(payload []byte) (*BindOptions, error) {
+	b := &BindOptions{}
+
+	for len(payload) > 0 {
+		if len(payload) < 3 {
+			return nil, ErrInvalidLength
+		}
+
+		opt := BindOption(payload[0])
+		n := int(binary.BigEndian.Uint16(payload[1:]))
+		if len(payload) < 3+n {
+			return nil, ErrInvalidLength
+		}
+		value := payload[3 : 3+n]
+		payload = payload[3+n:]
+
+		switch opt {
+		case OptPort:
+			b.Port = DecodeUint16(value)
+		case OptStandby:
+			b.Standby = len(value) > 0 && value[0] != 0
+		case OptAllowCIDR:
+			b.AllowCIDRs = append(b.AllowCIDRs, string(value))
+		case OptDenyCIDR:
+			b.DenyCIDRs = append(b.DenyCIDRs, string(value))
+		case OptBasicAuth:
+			b.BasicAuth = string(value)
+		case OptBearerToken:
+			b.BearerToken = string(value)
+		case OptHTTPS:
+			b.HTTPS = len(value) > 0 && value[0] != 0
+		case OptProtocol:
+			b.Protocol = string(value)
+		case OptJoin:
+			b.Join = append([]byte(nil), value...)
+		case OptHTTP2:
+			b.HTTP2 = len(value) > 0 && value[0] != 0
+		}
+	}
+
+	return b, nil
+}
+
+func appendOption(buf []byte, opt BindOption, value []byte) []byte {
+	buf = append(buf, byte(opt))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
@@ -0,0 +1,60 @@
+package protocol
+
+import "strings"
+
+type ErrorCode string
+
+const (
+	CodeBindRejected       ErrorCode = "bind_rejected"
+	CodePortInUse          ErrorCode = "port_in_use"
+	CodePortNotAllowed     ErrorCode = "port_not_allowed"
+	CodeHostNotAllowed     ErrorCode = "host_not_allowed"
+	CodeTooManyTunnels     ErrorCode = "too_many_tunnels"
+	CodeProtocolNotAllowed ErrorCode = "protocol_not_allowed"
+	CodeAccessListRequired ErrorCode = "access_list_required"
+	CodePublicAuthRequired ErrorCode = "public_auth_required"
+	CodeTLSUnavailable     ErrorCode = "tls_unavailable"
+	CodeQuotaExceeded      ErrorCode = "quota_exceeded"
+	CodeJoinRejected       ErrorCode = "join_rejected"
+)
+
+// BindError is sent in MsgError when the server refuses a bind. It travels
+// as "code: message" so older clients still print something readable.
+type BindError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *BindError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// Retryable reports whether the same request may succeed later without the
+// client changing anything.
+func (e *BindError) Retryable() bool {
+	switch e.Code {
+	case CodePortInUse, CodeTooManyTunnels, CodeQuotaExceeded:
+		return true
+	}
+	return false
+}
+
+func DecodeBindError(payload []byte) *BindError {
+	code, message, ok := strings.Cut(string(payload), ": ")
+	if !ok || !isErrorCode(code) {
+		return &BindError{Code: CodeBindRejected, Message: string(payload)}
+	}
+	return &BindError{Code: ErrorCode(code), Message: message}
+}
+
+func isErrorCode(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+package protocol
+
+import "testing"
+
+func TestDecodeBindError(t *testing.T) {
+	sent := &BindError{Code: CodePortNotAllowed, Message: "port 9999: not allowed"}
+
+	got := DecodeBindError([]byte(sent.Error()))
+	if *got != *sent {
+		t.Fatalf("round trip mismatch: %+v", got)
+	}
+
+	legacy := DecodeBindError([]byte("Port 10080 is already in use"))
+	if legacy.Code != CodeBindRejected || legacy.Message != "Port 10080 is already in use" {
+		t.Fatalf("unexpected legacy decode: %+v", legacy)
+	}
+}
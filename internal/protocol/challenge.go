@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+const NonceSize = 32
+
+const challengeLabel = "gotunnel-auth-v1"
+
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// TokenResponse proves knowledge of a token without revealing it. The
+// server nonce is fresh for every connection, so a captured response is
+// useless on any other session; the client nonce keeps a malicious server
+// from choosing the whole MAC input.
+func TokenResponse(token string, serverNonce, clientNonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(challengeLabel))
+	mac.Write(serverNonce)
+	mac.Write(clientNonce)
+	return mac.Sum(nil)
+}
+
+func VerifyTokenResponse(token string, serverNonce, clientNonce, response []byte) bool {
+	return hmac.Equal(TokenResponse(token, serverNonce, clientNonce), response)
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/bakare-dev/gotunnel/internal/auth"
+)
+
+func authFrame(payload []byte) *Frame {
+	return &Frame{Version: ProtocolVersion1, Type: MsgAuth, Payload: payload}
+}
+
+func TestChallengeResponseAuth(t *testing.T) {
+	sess := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer sess.Close()
+	sess.state = StateHandshaken
+
+	serverNonce, err := sess.IssueChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientNonce, _ := NewNonce()
+
+	payload := EncodeAuthResponse(clientNonce, TokenResponse("dev-token", serverNonce, clientNonce))
+	if bytes.Contains(payload, []byte("dev-token")) {
+		t.Fatal("token must not appear in the auth payload")
+	}
+
+	if err := sess.ProcessAuth(authFrame(payload)); err != nil {
+		t.Fatalf("auth failed: %v", err)
+	}
+	if sess.Token != "dev-token" {
+		t.Fatalf("expected matched token, got %q", sess.Token)
+	}
+}
+
+func TestChallengeResponseRejectsReplayAndWrongToken(t *testing.T) {
+	clientNonce, _ := NewNonce()
+
+	first := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer first.Close()
+	first.state = StateHandshaken
+	firstNonce, _ := first.IssueChallenge()
+	captured := EncodeAuthResponse(clientNonce, TokenResponse("dev-token", firstNonce, clientNonce))
+
+	// The same response on a new session fails because the nonce differs.
+	replay := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer replay.Close()
+	replay.state = StateHandshaken
+	replay.IssueChallenge()
+	if err := replay.ProcessAuth(authFrame(captured)); err != ErrAuthFailed {
+		t.Fatalf("expected replay to fail, got %v", err)
+	}
+
+	wrong := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer wrong.Close()
+	wrong.state = StateHandshaken
+	nonce, _ := wrong.IssueChallenge()
+	if err := wrong.ProcessAuth(authFrame(EncodeAuthResponse(clientNonce, TokenResponse("guess", nonce, clientNonce)))); err != ErrAuthFailed {
+		t.Fatalf("expected wrong token to fail, got %v", err)
+	}
+}
+
+func TestRequireChallengeRejectsPlaintext(t *testing.T) {
+	sess := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer sess.Close()
+	sess.state = StateHandshaken
+	sess.RequireChallenge = true
+
+	if err := sess.ProcessAuth(authFrame(EncodeAuth("dev-token"))); err != ErrChallengeRequired {
+		t.Fatalf("expected ErrChallengeRequired, got %v", err)
+	}
+}
+
+func TestPublicKeyAuth(t *testing.T) {
+	pub, priv, _ := auth.GenerateKey()
+	verify := func(key ed25519.PublicKey, serverNonce, clientNonce, sig []byte) (string, error) {
+		if !key.Equal(pub) || !auth.Verify(key, serverNonce, clientNonce, sig) {
+			return "", ErrAuthFailed
+		}
+		return auth.Fingerprint(key), nil
+	}
+
+	sess := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer sess.Close()
+	sess.state = StateHandshaken
+	sess.VerifyKey = verify
+
+	serverNonce, _ := sess.IssueChallenge()
+	clientNonce, _ := NewNonce()
+	payload := EncodeAuthPublicKey(pub, clientNonce, auth.Sign(priv, serverNonce, clientNonce))
+
+	if err := sess.ProcessAuth(authFrame(payload)); err != nil {
+		t.Fatalf("auth failed: %v", err)
+	}
+	if sess.Identity != auth.Fingerprint(pub) {
+		t.Fatalf("expected key identity, got %q", sess.Identity)
+	}
+
+	_, stranger, _ := auth.GenerateKey()
+	other := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer other.Close()
+	other.state = StateHandshaken
+	other.VerifyKey = verify
+	nonce, _ := other.IssueChallenge()
+	payload = EncodeAuthPublicKey(stranger.Public().(ed25519.PublicKey), clientNonce, auth.Sign(stranger, nonce, clientNonce))
+	if err := other.ProcessAuth(authFrame(payload)); err != ErrAuthFailed {
+		t.Fatalf("expected unknown key to fail, got %v", err)
+	}
+}
+
+func TestAuthSubjects(t *testing.T) {
+	if got := AuthSubject(EncodeAuth("dev-token")); got != TokenSubject("dev-token") {
+		t.Fatalf("expected the token subject, got %q", got)
+	}
+
+	pub, priv, _ := auth.GenerateKey()
+	nonce, _ := NewNonce()
+	if got := AuthSubject(EncodeAuthPublicKey(pub, nonce, auth.Sign(priv, nonce, nonce))); got != "" {
+		t.Fatalf("public keys must not be lockout subjects, got %q", got)
+	}
+
+	// A proven token is named even though cert auth then fails.
+	sess := NewSession(new(bytes.Buffer), new(bytes.Buffer))
+	defer sess.Close()
+	sess.state = StateHandshaken
+	sess.AuthMode = AuthCertAndToken
+
+	serverNonce, _ := sess.IssueChallenge()
+	payload := EncodeAuthResponse(nonce, TokenResponse("dev-token", serverNonce, nonce))
+	if AuthSubject(payload) != "" {
+		t.Fatal("challenge responses do not name their token up front")
+	}
+	if err := sess.ProcessAuth(authFrame(payload)); err != ErrAuthFailed {
+		t.Fatalf("expected auth without a certificate to fail, got %v", err)
+	}
+	if got := sess.MatchedTokenSubject(); got != TokenSubject("dev-token") {
+		t.Fatalf("expected the matched token subject, got %q", got)
+	}
+}
@@ -12,5 +12,7 @@ var (
 	ErrAuthRequired      = errors.New("protocol: authentication required")
 	ErrIncompatiblePeers = errors.New("protocol: incompatible peer capabilities")
 	ErrAuthFailed        = errors.New("protocol: authentication failed")
+	ErrKeyExpired        = errors.New("protocol: public key has expired")
+	ErrChallengeRequired = errors.New("protocol: plaintext token rejected, challenge response required")
 	ErrSessionExpired    = errors.New("protocol: session expired (heartbeat timeout)")
 )
@@ -6,6 +6,7 @@ import (
 )
 
 const (
+	HeaderSize     = 10
 	MaxPayloadSize = 16 * 1024 * 1024
 )
 
@@ -34,64 +35,62 @@ func NewStreamFrame(typ MessageType, streamID uint32, payload []byte) *Frame {
 	}
 }
 
+// AppendHeader appends the 10-byte frame header to dst.
+func (f *Frame) AppendHeader(dst []byte) []byte {
+	dst = append(dst, byte(f.Version), byte(f.Type))
+	dst = binary.BigEndian.AppendUint32(dst, f.StreamID)
+	return binary.BigEndian.AppendUint32(dst, uint32(len(f.Payload)))
+}
+
+// Encode writes the header and payload with two writes and no reflection.
 func (f *Frame) Encode(w io.Writer) error {
-	if err := binary.Write(w, binary.BigEndian, uint8(f.Version)); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.BigEndian, uint8(f.Type)); err != nil {
+	var header [HeaderSize]byte
+	if _, err := w.Write(f.AppendHeader(header[:0])); err != nil {
 		return err
 	}
-	if err := binary.Write(w, binary.BigEndian, f.StreamID); err != nil {
-		return err
+	if len(f.Payload) == 0 {
+		return nil
 	}
-
-	length := uint32(len(f.Payload))
-	if err := binary.Write(w, binary.BigEndian, length); err != nil {
-		return err
-	}
-
 	_, err := w.Write(f.Payload)
 	return err
 }
 
+// DecodeFrame reads one frame. The payload comes from the buffer pool; call
+// Release once it has been consumed to recycle it.
 func DecodeFrame(r io.Reader) (*Frame, error) {
-	var version uint8
-	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
-		return nil, ErrShortHeader
-	}
+	var header [HeaderSize]byte
+	n, err := io.ReadFull(r, header[:])
 
-	if ProtocolVersion(version) != ProtocolVersion1 {
+	// A foreign version is reported even when the header is cut short.
+	if n > 0 && ProtocolVersion(header[0]) != ProtocolVersion1 {
 		return nil, ErrUnsupportedProto
 	}
-
-	var typ uint8
-	if err := binary.Read(r, binary.BigEndian, &typ); err != nil {
-		return nil, ErrShortHeader
-	}
-
-	var streamID uint32
-	if err := binary.Read(r, binary.BigEndian, &streamID); err != nil {
-		return nil, ErrShortHeader
-	}
-
-	var length uint32
-	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+	if err != nil {
 		return nil, ErrShortHeader
 	}
 
+	length := binary.BigEndian.Uint32(header[6:10])
 	if length > MaxPayloadSize {
 		return nil, ErrPayloadTooLarge
 	}
 
-	payload := make([]byte, length)
+	payload := GetBuffer(int(length))
 	if _, err := io.ReadFull(r, payload); err != nil {
+		PutBuffer(payload)
 		return nil, ErrInvalidLength
 	}
 
 	return &Frame{
-		Version:  ProtocolVersion(version),
-		Type:     MessageType(typ),
-		StreamID: streamID,
+		Version:  ProtocolVersion(header[0]),
+		Type:     MessageType(header[1]),
+		StreamID: binary.BigEndian.Uint32(header[2:6]),
 		Payload:  payload,
 	}, nil
 }
+
+// Release returns the payload to the buffer pool. Neither the frame nor its
+// payload may be used afterwards.
+func (f *Frame) Release() {
+	PutBuffer(f.Payload)
+	f.Payload = nil
+}
@@ -3,6 +3,7 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"testing"
 )
 
@@ -96,3 +97,37 @@ func TestDecodeFrameUnsupportedVersion(t *testing.T) {
 		t.Fatalf("expected ErrUnsupportedProto, got %v", err)
 	}
 }
+
+func BenchmarkFrameEncode(b *testing.B) {
+	frame := NewStreamFrame(MsgStreamData, 7, make([]byte, 32*1024))
+
+	b.SetBytes(int64(len(frame.Payload)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := frame.Encode(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFrameDecode(b *testing.B) {
+	var buf bytes.Buffer
+	frame := NewStreamFrame(MsgStreamData, 7, make([]byte, 32*1024))
+	if err := frame.Encode(&buf); err != nil {
+		b.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+
+	b.SetBytes(int64(len(frame.Payload)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		r.Reset(buf.Bytes())
+		decoded, err := DecodeFrame(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		decoded.Release()
+	}
+}
@@ -8,18 +8,20 @@ type Handshake struct {
 	Role         PeerRole
 	Capabilities Capability
 	ExposeAddr   string
+	Bind         BindOptions
 }
 
 func (h *Handshake) Encode() ([]byte, error) {
 	expose := []byte(h.ExposeAddr)
+	opts := h.Bind.Encode()
 
-	buf := make([]byte, 1+8+2+len(expose))
+	buf := make([]byte, 1+8+2+len(expose), 1+8+2+len(expose)+len(opts))
 	buf[0] = byte(h.Role)
 	binary.BigEndian.PutUint64(buf[1:], uint64(h.Capabilities))
 	binary.BigEndian.PutUint16(buf[9:], uint16(len(expose)))
 	copy(buf[11:], expose)
 
-	return buf, nil
+	return append(buf, opts...), nil
 }
 
 func DecodeHandshake(payload []byte) (*Handshake, error) {
@@ -37,10 +39,16 @@ func DecodeHandshake(payload []byte) (*Handshake, error) {
 
 	expose := string(payload[11 : 11+exposeLen])
 
+	bind, err := DecodeBindOptions(payload[11+exposeLen:])
+	if err != nil {
+		return nil, err
+	}
+
 	return &Handshake{
 		Role:         role,
 		Capabilities: caps,
 		ExposeAddr:   expose,
+		Bind:         *bind,
 	}, nil
 }
 
@@ -1,6 +1,9 @@
 package protocol
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestHandshakeEncodeDecode(t *testing.T) {
 	h := &Handshake{
@@ -47,3 +50,33 @@ func TestCapabilityNegotiationFailure(t *testing.T) {
 		t.Fatalf("expected negotiation failure")
 	}
 }
+
+func TestHandshakeBindOptions(t *testing.T) {
+	h := &Handshake{
+		Role:       RoleClient,
+		ExposeAddr: "localhost:3000",
+		Bind: BindOptions{
+			Port:       10080,
+			Standby:    true,
+			AllowCIDRs: []string{"203.0.113.0/24", "198.51.100.7"},
+			DenyCIDRs:  []string{"203.0.113.99/32"},
+		},
+	}
+
+	payload, err := h.Encode()
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	decoded, err := DecodeHandshake(payload)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	if decoded.ExposeAddr != h.ExposeAddr {
+		t.Fatalf("expose addr mismatch")
+	}
+	if !reflect.DeepEqual(decoded.Bind, h.Bind) {
+		t.Fatalf("bind options mismatch: %+v", decoded.Bind)
+	}
+}
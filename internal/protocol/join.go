@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+)
+
+const JoinTicketSize = 16 + 32
+
+var ErrInvalidTicket = errors.New("protocol: invalid join ticket")
+
+// JoinTicket is handed out in MsgBindOK to clients that negotiated
+// CapStriping. Presenting it on a new, authenticated connection attaches
+// that connection to the session as another lane.
+type JoinTicket struct {
+	ID     [16]byte
+	Secret [32]byte
+}
+
+func NewJoinTicket() (*JoinTicket, error) {
+	t := &JoinTicket{}
+	if _, err := rand.Read(t.ID[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(t.Secret[:]); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *JoinTicket) Encode() []byte {
+	buf := make([]byte, 0, JoinTicketSize)
+	buf = append(buf, t.ID[:]...)
+	return append(buf, t.Secret[:]...)
+}
+
+func DecodeJoinTicket(b []byte) (*JoinTicket, error) {
+	if len(b) != JoinTicketSize {
+		return nil, ErrInvalidTicket
+	}
+	t := &JoinTicket{}
+	copy(t.ID[:], b[:16])
+	copy(t.Secret[:], b[16:])
+	return t, nil
+}
+
+func (t *JoinTicket) Matches(other *JoinTicket) bool {
+	return other != nil &&
+		t.ID == other.ID &&
+		subtle.ConstantTimeCompare(t.Secret[:], other.Secret[:]) == 1
+}
+
+// EncodeBindOK carries the public port, followed by the join ticket when the
+// session accepts extra lanes. Older clients only read the port.
+func EncodeBindOK(port uint16, ticket *JoinTicket) []byte {
+	buf := EncodeUint16(port)
+	if ticket != nil {
+		buf = append(buf, ticket.Encode()...)
+	}
+	return buf
+}
+
+func DecodeBindOK(payload []byte) (uint16, *JoinTicket, error) {
+	if len(payload) < 2 {
+		return 0, nil, ErrInvalidLength
+	}
+	port := DecodeUint16(payload)
+	if len(payload) == 2 {
+		return port, nil, nil
+	}
+	ticket, err := DecodeJoinTicket(payload[2:])
+	if err != nil {
+		return 0, nil, err
+	}
+	return port, ticket, nil
+}
@@ -0,0 +1,34 @@
+package protocol
+
+import "testing"
+
+func TestBindOKTicket(t *testing.T) {
+	ticket, err := NewJoinTicket()
+	if err != nil {
+		t.Fatalf("ticket failed: %v", err)
+	}
+
+	port, got, err := DecodeBindOK(EncodeBindOK(10080, ticket))
+	if err != nil || port != 10080 {
+		t.Fatalf("decode failed: port %d, err %v", port, err)
+	}
+	if !ticket.Matches(got) {
+		t.Fatalf("ticket did not survive the round trip")
+	}
+
+	forged := *got
+	forged.Secret[0] ^= 1
+	if ticket.Matches(&forged) {
+		t.Fatalf("ticket with a different secret matched")
+	}
+
+	// Servers without striping send only the port.
+	port, got, err = DecodeBindOK(EncodeUint16(10081))
+	if err != nil || port != 10081 || got != nil {
+		t.Fatalf("unexpected legacy decode: %d %v %v", port, got, err)
+	}
+
+	if _, _, err := DecodeBindOK([]byte{0, 1, 2}); err != ErrInvalidTicket {
+		t.Fatalf("expected ErrInvalidTicket, got %v", err)
+	}
+}
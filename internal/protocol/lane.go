@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxLanes caps how many connections one session may stripe across.
+const MaxLanes = 16
+
+var (
+	ErrLaneClosed   = errors.New("protocol: connection closed")
+	ErrTooManyLanes = errors.New("protocol: session has too many connections")
+)
+
+// Lane is one connection carrying part of a session. Every session has at
+// least one; clients that negotiate CapStriping add more, and each stream is
+// pinned to a single lane so its frames stay in order.
+type Lane struct {
+	sess   *Session
+	r      io.Reader
+	w      io.Writer
+	closer io.Closer
+
+	control chan writeRequest
+	data    chan writeRequest
+	streams atomic.Int32
+
+	lastSeen atomic.Int64
+
+	closed     chan struct{}
+	once       sync.Once
+	writerDone chan struct{}
+}
+
+func newLane(sess *Session, r io.Reader, w io.Writer) *Lane {
+	l := &Lane{
+		sess:       sess,
+		r:          r,
+		w:          w,
+		control:    make(chan writeRequest, controlQueueSize),
+		data:       make(chan writeRequest, dataQueueSize),
+		closed:     make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	if c, ok := w.(io.Closer); ok {
+		l.closer = c
+	}
+	l.touch()
+	go l.writeLoop()
+	return l
+}
+
+// ReadFrame reads the next frame from this lane. A StreamOpen pins the
+// stream to the lane it arrived on, so replies travel the same path.
+func (l *Lane) ReadFrame() (*Frame, error) {
+	select {
+	case <-l.sess.closed:
+		return nil, ErrSessionExpired
+	default:
+	}
+
+	frame, err := DecodeFrame(l.r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch frame.Type {
+	case MsgStreamOpen:
+		l.sess.pinStream(frame.StreamID, l)
+	case MsgStreamClose:
+		l.sess.unpinStream(frame.StreamID)
+	}
+
+	l.sess.Metrics.AddBytesReceived(int64(len(frame.Payload)))
+	l.touch()
+	return frame, nil
+}
+
+func (l *Lane) write(f *Frame) error {
+	queue := l.control
+	if f.Type == MsgStreamData {
+		queue = l.data
+	}
+	req := writeRequest{frame: f, done: make(chan error, 1)}
+
+	select {
+	case queue <- req:
+	case <-l.closed:
+		return ErrLaneClosed
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-l.closed:
+	}
+
+	// The writer may have taken the frame just before the lane closed. Only
+	// a frame it never picked up is safe to send again elsewhere.
+	<-l.writerDone
+	select {
+	case err := <-req.done:
+		return err
+	default:
+		return ErrLaneClosed
+	}
+}
+
+// Close shuts the lane and its connection. Streams pinned to it move to the
+// remaining lanes, and the session closes once none remain.
+func (l *Lane) Close() {
+	if l.shutdown() {
+		l.sess.dropLane(l)
+	}
+}
+
+// shutdown closes the connection first so a writer stuck on a slow peer
+// unblocks, then waits for it. It reports whether this call did the closing.
+func (l *Lane) shutdown() bool {
+	stopped := l.signal()
+	if stopped && l.closer != nil {
+		l.closer.Close()
+	}
+	<-l.writerDone
+	return stopped
+}
+
+// stop ends the writer and waits for it while leaving the connection open.
+func (l *Lane) stop() {
+	l.signal()
+	<-l.writerDone
+}
+
+func (l *Lane) signal() bool {
+	stopped := false
+	l.once.Do(func() {
+		close(l.closed)
+		stopped = true
+	})
+	return stopped
+}
+
+func (l *Lane) alive() bool {
+	select {
+	case <-l.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (l *Lane) startHeartbeat() {
+	l.touch()
+	go l.sendHeartbeatLoop()
+	go l.watchdogLoop()
+}
+
+func (l *Lane) sendHeartbeatLoop() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.write(&Frame{
+				Version: ProtocolVersion1,
+				Type:    MsgHeartbeat,
+			}); err != nil {
+				return
+			}
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+func (l *Lane) watchdogLoop() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if l.idle() > HeartbeatTimeout {
+				l.Close()
+				return
+			}
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+func (l *Lane) Done() <-chan struct{} {
+	return l.closed
+}
+
+func (l *Lane) touch() {
+	l.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (l *Lane) idle() time.Duration {
+	return time.Since(time.Unix(0, l.lastSeen.Load()))
+}
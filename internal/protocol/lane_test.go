@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+func streamIDs(t *testing.T, buf *bytes.Buffer) []uint32 {
+	t.Helper()
+	var ids []uint32
+	for buf.Len() > 0 {
+		frame, err := DecodeFrame(buf)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		ids = append(ids, frame.StreamID)
+	}
+	return ids
+}
+
+func TestStreamsStayOnOneLane(t *testing.T) {
+	var first, second bytes.Buffer
+	sess := NewSession(nil, &first)
+	defer sess.Close()
+
+	if _, err := sess.AddLane(nil, &second); err != nil {
+		t.Fatalf("add lane failed: %v", err)
+	}
+
+	for _, id := range []uint32{1, 2, 3, 4, 1, 2, 3, 4} {
+		if err := sess.WriteFrame(NewStreamFrame(MsgStreamData, id, []byte("data"))); err != nil {
+			t.Fatalf("write %d failed: %v", id, err)
+		}
+	}
+
+	a, b := streamIDs(t, &first), streamIDs(t, &second)
+	if len(a) != 4 || len(b) != 4 {
+		t.Fatalf("expected streams spread evenly, got %v and %v", a, b)
+	}
+	for _, id := range a {
+		if slices.Contains(b, id) {
+			t.Fatalf("stream %d was written to both lanes", id)
+		}
+	}
+}
+
+func TestLaneLossMovesItsStreams(t *testing.T) {
+	var first, second bytes.Buffer
+	sess := NewSession(nil, &first)
+	defer sess.Close()
+
+	lane, err := sess.AddLane(nil, &second)
+	if err != nil {
+		t.Fatalf("add lane failed: %v", err)
+	}
+
+	kept := sess.Streams().Open()
+	moved := sess.Streams().Open()
+	sess.pinStream(kept.ID, sess.Primary())
+	sess.pinStream(moved.ID, lane)
+
+	lane.Close()
+
+	for _, id := range []uint32{kept.ID, moved.ID} {
+		if _, ok := sess.Streams().Get(id); !ok {
+			t.Fatalf("stream %d was reset", id)
+		}
+	}
+	if sess.IsClosed() || sess.LaneCount() != 1 {
+		t.Fatalf("session should survive on its remaining lane")
+	}
+
+	// The stream from the lost lane carries on over the one that is left.
+	if err := sess.WriteFrame(NewStreamFrame(MsgStreamData, moved.ID, []byte("data"))); err != nil {
+		t.Fatalf("write after failover failed: %v", err)
+	}
+	if ids := streamIDs(t, &first); !slices.Equal(ids, []uint32{moved.ID}) {
+		t.Fatalf("expected stream %d on the remaining lane, got %v", moved.ID, ids)
+	}
+
+	// The first connection is not special: the session lives on without it.
+	if _, err := sess.AddLane(nil, &second); err != nil {
+		t.Fatalf("add lane failed: %v", err)
+	}
+	sess.Primary().Close()
+	if sess.IsClosed() {
+		t.Fatalf("session should survive losing its first connection")
+	}
+	if err := sess.WriteFrame(&Frame{Type: MsgError, Payload: []byte("bye")}); err != nil {
+		t.Fatalf("session frame after losing the first connection failed: %v", err)
+	}
+
+	for _, l := range sess.Lanes() {
+		l.Close()
+	}
+	if !sess.IsClosed() {
+		t.Fatalf("session should close with its last lane")
+	}
+}
+
+func TestAddLaneLimit(t *testing.T) {
+	sess := NewSession(nil, io.Discard)
+	defer sess.Close()
+
+	for range MaxLanes - 1 {
+		if _, err := sess.AddLane(nil, io.Discard); err != nil {
+			t.Fatalf("add lane failed: %v", err)
+		}
+	}
+	if _, err := sess.AddLane(nil, io.Discard); err != ErrTooManyLanes {
+		t.Fatalf("expected ErrTooManyLanes, got %v", err)
+	}
+}
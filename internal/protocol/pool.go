@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// Payload buffers are pooled in power-of-two size classes from 512 B to
+// 64 KiB. Larger payloads are rare and simply allocated.
+const (
+	minBufferShift = 9
+	maxBufferShift = 16
+
+	MaxPooledBuffer = 1 << maxBufferShift
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// GetBuffer returns a slice of length n, reusing a pooled buffer when n is
+// small enough.
+func GetBuffer(n int) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	class := bufferClass(n)
+	if class < 0 {
+		return make([]byte, n)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:n]
+	}
+	return make([]byte, n, 1<<(class+minBufferShift))
+}
+
+// PutBuffer hands a buffer from GetBuffer back to the pool. Buffers of other
+// sizes are ignored.
+func PutBuffer(buf []byte) {
+	size := cap(buf)
+	class := bufferClass(size)
+	if class < 0 || size != 1<<(class+minBufferShift) {
+		return
+	}
+	buf = buf[:0]
+	bufferPools[class].Put(&buf)
+}
+
+func bufferClass(n int) int {
+	if n > MaxPooledBuffer {
+		return -1
+	}
+	if n <= 1<<minBufferShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minBufferShift
+}
+
+const (
+	minReadSize = 4 * 1024
+	maxReadSize = MaxPooledBuffer
+)
+
+// ReadBuffer is a per-stream read buffer whose read size doubles while reads
+// keep filling it and halves when they come back mostly empty, so bulk
+// transfers travel in large frames and interactive streams in small ones.
+type ReadBuffer struct {
+	buf  []byte
+	size int
+}
+
+func NewReadBuffer() *ReadBuffer {
+	return &ReadBuffer{buf: GetBuffer(maxReadSize), size: minReadSize}
+}
+
+// Read returns the bytes read by one r.Read call. The slice is only valid
+// until the next Read.
+func (b *ReadBuffer) Read(r io.Reader) ([]byte, error) {
+	n, err := r.Read(b.buf[:b.size])
+
+	switch {
+	case n == b.size && b.size < maxReadSize:
+		b.size *= 2
+	case n < b.size/4 && b.size > minReadSize:
+		b.size /= 2
+	}
+
+	return b.buf[:n], err
+}
+
+func (b *ReadBuffer) Release() {
+	PutBuffer(b.buf)
+	b.buf = nil
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestGetBufferSizeClasses(t *testing.T) {
+	tests := []struct {
+		n, cap int
+	}{
+		{1, 512},
+		{512, 512},
+		{513, 1024},
+		{4096, 4096},
+		{40000, 65536},
+		{MaxPooledBuffer, MaxPooledBuffer},
+		{MaxPooledBuffer + 1, MaxPooledBuffer + 1},
+	}
+
+	for _, tt := range tests {
+		buf := GetBuffer(tt.n)
+		if len(buf) != tt.n || cap(buf) != tt.cap {
+			t.Errorf("GetBuffer(%d): len %d cap %d, expected cap %d", tt.n, len(buf), cap(buf), tt.cap)
+		}
+		PutBuffer(buf)
+	}
+
+	if buf := GetBuffer(0); buf != nil {
+		t.Fatalf("expected nil buffer for empty payload")
+	}
+}
+
+func TestPutBufferIgnoresForeignSlices(t *testing.T) {
+	PutBuffer(make([]byte, 100))
+	PutBuffer(make([]byte, 1000, 1000))
+	PutBuffer(nil)
+
+	if buf := GetBuffer(100); cap(buf) != 512 {
+		t.Fatalf("expected a 512 byte class buffer, got cap %d", cap(buf))
+	}
+}
+
+func TestReadBufferAdapts(t *testing.T) {
+	buf := NewReadBuffer()
+	defer buf.Release()
+
+	bulk := bytes.NewReader(make([]byte, 1<<20))
+	for range 6 {
+		if _, err := buf.Read(bulk); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+	}
+	if buf.size != maxReadSize {
+		t.Fatalf("expected read size to grow to %d, got %d", maxReadSize, buf.size)
+	}
+
+	for range 6 {
+		if _, err := buf.Read(bytes.NewReader([]byte("ping"))); err != nil && err != io.EOF {
+			t.Fatalf("read failed: %v", err)
+		}
+	}
+	if buf.size != minReadSize {
+		t.Fatalf("expected read size to shrink to %d, got %d", minReadSize, buf.size)
+	}
+}
@@ -3,10 +3,12 @@ package protocol
 import (
 	"io"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/bakare-dev/gotunnel/internal/metrics"
+	"github.com/bakare-dev/gotunnel/internal/ratelimit"
 )
 
 type SessionState uint8
@@ -18,74 +20,243 @@ const (
 )
 
 type Session struct {
-	r io.Reader
-	w io.Writer
-
 	state SessionState
 
 	Role         PeerRole
 	Capabilities Capability
 
+	AuthMode         AuthMode
+	RequireChallenge bool
+	PeerIdentity     string
+	Token            string
+	Identity         string
+
+	// VerifyKey checks public key auth and is left to the server, which
+	// owns the authorized keys. KeyIdentity is the identity it returned.
+	VerifyKey   KeyVerifier
+	KeyIdentity string
+
+	nonce   []byte
+	matched string
+
 	ExposeAddr string
+	Bind       BindOptions
 	PublicPort int
 
 	streams *StreamManager
 	Metrics *metrics.Metrics
+	Limiter ratelimit.Chain
+
+	// Ticket lets further connections join this session as extra lanes.
+	Ticket *JoinTicket
 
-	lastSeen time.Time
-	mu       sync.Mutex
-	writeMu  sync.Mutex
-	closed   chan struct{}
-	once     sync.Once
+	primary     *Lane
+	lanes       []*Lane
+	streamLanes map[uint32]*Lane
+	heartbeat   bool
+
+	mu     sync.Mutex
+	closed chan struct{}
+	once   sync.Once
 }
 
 func NewSession(r io.Reader, w io.Writer) *Session {
-	return &Session{
-		r:       r,
-		w:       w,
-		state:   StateInit,
-		streams: NewStreamManager(),
-		Metrics: metrics.New(),
-		closed:  make(chan struct{}),
+	s := &Session{
+		state:       StateInit,
+		streams:     NewStreamManager(),
+		Metrics:     metrics.New(),
+		streamLanes: make(map[uint32]*Lane),
+		closed:      make(chan struct{}),
 	}
+	s.primary = newLane(s, r, w)
+	s.lanes = []*Lane{s.primary}
+	return s
 }
 
+// ReadFrame reads from the connection the session was created with. Extra
+// lanes are read through their own ReadFrame.
 func (s *Session) ReadFrame() (*Frame, error) {
+	return s.primary.ReadFrame()
+}
+
+func (s *Session) WriteFrame(f *Frame) error {
 	select {
 	case <-s.closed:
-		return nil, ErrSessionExpired
+		return ErrSessionExpired
 	default:
 	}
 
-	frame, err := DecodeFrame(s.r)
-	if err != nil {
-		return nil, err
+	lane := s.laneFor(f)
+	if lane == nil {
+		return ErrSessionExpired
 	}
 
-	s.Metrics.AddBytesReceived(int64(len(frame.Payload)))
-	s.touch()
-	return frame, nil
-}
+	// Wait for bandwidth before queueing so control frames and other
+	// streams are not held up behind a throttled data frame.
+	if f.Type == MsgStreamData {
+		s.Limiter.WaitBytes(len(f.Payload))
+	}
 
-func (s *Session) WriteFrame(f *Frame) error {
-	select {
-	case <-s.closed:
+	f.Version = ProtocolVersion1
+	err := lane.write(f)
+
+	// A frame that never reached a lost connection goes out on the lane its
+	// stream was moved to.
+	for tries := 1; err == ErrLaneClosed && !s.IsClosed() && tries < MaxLanes; tries++ {
+		if lane = s.laneFor(f); lane == nil {
+			break
+		}
+		err = lane.write(f)
+	}
+	if f.Type == MsgStreamClose {
+		s.unpinStream(f.StreamID)
+	}
+	if err == ErrLaneClosed && s.IsClosed() {
 		return ErrSessionExpired
-	default:
 	}
+	if err == nil {
+		s.Metrics.AddBytesSent(int64(len(f.Payload)))
+	}
+	return err
+}
 
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+// laneFor picks the lane a frame travels on. Streams stay on the lane they
+// were opened on until it is lost; new streams go to the least busy lane, and
+// session-level frames prefer the primary.
+func (s *Session) laneFor(f *Frame) *Lane {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	select {
-	case <-s.closed:
-		return ErrSessionExpired
-	default:
+	if f.StreamID == 0 {
+		if s.primary.alive() {
+			return s.primary
+		}
+		for _, l := range s.lanes {
+			if l.alive() {
+				return l
+			}
+		}
+		return nil
 	}
 
-	f.Version = ProtocolVersion1
-	s.Metrics.AddBytesSent(int64(len(f.Payload)))
-	return f.Encode(s.w)
+	if l, ok := s.streamLanes[f.StreamID]; ok && l.alive() {
+		return l
+	}
+	return s.repin(f.StreamID)
+}
+
+// repin moves a stream to the least busy live lane. Callers hold s.mu.
+func (s *Session) repin(id uint32) *Lane {
+	if l, ok := s.streamLanes[id]; ok {
+		delete(s.streamLanes, id)
+		l.streams.Add(-1)
+	}
+
+	var best *Lane
+	for _, l := range s.lanes {
+		if l.alive() && (best == nil || l.streams.Load() < best.streams.Load()) {
+			best = l
+		}
+	}
+	if best != nil {
+		s.streamLanes[id] = best
+		best.streams.Add(1)
+	}
+	return best
+}
+
+func (s *Session) pinStream(id uint32, l *Lane) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.streamLanes[id]; !ok {
+		s.streamLanes[id] = l
+		l.streams.Add(1)
+	}
+}
+
+func (s *Session) unpinStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.streamLanes[id]; ok {
+		delete(s.streamLanes, id)
+		l.streams.Add(-1)
+	}
+}
+
+// AddLane attaches another connection to the session. Frames read from it
+// must be fed through the returned lane's ReadFrame.
+func (s *Session) AddLane(r io.Reader, w io.Writer) (*Lane, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionExpired
+	}
+
+	s.mu.Lock()
+	if len(s.lanes) >= MaxLanes {
+		s.mu.Unlock()
+		return nil, ErrTooManyLanes
+	}
+	l := newLane(s, r, w)
+	s.lanes = append(s.lanes, l)
+	heartbeat := s.heartbeat
+	s.mu.Unlock()
+
+	if heartbeat {
+		l.startHeartbeat()
+	}
+	return l, nil
+}
+
+// Primary is the lane for the connection the session was created with.
+func (s *Session) Primary() *Lane {
+	return s.primary
+}
+
+func (s *Session) Lanes() []*Lane {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.lanes)
+}
+
+func (s *Session) LaneCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.lanes)
+}
+
+// dropLane removes a closed lane, moves the streams that were pinned to it
+// onto the remaining lanes and closes the session once no lanes remain.
+// Frames the lost connection had already sent are not resent.
+func (s *Session) dropLane(l *Lane) {
+	s.mu.Lock()
+	s.lanes = slices.DeleteFunc(s.lanes, func(x *Lane) bool { return x == l })
+	moved := 0
+	for id, pinned := range s.streamLanes {
+		if pinned == l {
+			s.repin(id)
+			moved++
+		}
+	}
+	remaining := len(s.lanes)
+	s.mu.Unlock()
+
+	if remaining == 0 {
+		s.Close()
+		return
+	}
+	if moved > 0 {
+		log.Printf("│ WARN  │ Connection lost, moved %d streams (%d connections left)", moved, remaining)
+	}
+}
+
+// Detach ends the session without touching its connection, handing the
+// connection over to the session it joins.
+func (s *Session) Detach() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	s.primary.stop()
 }
 
 func (s *Session) ProcessHandshake(frame *Frame) error {
@@ -101,22 +272,87 @@ func (s *Session) ProcessHandshake(frame *Frame) error {
 	s.Role = hs.Role
 	s.Capabilities = hs.Capabilities
 	s.ExposeAddr = hs.ExposeAddr
+	s.Bind = hs.Bind
 	s.state = StateHandshaken
 	return nil
 }
 
+// IssueChallenge creates the nonce sent in MsgHandshakeAck for clients that
+// support challenge-response auth. It is consumed by the next ProcessAuth.
+func (s *Session) IssueChallenge() ([]byte, error) {
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	s.nonce = nonce
+	return nonce, nil
+}
+
 func (s *Session) ProcessAuth(frame *Frame) error {
 	if s.state != StateHandshaken {
 		return ErrAuthRequired
 	}
 
-	auth, err := DecodeAuth(frame.Payload)
-	if err != nil {
+	nonce := s.nonce
+	s.nonce = nil
+
+	msg, err := DecodeAuth(frame.Payload)
+	if err != nil && s.AuthMode == AuthToken {
 		return err
 	}
+	if msg == nil {
+		msg = &Auth{}
+	}
 
-	if !ValidateToken(auth.Token) {
-		return ErrAuthFailed
+	// A registered public key stands in for a token.
+	var tokenOK bool
+	switch {
+	case msg.IsPublicKey():
+		if nonce == nil || s.VerifyKey == nil {
+			return ErrAuthFailed
+		}
+		identity, err := s.VerifyKey(msg.PublicKey, nonce, msg.ClientNonce, msg.Signature)
+		if err != nil {
+			return err
+		}
+		s.KeyIdentity = identity
+		tokenOK = true
+	case msg.IsChallengeResponse():
+		if nonce == nil {
+			return ErrAuthFailed
+		}
+		msg.Token, tokenOK = MatchTokenResponse(nonce, msg.ClientNonce, msg.Response)
+		s.matched = msg.Token
+	case s.RequireChallenge && msg.Token != "":
+		return ErrChallengeRequired
+	default:
+		tokenOK = ValidateToken(msg.Token)
+	}
+
+	certOK := s.PeerIdentity != ""
+
+	switch s.AuthMode {
+	case AuthCert:
+		if !certOK {
+			return ErrAuthFailed
+		}
+	case AuthCertAndToken:
+		if !certOK || !tokenOK {
+			return ErrAuthFailed
+		}
+	default:
+		if !tokenOK {
+			return ErrAuthFailed
+		}
+	}
+
+	s.Token = msg.Token
+	s.Identity = s.PeerIdentity
+	if s.Identity == "" {
+		s.Identity = s.KeyIdentity
+	}
+	if s.Identity == "" {
+		s.Identity = msg.Token
 	}
 
 	s.state = StateAuthenticated
@@ -124,59 +360,30 @@ func (s *Session) ProcessAuth(frame *Frame) error {
 	return nil
 }
 
-func (s *Session) Streams() *StreamManager {
-	return s.streams
-}
-
-func (s *Session) StartHeartbeat() {
-	s.touch()
-	go s.sendHeartbeatLoop()
-	go s.watchdogLoop()
-}
-
-func (s *Session) sendHeartbeatLoop() {
-	ticker := time.NewTicker(HeartbeatInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			if err := s.WriteFrame(&Frame{
-				Type: MsgHeartbeat,
-			}); err != nil {
-				return
-			}
-		case <-s.closed:
-			return
-		}
+// MatchedTokenSubject names the token a challenge response proved, even if
+// auth then failed for another reason, so the attempt can be counted
+// against that token.
+func (s *Session) MatchedTokenSubject() string {
+	if s.matched == "" {
+		return ""
 	}
+	return TokenSubject(s.matched)
 }
 
-func (s *Session) watchdogLoop() {
-	ticker := time.NewTicker(HeartbeatInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			s.mu.Lock()
-			expired := time.Since(s.lastSeen) > HeartbeatTimeout
-			s.mu.Unlock()
-
-			if expired {
-				s.Close()
-				return
-			}
-		case <-s.closed:
-			return
-		}
-	}
+func (s *Session) Streams() *StreamManager {
+	return s.streams
 }
 
-func (s *Session) touch() {
+// StartHeartbeat runs heartbeats on every lane, including ones added later.
+func (s *Session) StartHeartbeat() {
 	s.mu.Lock()
-	s.lastSeen = time.Now()
+	s.heartbeat = true
+	lanes := slices.Clone(s.lanes)
 	s.mu.Unlock()
+
+	for _, l := range lanes {
+		l.startHeartbeat()
+	}
 }
 
 func (s *Session) IsAuthenticated() bool {
@@ -199,6 +406,10 @@ func (s *Session) Close() error {
 
 		log.Println("│ INFO  │ Shutting down session...")
 
+		for _, l := range s.Lanes() {
+			l.shutdown()
+		}
+
 		streamIDs := s.streams.GetAllStreamIDs()
 		for _, streamID := range streamIDs {
 			s.streams.Close(streamID)
@@ -209,6 +420,10 @@ func (s *Session) Close() error {
 	return nil
 }
 
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
 func (s *Session) IsClosed() bool {
 	select {
 	case <-s.closed:
@@ -9,13 +9,17 @@ func (s *Session) HandleFrame(f *Frame) error {
 	case MsgStreamData:
 		stream, ok := s.streams.Get(f.StreamID)
 		if !ok {
+			f.Release()
 			return ErrInvalidLength
 		}
+		// The stream's consumer releases the payload once written.
 		stream.In <- f.Payload
+		return nil
 
 	case MsgStreamClose:
 		s.streams.Close(f.StreamID)
 	}
 
+	f.Release()
 	return nil
 }
@@ -0,0 +1,73 @@
+package protocol
+
+import "encoding/binary"
+
+const streamFlagTLS uint8 = 1 << 0
+
+type StreamOpen struct {
+	RemoteAddr string
+	LocalAddr  string
+
+	// TLS is set when the server terminated TLS for this connection;
+	// ServerName is the SNI the public client asked for.
+	TLS        bool
+	ServerName string
+}
+
+func (o *StreamOpen) Encode() []byte {
+	buf := make([]byte, 0, 7+len(o.RemoteAddr)+len(o.LocalAddr)+len(o.ServerName))
+	buf = appendString(buf, o.RemoteAddr)
+	buf = appendString(buf, o.LocalAddr)
+
+	var flags uint8
+	if o.TLS {
+		flags |= streamFlagTLS
+	}
+	buf = append(buf, flags)
+	return appendString(buf, o.ServerName)
+}
+
+func DecodeStreamOpen(payload []byte) (*StreamOpen, error) {
+	o := &StreamOpen{}
+	if len(payload) == 0 {
+		return o, nil
+	}
+
+	remote, rest, err := readString(payload)
+	if err != nil {
+		return nil, err
+	}
+	local, rest, err := readString(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	o.RemoteAddr = remote
+	o.LocalAddr = local
+
+	// Flags and server name were added later and are optional.
+	if len(rest) == 0 {
+		return o, nil
+	}
+	o.TLS = rest[0]&streamFlagTLS != 0
+	if o.ServerName, _, err = readString(rest[1:]); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, ErrInvalidLength
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, ErrInvalidLength
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import "testing"
+
+func TestStreamOpenRoundTrip(t *testing.T) {
+	open := &StreamOpen{
+		RemoteAddr: "203.0.113.7:51234",
+		LocalAddr:  "[::]:10000",
+		TLS:        true,
+		ServerName: "app.example.com",
+	}
+
+	decoded, err := DecodeStreamOpen(open.Encode())
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if *decoded != *open {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", decoded, open)
+	}
+}
+
+func TestStreamOpenWithoutFlags(t *testing.T) {
+	payload := appendString(appendString(nil, "203.0.113.7:51234"), "[::]:10000")
+
+	decoded, err := DecodeStreamOpen(payload)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if decoded.TLS || decoded.ServerName != "" || decoded.RemoteAddr != "203.0.113.7:51234" {
+		t.Fatalf("unexpected decode of legacy payload: %+v", decoded)
+	}
+}
@@ -1,5 +1,28 @@
 package protocol
 
+import "crypto/subtle"
+
+var validTokens = []string{"dev-token"}
+
 func ValidateToken(token string) bool {
-	return token == "dev-token"
+	for _, valid := range validTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTokenResponse returns the token that produced a challenge response.
+// Every known token is tried so the timing does not reveal which matched.
+func MatchTokenResponse(serverNonce, clientNonce, response []byte) (string, bool) {
+	var matched string
+	found := false
+
+	for _, valid := range validTokens {
+		if VerifyTokenResponse(valid, serverNonce, clientNonce, response) && !found {
+			matched, found = valid, true
+		}
+	}
+	return matched, found
 }
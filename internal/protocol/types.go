@@ -37,4 +37,6 @@ const (
 	CapCompression
 	CapReconnect
 	CapMetrics
+	CapChallenge
+	CapStriping
 )
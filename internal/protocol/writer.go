@@ -0,0 +1,109 @@
+package protocol
+
+import "bufio"
+
+const (
+	writeBufferSize = 64 * 1024
+	maxWriteBatch   = 64
+
+	controlQueueSize = 64
+	dataQueueSize    = 256
+)
+
+type writeRequest struct {
+	frame *Frame
+	done  chan error
+}
+
+// writeLoop is the only goroutine that touches the lane's writer. Frames
+// queued while a batch is being written are coalesced into one buffered
+// write and flushed as soon as the queues run dry. Control frames always go
+// ahead of queued stream data so heartbeats are not delayed by a busy tunnel.
+func (l *Lane) writeLoop() {
+	defer close(l.writerDone)
+
+	bw := bufio.NewWriterSize(l.w, writeBufferSize)
+	batch := make([]writeRequest, 0, maxWriteBatch)
+
+	for {
+		req, ok := l.nextWrite()
+		if !ok {
+			return
+		}
+
+		err := writeFrame(bw, req.frame)
+		batch = append(batch, req)
+
+		for err == nil && len(batch) < maxWriteBatch {
+			next, ok := l.pollWrite()
+			if !ok {
+				break
+			}
+			err = writeFrame(bw, next.frame)
+			batch = append(batch, next)
+		}
+
+		if err == nil {
+			err = bw.Flush()
+		}
+		for _, r := range batch {
+			r.done <- err
+		}
+		batch = batch[:0]
+	}
+}
+
+// writeFrame encodes the header straight into the buffered writer's free
+// space so it does not escape to the heap.
+func writeFrame(bw *bufio.Writer, f *Frame) error {
+	if bw.Available() < HeaderSize {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.Write(f.AppendHeader(bw.AvailableBuffer())); err != nil {
+		return err
+	}
+	_, err := bw.Write(f.Payload)
+	return err
+}
+
+// nextWrite blocks until a frame is queued, preferring control frames. It
+// returns false once the lane is closed, even with frames still queued, so
+// their senders know the frames were never written.
+func (l *Lane) nextWrite() (writeRequest, bool) {
+	if req, ok := l.pollWrite(); ok {
+		return req, true
+	}
+	if !l.alive() {
+		return writeRequest{}, false
+	}
+
+	select {
+	case req := <-l.control:
+		return req, true
+	case req := <-l.data:
+		return req, true
+	case <-l.closed:
+		return writeRequest{}, false
+	}
+}
+
+func (l *Lane) pollWrite() (writeRequest, bool) {
+	if !l.alive() {
+		return writeRequest{}, false
+	}
+
+	select {
+	case req := <-l.control:
+		return req, true
+	default:
+	}
+
+	select {
+	case req := <-l.data:
+		return req, true
+	default:
+		return writeRequest{}, false
+	}
+}
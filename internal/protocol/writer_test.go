@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteFrameConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	sess := NewSession(nil, &buf)
+	defer sess.Close()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func(id uint32) {
+			defer wg.Done()
+			payload := bytes.Repeat([]byte{byte(id)}, 1000)
+			if err := sess.WriteFrame(NewStreamFrame(MsgStreamData, id, payload)); err != nil {
+				t.Errorf("write %d failed: %v", id, err)
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+
+	for range 50 {
+		frame, err := DecodeFrame(&buf)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if len(frame.Payload) != 1000 || frame.Payload[0] != byte(frame.StreamID) {
+			t.Fatalf("frame %d was corrupted", frame.StreamID)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected trailing bytes: %d", buf.Len())
+	}
+}
+
+func TestControlFramesSkipQueuedData(t *testing.T) {
+	pr, pw := io.Pipe()
+	sess := NewSession(nil, pw)
+	defer sess.Close()
+
+	// The first frame blocks in the pipe; everything after it queues up.
+	go sess.WriteFrame(NewStreamFrame(MsgStreamData, 1, []byte("first")))
+	time.Sleep(20 * time.Millisecond)
+
+	for id := uint32(2); id <= 4; id++ {
+		go sess.WriteFrame(NewStreamFrame(MsgStreamData, id, []byte("data")))
+	}
+	time.Sleep(20 * time.Millisecond)
+	go sess.WriteFrame(NewFrame(MsgHeartbeat, nil))
+	time.Sleep(20 * time.Millisecond)
+
+	var types []MessageType
+	for range 5 {
+		frame, err := DecodeFrame(pr)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		types = append(types, frame.Type)
+	}
+
+	if types[0] != MsgStreamData || types[1] != MsgHeartbeat {
+		t.Fatalf("expected heartbeat right after the in-flight frame, got %v", types)
+	}
+}
+
+func TestWriteFrameAfterClose(t *testing.T) {
+	sess := NewSession(nil, io.Discard)
+	sess.Close()
+
+	if err := sess.WriteFrame(NewFrame(MsgHeartbeat, nil)); err != ErrSessionExpired {
+		t.Fatalf("expected ErrSessionExpired, got %v", err)
+	}
+}
+
+// stallWriter holds every write until released.
+type stallWriter struct {
+	entered  chan struct{}
+	release  chan struct{}
+	once     sync.Once
+	finished atomic.Bool
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.entered) })
+	<-w.release
+	w.finished.Store(true)
+	return len(p), nil
+}
+
+func TestWriteFrameWaitsForWriterOnClose(t *testing.T) {
+	w := &stallWriter{entered: make(chan struct{}), release: make(chan struct{})}
+	sess := NewSession(nil, w)
+
+	written := make(chan error, 1)
+	go func() {
+		written <- sess.WriteFrame(NewStreamFrame(MsgStreamData, 1, make([]byte, 128*1024)))
+	}()
+	<-w.entered
+
+	// A queued frame the writer never picked up must not be written after
+	// the session closes.
+	queued := make(chan error, 1)
+	go func() {
+		queued <- sess.WriteFrame(NewStreamFrame(MsgStreamData, 2, []byte("late")))
+	}()
+	go sess.Close()
+
+	select {
+	case err := <-written:
+		t.Fatalf("WriteFrame returned %v while its payload was still being written", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(w.release)
+	if err := <-written; err != nil {
+		t.Fatalf("expected the in-flight write to finish, got %v", err)
+	}
+	if !w.finished.Load() {
+		t.Fatalf("WriteFrame returned before the writer was done with the payload")
+	}
+	if err := <-queued; err != ErrSessionExpired {
+		t.Fatalf("expected ErrSessionExpired for the queued frame, got %v", err)
+	}
+}
+
+func BenchmarkSessionWriteFrame(b *testing.B) {
+	sess := NewSession(nil, io.Discard)
+	defer sess.Close()
+	payload := make([]byte, 32*1024)
+
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := sess.WriteFrame(NewStreamFrame(MsgStreamData, 1, payload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
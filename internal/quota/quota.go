@@ -0,0 +1,143 @@
+package quota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/metrics"
+)
+
+const TrackInterval = 10 * time.Second
+
+type Limits struct {
+	Period      string  `yaml:"period" json:"period"`
+	Bytes       int64   `yaml:"bytes" json:"bytes"`
+	TunnelHours float64 `yaml:"tunnel_hours" json:"tunnel_hours"`
+}
+
+type Config struct {
+	Store   string            `yaml:"store"`
+	Default Limits            `yaml:"default"`
+	Tokens  map[string]Limits `yaml:"tokens"`
+}
+
+type ExceededError struct {
+	Reason string
+}
+
+func (e *ExceededError) Error() string {
+	return "quota exceeded: " + e.Reason
+}
+
+type Manager struct {
+	config Config
+	store  *Store
+}
+
+func NewManager(config Config) (*Manager, error) {
+	store, err := OpenStore(config.Store)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{config: config, store: store}, nil
+}
+
+func (m *Manager) limits(token string) Limits {
+	if l, ok := m.config.Tokens[token]; ok {
+		return l
+	}
+	return m.config.Default
+}
+
+func (m *Manager) Check(token string) error {
+	limits := m.limits(token)
+	usage := m.store.Get(Fingerprint(token), PeriodKey(limits.Period, time.Now()))
+	return check(limits, usage)
+}
+
+// Track folds the session's byte counters and connected time into the store
+// until done is closed. cut is called once if the quota runs out mid-session.
+func (m *Manager) Track(token string, sess *metrics.Metrics, done <-chan struct{}, cut func(error)) {
+	key := Fingerprint(token)
+	limits := m.limits(token)
+
+	ticker := time.NewTicker(TrackInterval)
+	defer ticker.Stop()
+
+	var lastBytes int64
+	last := time.Now()
+
+	flush := func() error {
+		sent, recv := sess.GetBandwidth()
+		now := time.Now()
+
+		usage := m.store.Add(key, PeriodKey(limits.Period, now), sent+recv-lastBytes, now.Sub(last).Seconds())
+		lastBytes = sent + recv
+		last = now
+
+		if err := m.store.Save(); err != nil {
+			log.Printf("│ ERROR │ Failed to persist quota usage: %v", err)
+		}
+		return check(limits, usage)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				cut(err)
+				flush()
+				return
+			}
+		case <-done:
+			flush()
+			return
+		}
+	}
+}
+
+func (m *Manager) Usage() map[string]Usage {
+	return m.store.Snapshot()
+}
+
+func (m *Manager) Close() error {
+	return m.store.Save()
+}
+
+func check(limits Limits, usage Usage) error {
+	if limits.Bytes > 0 && usage.Bytes >= limits.Bytes {
+		return &ExceededError{Reason: fmt.Sprintf("transferred %s of %s this %s",
+			metrics.FormatBytes(usage.Bytes), metrics.FormatBytes(limits.Bytes), periodName(limits.Period))}
+	}
+
+	hours := usage.TunnelSeconds / 3600
+	if limits.TunnelHours > 0 && hours >= limits.TunnelHours {
+		return &ExceededError{Reason: fmt.Sprintf("used %.1f of %.1f tunnel hours this %s",
+			hours, limits.TunnelHours, periodName(limits.Period))}
+	}
+
+	return nil
+}
+
+func PeriodKey(period string, now time.Time) string {
+	now = now.UTC()
+	if period == "day" {
+		return now.Format("2006-01-02")
+	}
+	return now.Format("2006-01")
+}
+
+func periodName(period string) string {
+	if period == "day" {
+		return "day"
+	}
+	return "month"
+}
+
+func Fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
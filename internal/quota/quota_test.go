@@ -0,0 +1,91 @@
+package quota
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorePersistsUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	store.Add("abc", "2026-10", 1024, 60)
+	if err := store.Save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	usage := reopened.Get("abc", "2026-10")
+	if usage.Bytes != 1024 || usage.TunnelSeconds != 60 {
+		t.Fatalf("unexpected usage after reopen: %+v", usage)
+	}
+
+	if usage := reopened.Get("abc", "2026-11"); usage.Bytes != 0 {
+		t.Fatalf("expected usage to reset in a new period, got %+v", usage)
+	}
+}
+
+func TestStoreConcurrentSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				store.Add("abc", "2026-10", 1, 0)
+				if err := store.Save(); err != nil {
+					t.Errorf("save failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if usage := reopened.Get("abc", "2026-10"); usage.Bytes != 400 {
+		t.Fatalf("expected the last save to hold all usage, got %+v", usage)
+	}
+}
+
+func TestManagerCheck(t *testing.T) {
+	m, err := NewManager(Config{
+		Store:   filepath.Join(t.TempDir(), "quota.json"),
+		Default: Limits{Period: "day", Bytes: 1000},
+		Tokens: map[string]Limits{
+			"vip": {Period: "day"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("new manager failed: %v", err)
+	}
+
+	period := PeriodKey("day", time.Now())
+	m.store.Add(Fingerprint("dev"), period, 1500, 0)
+	m.store.Add(Fingerprint("vip"), period, 1500, 0)
+
+	if err := m.Check("dev"); err == nil {
+		t.Fatalf("expected quota to be exceeded")
+	}
+	if err := m.Check("vip"); err != nil {
+		t.Fatalf("expected unlimited token to pass, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type Usage struct {
+	Period        string  `json:"period"`
+	Bytes         int64   `json:"bytes"`
+	TunnelSeconds float64 `json:"tunnel_seconds"`
+}
+
+// Store keeps per-token usage in a JSON file. Keys are token fingerprints,
+// never the tokens themselves.
+type Store struct {
+	path string
+
+	// saveMu serializes writers of the temp file and the rename over path.
+	saveMu sync.Mutex
+
+	mu    sync.Mutex
+	usage map[string]*Usage
+	dirty bool
+}
+
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		usage: make(map[string]*Usage),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota store: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.usage); err != nil {
+		return nil, fmt.Errorf("failed to parse quota store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Store) Get(key, period string) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.usage[key]
+	if !ok || u.Period != period {
+		return Usage{Period: period}
+	}
+	return *u
+}
+
+func (s *Store) Add(key, period string, bytes int64, seconds float64) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.usage[key]
+	if !ok || u.Period != period {
+		u = &Usage{Period: period}
+		s.usage[key] = u
+	}
+
+	u.Bytes += bytes
+	u.TunnelSeconds += seconds
+	s.dirty = true
+
+	return *u
+}
+
+func (s *Store) Snapshot() map[string]Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]Usage, len(s.usage))
+	for key, u := range s.usage {
+		snapshot[key] = *u
+	}
+	return snapshot
+}
+
+func (s *Store) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if err := s.write(data); err != nil {
+		s.mu.Lock()
+		s.dirty = true // retry on the next save
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *Store) write(data []byte) error {
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	// Write to a temp file and rename so a crash never leaves a torn file.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
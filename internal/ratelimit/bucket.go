@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket that hands out reservations in call order.
+// Callers that want n tokens are told how long to wait rather than being
+// blocked on a lock, so concurrent streams take turns instead of one large
+// writer starving the others.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	windowStart time.Time
+	windowUsed  float64
+	lastRate    float64
+}
+
+func NewBucket(rate, burst float64) *Bucket {
+	if burst < rate {
+		burst = rate
+	}
+
+	now := time.Now()
+	return &Bucket{
+		rate:        rate,
+		burst:       burst,
+		tokens:      burst,
+		last:        now,
+		windowStart: now,
+	}
+}
+
+func (b *Bucket) Rate() float64 {
+	if b == nil {
+		return 0
+	}
+	return b.rate
+}
+
+func (b *Bucket) Reserve(n int) time.Duration {
+	if b == nil || b.rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refill(now)
+	b.record(now, float64(n))
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *Bucket) Allow() bool {
+	if b == nil || b.rate <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	b.record(now, 1)
+	return true
+}
+
+// refund returns a token taken by Allow, for when a later check turned the
+// request down after all.
+func (b *Bucket) refund() {
+	if b == nil || b.rate <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+1, b.burst)
+	b.windowUsed = max(b.windowUsed-1, 0)
+}
+
+// Usage returns the amount consumed per second over the last full second.
+func (b *Bucket) Usage() float64 {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.record(time.Now(), 0)
+	return b.lastRate
+}
+
+func (b *Bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+func (b *Bucket) record(now time.Time, n float64) {
+	elapsed := now.Sub(b.windowStart)
+	if elapsed >= time.Second {
+		if elapsed >= 2*time.Second {
+			b.lastRate = 0
+		} else {
+			b.lastRate = b.windowUsed / elapsed.Seconds()
+		}
+		b.windowStart = now
+		b.windowUsed = 0
+	}
+	b.windowUsed += n
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	b := NewBucket(1000, 1000)
+
+	if d := b.Reserve(1000); d != 0 {
+		t.Fatalf("expected burst to be available, got wait %v", d)
+	}
+
+	d := b.Reserve(500)
+	if d < 400*time.Millisecond || d > 600*time.Millisecond {
+		t.Fatalf("expected ~500ms wait, got %v", d)
+	}
+}
+
+func TestBucketReservationsQueueInOrder(t *testing.T) {
+	b := NewBucket(1000, 1000)
+	b.Reserve(1000)
+
+	first := b.Reserve(100)
+	second := b.Reserve(100)
+	if second <= first {
+		t.Fatalf("expected later reservation to wait longer: %v <= %v", second, first)
+	}
+}
+
+func TestBucketAllow(t *testing.T) {
+	b := NewBucket(2, 2)
+
+	if !b.Allow() || !b.Allow() {
+		t.Fatalf("expected burst of 2 to be allowed")
+	}
+	if b.Allow() {
+		t.Fatalf("expected third request to be limited")
+	}
+}
+
+func TestUnlimitedChain(t *testing.T) {
+	chain := Chain{New("server", Limits{}), nil}
+
+	start := time.Now()
+	chain.WaitBytes(10 << 20)
+	if time.Since(start) > 10*time.Millisecond {
+		t.Fatalf("unlimited chain should not wait")
+	}
+	if !chain.AllowStream() {
+		t.Fatalf("unlimited chain should allow streams")
+	}
+}
+
+func TestChainRejectionLeavesOtherLevels(t *testing.T) {
+	server := New("server", Limits{StreamsPerSecond: 2})
+	token := New("token", Limits{StreamsPerSecond: 2})
+	tunnel := New("tunnel", Limits{StreamsPerSecond: 1})
+	chain := Chain{server, token, nil, tunnel}
+
+	if !chain.AllowStream() {
+		t.Fatalf("expected the first stream to be allowed")
+	}
+	// The tunnel is now out of tokens; rejecting it must not drain the
+	// shared levels.
+	for range 10 {
+		if chain.AllowStream() {
+			t.Fatalf("expected the tunnel limit to reject the stream")
+		}
+	}
+
+	other := Chain{server, token, New("other", Limits{})}
+	if !other.AllowStream() {
+		t.Fatalf("rejected streams used up the server and token buckets")
+	}
+	if other.AllowStream() {
+		t.Fatalf("expected the shared burst of 2 to be used up")
+	}
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import "time"
+
+const minBurstBytes = 64 * 1024
+
+type Limits struct {
+	BytesPerSecond   float64 `yaml:"bytes_per_second" json:"bytes_per_second"`
+	StreamsPerSecond float64 `yaml:"streams_per_second" json:"streams_per_second"`
+}
+
+func (l Limits) Unlimited() bool {
+	return l.BytesPerSecond <= 0 && l.StreamsPerSecond <= 0
+}
+
+type Limiter struct {
+	Name    string
+	Bytes   *Bucket
+	Streams *Bucket
+}
+
+func New(name string, limits Limits) *Limiter {
+	l := &Limiter{Name: name}
+
+	if limits.BytesPerSecond > 0 {
+		l.Bytes = NewBucket(limits.BytesPerSecond, max(limits.BytesPerSecond, minBurstBytes))
+	}
+	if limits.StreamsPerSecond > 0 {
+		l.Streams = NewBucket(limits.StreamsPerSecond, limits.StreamsPerSecond)
+	}
+
+	return l
+}
+
+type Usage struct {
+	Name             string  `json:"name"`
+	BytesPerSecond   float64 `json:"bytes_per_second"`
+	BytesLimit       float64 `json:"bytes_limit,omitempty"`
+	StreamsPerSecond float64 `json:"streams_per_second"`
+	StreamsLimit     float64 `json:"streams_limit,omitempty"`
+}
+
+func (l *Limiter) Usage() Usage {
+	return Usage{
+		Name:             l.Name,
+		BytesPerSecond:   l.Bytes.Usage(),
+		BytesLimit:       l.Bytes.Rate(),
+		StreamsPerSecond: l.Streams.Usage(),
+		StreamsLimit:     l.Streams.Rate(),
+	}
+}
+
+// Chain applies several limiters at once, e.g. server-wide, per token and
+// per tunnel. A transfer has to fit within every level.
+type Chain []*Limiter
+
+func (c Chain) WaitBytes(n int) {
+	var wait time.Duration
+	for _, l := range c {
+		if l == nil {
+			continue
+		}
+		if d := l.Bytes.Reserve(n); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// AllowStream takes a stream token from every level, or from none: when one
+// level refuses, the tokens already taken from the others are given back so
+// a tunnel over its own limit does not use up the shared ones.
+func (c Chain) AllowStream() bool {
+	for i, l := range c {
+		if l != nil && !l.Streams.Allow() {
+			for _, taken := range c[:i] {
+				if taken != nil {
+					taken.Streams.refund()
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (c Chain) Usage() []Usage {
+	usage := make([]Usage, 0, len(c))
+	for _, l := range c {
+		if l != nil {
+			usage = append(usage, l.Usage())
+		}
+	}
+	return usage
+}
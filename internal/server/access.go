@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+type AccessList struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func ParseAccessList(allow, deny []string) (*AccessList, error) {
+	a := &AccessList{}
+
+	var err error
+	if a.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *AccessList) Empty() bool {
+	return a == nil || (len(a.allow) == 0 && len(a.deny) == 0)
+}
+
+func (a *AccessList) Allowed(ip netip.Addr) bool {
+	if a.Empty() {
+		return true
+	}
+
+	ip = ip.Unmap()
+
+	for _, p := range a.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(a.allow) == 0 {
+		return true
+	}
+
+	for _, p := range a.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func RemoteIP(conn net.Conn) netip.Addr {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.AddrPort().Addr().Unmap()
+	}
+
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", entry, err)
+			}
+			ip = ip.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
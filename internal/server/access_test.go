@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	acl, err := ParseAccessList(
+		[]string{"203.0.113.0/24", "2001:db8::/32"},
+		[]string{"203.0.113.99"},
+	)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.10", true},
+		{"203.0.113.99", false},
+		{"::ffff:203.0.113.10", true},
+		{"198.51.100.1", false},
+		{"2001:db8::1", true},
+	}
+
+	for _, tt := range tests {
+		if got := acl.Allowed(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestAccessListEmptyAllowsAll(t *testing.T) {
+	var acl *AccessList
+	if !acl.Allowed(netip.MustParseAddr("198.51.100.1")) {
+		t.Fatalf("nil access list should allow all")
+	}
+}
+
+func TestAccessListInvalid(t *testing.T) {
+	if _, err := ParseAccessList([]string{"not-a-cidr"}, nil); err == nil {
+		t.Fatalf("expected error for invalid CIDR")
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bakare-dev/gotunnel/internal/quota"
+	"github.com/bakare-dev/gotunnel/internal/ratelimit"
+)
+
+type TunnelInfo struct {
+	Port          int               `json:"port"`
+	Standby       bool              `json:"standby"`
+	ExposeAddr    string            `json:"expose_addr"`
+	Online        bool              `json:"online"`
+	ActiveStreams int               `json:"active_streams"`
+	TotalStreams  int64             `json:"total_streams"`
+	BytesSent     int64             `json:"bytes_sent"`
+	BytesReceived int64             `json:"bytes_received"`
+	Denied        int64             `json:"denied_connections"`
+	RateLimited   int64             `json:"rate_limited"`
+	Limits        []ratelimit.Usage `json:"limits"`
+}
+
+type Admin struct {
+	router *Router
+	quotas *quota.Manager
+	guard  *AuthGuard
+}
+
+func NewAdmin(router *Router, quotas *quota.Manager, guard *AuthGuard) *Admin {
+	return &Admin{router: router, quotas: quotas, guard: guard}
+}
+
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tunnels", a.handleTunnels)
+	mux.HandleFunc("GET /api/quotas", a.handleQuotas)
+	mux.HandleFunc("GET /api/lockouts", a.handleLockouts)
+	return mux
+}
+
+func (a *Admin) ListenAndServe(addr string) {
+	log.Printf("│ INFO  │ Admin API listening on %s", addr)
+	if err := http.ListenAndServe(addr, a.Handler()); err != nil {
+		log.Printf("│ ERROR │ Admin API stopped: %v", err)
+	}
+}
+
+func (a *Admin) handleTunnels(w http.ResponseWriter, r *http.Request) {
+	routes := a.router.Routes()
+	tunnels := make([]TunnelInfo, 0, len(routes))
+
+	for _, route := range routes {
+		sess := route.Session
+		sent, recv := sess.Metrics.GetBandwidth()
+
+		tunnels = append(tunnels, TunnelInfo{
+			Port:          route.Port,
+			Standby:       route.Standby,
+			ExposeAddr:    sess.ExposeAddr,
+			Online:        !sess.IsClosed(),
+			ActiveStreams: sess.Metrics.GetActiveStreams(),
+			TotalStreams:  sess.Metrics.GetTotalStreams(),
+			BytesSent:     sent,
+			BytesReceived: recv,
+			Denied:        sess.Metrics.GetDeniedConnections(),
+			RateLimited:   sess.Metrics.GetRateLimited(),
+			Limits:        sess.Limiter.Usage(),
+		})
+	}
+
+	writeJSON(w, tunnels)
+}
+
+func (a *Admin) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	if a.quotas == nil {
+		writeJSON(w, map[string]quota.Usage{})
+		return
+	}
+	writeJSON(w, a.quotas.Usage())
+}
+
+func (a *Admin) handleLockouts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.guard.Stats())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("│ ERROR │ Admin API encode failed: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/auth"
+	"github.com/bakare-dev/gotunnel/internal/pki"
+)
+
+const (
+	CertReloadInterval = 5 * time.Second
+
+	issuedCertValidity = 30 * 24 * time.Hour
+	maxIssuedCerts     = 1024
+)
+
+var ErrNoCertificate = errors.New("no certificate for server name")
+
+// CertStore serves public HTTPS certificates. Certificates are loaded from
+// <name>.crt/<name>.key pairs in a directory and indexed by their SANs, so
+// file names do not matter. Names without a file are signed on the fly by
+// an optional CA as a wildcard for the parent domain, but only when they
+// match the configured domains or the host restrictions of the tunnel.
+type CertStore struct {
+	dir     string
+	ca      *pki.Authority
+	domains []string
+
+	mu        sync.RWMutex
+	certs     map[string]*tls.Certificate
+	fallback  *tls.Certificate
+	issued    map[string]*tls.Certificate
+	signature string
+}
+
+func NewCertStore(dir string, ca *pki.Authority, domains []string) (*CertStore, error) {
+	s := &CertStore{
+		dir:     dir,
+		ca:      ca,
+		domains: domains,
+		certs:   make(map[string]*tls.Certificate),
+		issued:  make(map[string]*tls.Certificate),
+	}
+
+	if dir != "" {
+		if _, err := s.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// TLSConfig serves certificates for one tunnel; allowed reports whether the
+// CA may sign a name beyond the configured domains, and may be nil.
+func (s *CertStore) TLSConfig(allowed func(name string) bool) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate(hello, allowed)
+		},
+		MinVersion: tls.VersionTLS12,
+		// Traffic is forwarded as plaintext, so h2 is only offered by
+		// tunnels whose local service speaks h2c.
+		NextProtos: []string{"http/1.1"},
+	}
+}
+
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.certificate(hello, nil)
+}
+
+func (s *CertStore) certificate(hello *tls.ClientHelloInfo, allowed func(string) bool) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	permits := func(name string) bool {
+		return auth.MatchHost(s.domains, name) || (allowed != nil && allowed(name))
+	}
+	mayIssue := name != "" && permits(name)
+
+	s.mu.RLock()
+	cert := s.lookup(name)
+	fallback := s.fallback
+	s.mu.RUnlock()
+
+	if cert != nil {
+		return cert, nil
+	}
+	if fallback != nil && name == "" {
+		return fallback, nil
+	}
+	if name == "" && hello.Conn != nil {
+		// Clients connecting by IP send no SNI; certify the local address.
+		if host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String()); err == nil {
+			name, mayIssue = host, true
+		}
+	}
+	if s.ca != nil && name != "" && mayIssue {
+		return s.issue(name, permits)
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrNoCertificate, name)
+}
+
+func (s *CertStore) lookup(name string) *tls.Certificate {
+	if cert, ok := s.certs[name]; ok {
+		return cert
+	}
+	if wildcard := wildcardFor(name); wildcard != "" {
+		if cert, ok := s.certs[wildcard]; ok {
+			return cert
+		}
+		if cert, ok := s.issued[wildcard]; ok {
+			return cert
+		}
+	}
+	return s.issued[name]
+}
+
+// issue signs a certificate for name, as a wildcard for its parent domain
+// when the wildcard itself is permitted.
+func (s *CertStore) issue(name string, permits func(string) bool) (*tls.Certificate, error) {
+	hosts := []string{name}
+	key := name
+	if wildcard := wildcardFor(name); wildcard != "" && permits(wildcard) {
+		hosts = []string{wildcard, name}
+		key = wildcard
+	}
+
+	leaf, signer, err := s.ca.Issue(pki.Request{
+		Hosts:    hosts,
+		Validity: issuedCertValidity,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, s.ca.Cert.Raw},
+		PrivateKey:  signer,
+		Leaf:        leaf,
+	}
+
+	s.mu.Lock()
+	if len(s.issued) >= maxIssuedCerts {
+		s.issued = make(map[string]*tls.Certificate)
+	}
+	s.issued[key] = cert
+	s.mu.Unlock()
+
+	log.Printf("│ INFO  │ Issued public certificate for %s", strings.Join(hosts, ", "))
+	return cert, nil
+}
+
+// Reload re-reads the certificate directory if anything in it changed. It
+// reports whether a reload happened; on error the previous set stays active.
+func (s *CertStore) Reload() (bool, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate dir: %w", err)
+	}
+
+	signature := dirSignature(entries)
+
+	s.mu.RLock()
+	unchanged := signature == s.signature
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certs := make(map[string]*tls.Certificate)
+	var fallback *tls.Certificate
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".crt" {
+			continue
+		}
+
+		certFile := filepath.Join(s.dir, name)
+		keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to load %s: %w", certFile, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return false, err
+			}
+		}
+
+		if fallback == nil {
+			fallback = &cert
+		}
+		for _, host := range certNames(cert.Leaf) {
+			certs[host] = &cert
+		}
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.fallback = fallback
+	s.signature = signature
+	s.mu.Unlock()
+
+	log.Printf("│ INFO  │ Loaded %d public certificate names from %s", len(certs), s.dir)
+	return true, nil
+}
+
+func (s *CertStore) Watch(ctx context.Context) {
+	if s.dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(CertReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Reload(); err != nil {
+				log.Printf("│ WARN  │ Certificate reload failed, keeping previous set: %v", err)
+			}
+		}
+	}
+}
+
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, name := range cert.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(cert.Subject.CommonName))
+	}
+	return names
+}
+
+func wildcardFor(name string) string {
+	if net.ParseIP(name) != nil {
+		return ""
+	}
+	_, parent, ok := strings.Cut(name, ".")
+	if !ok || !strings.Contains(parent, ".") {
+		return ""
+	}
+	return "*." + parent
+}
+
+func dirSignature(entries []os.DirEntry) string {
+	parts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", entry.Name(), info.Size(), info.ModTime().UnixNano()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/pki"
+)
+
+func writePair(t *testing.T, ca *pki.Authority, dir, name string, hosts ...string) {
+	t.Helper()
+
+	cert, key, err := ca.Issue(pki.Request{Hosts: hosts, Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.WriteFiles(cert, key, filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertStoreSelectsBySAN(t *testing.T) {
+	ca, _ := pki.NewCA("Test CA", pki.KeyECDSA, time.Hour)
+	dir := t.TempDir()
+	writePair(t, ca, dir, "api", "api.example.com")
+	writePair(t, ca, dir, "wildcard", "*.dev.example.com")
+
+	store, err := NewCertStore(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "API.example.com"})
+	if err != nil || cert.Leaf.DNSNames[0] != "api.example.com" {
+		t.Fatalf("exact match: got %v, %v", cert, err)
+	}
+
+	cert, err = store.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.dev.example.com"})
+	if err != nil || cert.Leaf.DNSNames[0] != "*.dev.example.com" {
+		t.Fatalf("wildcard match: got %v, %v", cert, err)
+	}
+
+	// Unknown names get the default certificate rather than a failed handshake.
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.org"}); err != nil {
+		t.Fatalf("expected default certificate, got %v", err)
+	}
+
+	empty, _ := NewCertStore("", nil, nil)
+	if _, err := empty.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.org"}); err == nil {
+		t.Fatal("expected error from an empty store")
+	}
+}
+
+func TestCertStoreIssuesWildcard(t *testing.T) {
+	ca, _ := pki.NewCA("Test CA", pki.KeyECDSA, time.Hour)
+
+	store, err := NewCertStore("", ca, []string{"*.tunnel.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.tunnel.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Leaf.DNSNames[0] != "*.tunnel.example.com" {
+		t.Fatalf("expected wildcard, got %v", first.Leaf.DNSNames)
+	}
+
+	second, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.tunnel.example.com"})
+	if second != first {
+		t.Fatal("expected sibling name to reuse the issued wildcard")
+	}
+}
+
+func TestCertStoreRefusesUnknownNames(t *testing.T) {
+	ca, _ := pki.NewCA("Test CA", pki.KeyECDSA, time.Hour)
+
+	store, err := NewCertStore("", ca, []string{"*.tunnel.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bank.example.org", "a.b.tunnel.example.com"} {
+		if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); !errors.Is(err, ErrNoCertificate) {
+			t.Errorf("%s: expected ErrNoCertificate, got %v", name, err)
+		}
+	}
+
+	// A tunnel restricted to an exact host gets that name, not a wildcard.
+	config := store.TLSConfig(func(name string) bool { return name == "app.example.org" })
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := cert.Leaf.DNSNames; len(names) != 1 || names[0] != "app.example.org" {
+		t.Fatalf("expected a certificate for app.example.org only, got %v", names)
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	ca, _ := pki.NewCA("Test CA", pki.KeyECDSA, time.Hour)
+	dir := t.TempDir()
+	writePair(t, ca, dir, "api", "api.example.com")
+
+	store, err := NewCertStore(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed, _ := store.Reload(); changed {
+		t.Fatal("expected no reload without changes")
+	}
+
+	writePair(t, ca, dir, "web", "web.example.com")
+	if changed, err := store.Reload(); !changed || err != nil {
+		t.Fatalf("expected reload, got %v, %v", changed, err)
+	}
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "web.example.com"}); err != nil {
+		t.Fatalf("new certificate not served: %v", err)
+	}
+
+	// A broken pair must not take down the certificates already loaded.
+	os.WriteFile(filepath.Join(dir, "broken.crt"), []byte("junk"), 0644)
+	if _, err := store.Reload(); err == nil {
+		t.Fatal("expected reload error for broken pair")
+	}
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.example.com"}); err != nil {
+		t.Fatalf("previous certificates lost after failed reload: %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -11,6 +15,11 @@ import (
 	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
+const (
+	publicAuthTimeout = 10 * time.Second
+	publicTLSTimeout  = 10 * time.Second
+)
+
 func (p *PublicListener) handleConn(conn net.Conn) {
 	defer conn.Close()
 
@@ -26,59 +35,183 @@ func (p *PublicListener) handleConn(conn net.Conn) {
 		return
 	}
 
+	policy := p.policy(sess)
+
+	ip := RemoteIP(conn)
+	if !p.config.Access.Allowed(ip) || !policy.Access.Allowed(ip) {
+		sess.Metrics.RecordDenied()
+		log.Printf("│ WARN  │ [Port %d] Denied connection from %s", port, ip)
+		return
+	}
+
+	open := &protocol.StreamOpen{
+		RemoteAddr: conn.RemoteAddr().String(),
+		LocalAddr:  conn.LocalAddr().String(),
+	}
+	h2 := false
+
+	if policy.TLS {
+		tlsConn, err := p.terminateTLS(conn, policy)
+		if err != nil {
+			log.Printf("│ WARN  │ [Port %d] TLS handshake with %s failed: %v", port, ip, err)
+			return
+		}
+		defer tlsConn.Close()
+
+		conn = tlsConn
+		open.TLS = true
+		open.ServerName = tlsConn.ConnectionState().ServerName
+		h2 = tlsConn.ConnectionState().NegotiatedProtocol == "h2"
+
+		if !policy.AllowsHost(open.ServerName) {
+			sess.Metrics.RecordDenied()
+			log.Printf("│ WARN  │ [Port %d] Host %q not allowed for this tunnel", port, open.ServerName)
+			return
+		}
+	}
+
+	// Bytes consumed while inspecting the request are replayed into the
+	// stream. Visitors that negotiated h2 are known to speak HTTP.
+	var src io.Reader = conn
+	if policy.Auth.Enabled() || (policy.HTTPOnly && !h2) {
+		head, err := readPublicHead(conn)
+
+		switch {
+		case policy.HTTPOnly && errors.Is(err, tunnel.ErrNotHTTPRequest):
+			sess.Metrics.RecordDenied()
+			log.Printf("│ WARN  │ [Port %d] Rejected non-HTTP connection from %s", port, ip)
+			return
+		case policy.Auth.Enabled() && (err != nil || !policy.Auth.Authorize(head)):
+			conn.Write(policy.Auth.Challenge())
+			sess.Metrics.RecordDenied()
+			log.Printf("│ WARN  │ [Port %d] Rejected unauthenticated request from %s", port, ip)
+			return
+		case err != nil:
+			return
+		}
+		src = io.MultiReader(bytes.NewReader(head), conn)
+	}
+
+	if !sess.Limiter.AllowStream() {
+		sess.Metrics.RecordRateLimited()
+		log.Printf("│ WARN  │ [Port %d] Stream rate limit exceeded, rejecting %s", port, ip)
+		return
+	}
+
+	if policy.MaxStreams > 0 {
+		if !p.acquireStream(sess.Identity, policy.MaxStreams) {
+			sess.Metrics.RecordRateLimited()
+			log.Printf("│ WARN  │ [Port %d] Stream limit of %d reached, rejecting %s", port, policy.MaxStreams, ip)
+			return
+		}
+		defer p.releaseStream(sess.Identity)
+	}
+
 	stream := sess.Streams().Open()
-	sess.Metrics.StreamOpened()
 
-	httpLog := &tunnel.HTTPLog{
-		StartTime: time.Now(),
+	pipeline, err := p.chain(policy).Open(&tunnel.StreamInfo{
+		ID:         stream.ID,
+		RemoteAddr: open.RemoteAddr,
+		LocalAddr:  open.LocalAddr,
+		TLS:        open.TLS,
+		ServerName: open.ServerName,
+		Metrics:    sess.Metrics,
+	})
+	if err != nil {
+		sess.Streams().Close(stream.ID)
+		sess.Metrics.RecordDenied()
+		log.Printf("│ WARN  │ [Port %d] Rejected by middleware: %v", port, err)
+		return
 	}
-	var firstRequest []byte
+	defer pipeline.Close()
+
+	sess.Metrics.StreamOpened()
 
 	if err := sess.WriteFrame(&protocol.Frame{
 		Type:     protocol.MsgStreamOpen,
 		StreamID: stream.ID,
+		Payload:  open.Encode(),
 	}); err != nil {
 		log.Printf("│ ERROR │ [Stream %d] Failed to send StreamOpen: %v", stream.ID, err)
 		sess.Metrics.StreamClosed()
 		return
 	}
 
+	// The head checked above only covers the first request; later requests
+	// on a keep-alive connection need credentials too.
+	var gate *tunnel.AuthGate
+	if policy.Auth.Enabled() {
+		gate = policy.Auth.Gate()
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		buf := make([]byte, 4096)
-		isFirstPacket := true
+		buf := protocol.NewReadBuffer()
+		defer buf.Release()
 
 		for {
 			if sess.IsClosed() {
 				return
 			}
 
-			n, err := conn.Read(buf)
+			data, err := buf.Read(src)
+			if err == io.EOF {
+				// Send what the middlewares still hold before closing.
+				data, err = pipeline.FlushInbound()
+				if err != nil {
+					log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", stream.ID, err)
+				} else if len(data) > 0 {
+					_ = sess.WriteFrame(&protocol.Frame{
+						Type:     protocol.MsgStreamData,
+						StreamID: stream.ID,
+						Payload:  data,
+					})
+				}
+				break
+			}
 			if err != nil {
-				if err != io.EOF {
+				if !errors.Is(err, net.ErrClosed) {
 					log.Printf("│ DEBUG │ [Stream %d] Public read error: %v", stream.ID, err)
 				}
 				break
 			}
 
-			if isFirstPacket {
-				isFirstPacket = false
-				firstRequest = make([]byte, n)
-				copy(firstRequest, buf[:n])
-				httpLog.Request = tunnel.ParseHTTPRequest(firstRequest)
+			var denied error
+			if gate != nil {
+				data, denied = gate.Check(data)
 			}
 
-			if err := sess.WriteFrame(&protocol.Frame{
-				Type:     protocol.MsgStreamData,
-				StreamID: stream.ID,
-				Payload:  buf[:n],
-			}); err != nil {
-				if err != protocol.ErrSessionExpired {
-					log.Printf("│ ERROR │ [Stream %d] Failed to forward to tunnel: %v", stream.ID, err)
+			if len(data) > 0 {
+				data, err = pipeline.Inbound(data)
+				if err != nil {
+					log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", stream.ID, err)
+					conn.Close()
+					break
 				}
+			}
+
+			if len(data) > 0 {
+				if err := sess.WriteFrame(&protocol.Frame{
+					Type:     protocol.MsgStreamData,
+					StreamID: stream.ID,
+					Payload:  data,
+				}); err != nil {
+					if err != protocol.ErrSessionExpired {
+						log.Printf("│ ERROR │ [Stream %d] Failed to forward to tunnel: %v", stream.ID, err)
+					}
+					break
+				}
+			}
+
+			// The connection may be shared by several visitors behind a
+			// proxy, so one refused request ends it.
+			if denied != nil {
+				sess.Metrics.RecordDenied()
+				log.Printf("│ WARN  │ [Port %d] Rejected unauthenticated request from %s", port, ip)
+				conn.Close()
 				break
 			}
 		}
@@ -91,41 +224,70 @@ func (p *PublicListener) handleConn(conn net.Conn) {
 
 	go func() {
 		defer wg.Done()
-		isFirstPacket := true
-
-		for {
-			select {
-			case data, ok := <-stream.In:
-				if !ok {
-					return
-				}
-
-				if isFirstPacket && httpLog.Request != nil {
-					isFirstPacket = false
-					httpLog.Response = tunnel.ParseHTTPResponse(data)
-					httpLog.Duration = time.Since(httpLog.StartTime)
-
-					if httpLog.Response != nil {
-						sess.Metrics.RecordHTTPRequest(httpLog.Response.StatusCode, httpLog.Duration)
-					}
 
-					if logStr := httpLog.String(); logStr != "" {
-						log.Printf("│ HTTP  │ %s", logStr)
-					}
-				}
+		// Closing a stream also closes In, so ranging over it delivers
+		// data that arrived just before MsgStreamClose instead of racing
+		// stream.Done() and dropping the tail of the response.
+		for data := range stream.In {
+			out, err := pipeline.Outbound(data)
+			if err != nil {
+				protocol.PutBuffer(data)
+				log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", stream.ID, err)
+				conn.Close()
+				return
+			}
 
-				if _, err := conn.Write(data); err != nil {
-					log.Printf("│ ERROR │ [Stream %d] Failed to write to public: %v", stream.ID, err)
-					return
-				}
+			sess.Limiter.WaitBytes(len(out))
 
-			case <-stream.Done():
+			_, err = conn.Write(out)
+			protocol.PutBuffer(data)
+			if err != nil {
+				log.Printf("│ ERROR │ [Stream %d] Failed to write to public: %v", stream.ID, err)
 				return
 			}
 		}
+
+		// The client closed or lost the stream; write out what the
+		// middlewares still hold, then stop the public reader too.
+		if out, err := pipeline.FlushOutbound(); err != nil {
+			log.Printf("│ WARN  │ [Stream %d] Rejected by middleware: %v", stream.ID, err)
+		} else if len(out) > 0 {
+			conn.Write(out)
+		}
+		conn.Close()
 	}()
 
 	wg.Wait()
 	sess.Streams().Close(stream.ID)
 	sess.Metrics.StreamClosed()
 }
+
+func (p *PublicListener) terminateTLS(conn net.Conn, policy *TunnelPolicy) (*tls.Conn, error) {
+	if p.config.Certs == nil {
+		return nil, ErrPublicTLSUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publicTLSTimeout)
+	defer cancel()
+
+	config := p.config.Certs.TLSConfig(func(name string) bool {
+		return len(policy.Hosts) > 0 && policy.AllowsHost(name)
+	})
+	// Public auth reads the HTTP/1.1 request head, so it keeps h2 off.
+	if policy.HTTP2 && !policy.Auth.Enabled() {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	tlsConn := tls.Server(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func readPublicHead(conn net.Conn) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(publicAuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	return tunnel.ReadRequestHead(conn)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("failed to parse client CA %s", path)
+	}
+	return pool, nil
+}
+
+// PeerIdentity completes the TLS handshake and returns the identity carried
+// by the verified client certificate, or "" for plain TCP connections and
+// TLS connections without a client certificate.
+func PeerIdentity(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		// Transports such as WebSocket finish TLS before handing us the
+		// connection and expose the result instead.
+		if stater, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+			return certsIdentity(stater.ConnectionState().PeerCertificates), nil
+		}
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return "", err
+	}
+
+	return certsIdentity(tlsConn.ConnectionState().PeerCertificates), nil
+}
+
+func certsIdentity(certs []*x509.Certificate) string {
+	if len(certs) == 0 {
+		return ""
+	}
+	return CertIdentity(certs[0])
+}
+
+func CertIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestCertIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/agent")
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{"dns", &x509.Certificate{DNSNames: []string{"agent.example.org"}, Subject: pkix.Name{CommonName: "cn"}}, "agent.example.org"},
+		{"uri", &x509.Certificate{URIs: []*url.URL{spiffe}}, "spiffe://example.org/agent"},
+		{"email", &x509.Certificate{EmailAddresses: []string{"ops@example.org"}}, "ops@example.org"},
+		{"cn", &x509.Certificate{Subject: pkix.Name{CommonName: "agent-1"}}, "agent-1"},
+	}
+
+	for _, tt := range tests {
+		if got := CertIdentity(tt.cert); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
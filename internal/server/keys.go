@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/auth"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/quota"
+)
+
+// KeyLogin verifies the public key login of one control connection against
+// the authorized keys and remembers the key it accepted.
+type KeyLogin struct {
+	keys *auth.KeyStore
+	Key  *auth.AuthorizedKey
+}
+
+func NewKeyLogin(keys *auth.KeyStore) *KeyLogin {
+	return &KeyLogin{keys: keys}
+}
+
+// Verify implements protocol.KeyVerifier.
+func (l *KeyLogin) Verify(pub ed25519.PublicKey, serverNonce, clientNonce, sig []byte) (string, error) {
+	if l.keys == nil {
+		return "", protocol.ErrAuthFailed
+	}
+	key, ok := l.keys.Lookup(pub)
+	if !ok || !auth.Verify(key.Key, serverNonce, clientNonce, sig) {
+		return "", protocol.ErrAuthFailed
+	}
+	if key.Options.Expired(time.Now()) {
+		return "", protocol.ErrKeyExpired
+	}
+	l.Key = key
+	return key.Fingerprint(), nil
+}
+
+// CheckKeyOptions applies the authorized_keys options of the key a client
+// authenticated with to its bind request. active is the number of tunnels
+// the key already holds.
+func CheckKeyOptions(key *auth.AuthorizedKey, bind protocol.BindOptions, active int) error {
+	opts := key.Options
+
+	if opts.RestrictsPorts() {
+		if bind.Port == 0 {
+			return &protocol.BindError{Code: protocol.CodePortNotAllowed, Message: "this key requires a port to be requested with --port"}
+		}
+		if !opts.AllowsPort(int(bind.Port)) {
+			return &protocol.BindError{Code: protocol.CodePortNotAllowed, Message: fmt.Sprintf("port %d is not allowed for this key", bind.Port)}
+		}
+	}
+
+	if len(opts.Hosts) > 0 && !bind.HTTPS {
+		return &protocol.BindError{Code: protocol.CodeHostNotAllowed, Message: "this key is limited to hostnames; request an --https tunnel"}
+	}
+
+	if opts.MaxTunnels > 0 && active >= opts.MaxTunnels {
+		return &protocol.BindError{Code: protocol.CodeTooManyTunnels, Message: fmt.Sprintf("limit of %d tunnels reached for this key", opts.MaxTunnels)}
+	}
+
+	return nil
+}
+
+// BindErrorFor turns any bind failure into the structured form sent to
+// clients.
+func BindErrorFor(err error) *protocol.BindError {
+	var bindErr *protocol.BindError
+	var quotaErr *quota.ExceededError
+
+	switch {
+	case errors.As(err, &bindErr):
+		return bindErr
+	case errors.As(err, &quotaErr):
+		return &protocol.BindError{Code: protocol.CodeQuotaExceeded, Message: quotaErr.Reason}
+	case errors.Is(err, ErrPortInUse), errors.Is(err, ErrStandbyInUse):
+		return &protocol.BindError{Code: protocol.CodePortInUse, Message: err.Error()}
+	case errors.Is(err, ErrPublicTLSUnavailable):
+		return &protocol.BindError{Code: protocol.CodeTLSUnavailable, Message: err.Error()}
+	case errors.Is(err, ErrUnknownSession), errors.Is(err, ErrJoinIdentity),
+		errors.Is(err, protocol.ErrTooManyLanes), errors.Is(err, protocol.ErrInvalidTicket):
+		return &protocol.BindError{Code: protocol.CodeJoinRejected, Message: err.Error()}
+	}
+	return &protocol.BindError{Code: protocol.CodeBindRejected, Message: err.Error()}
+}
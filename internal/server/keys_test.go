@@ -0,0 +1,40 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bakare-dev/gotunnel/internal/auth"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+)
+
+func TestKeyLogin(t *testing.T) {
+	pub, priv, _ := auth.GenerateKey()
+	oldPub, oldPriv, _ := auth.GenerateKey()
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	data := auth.MarshalPublicKey(pub, "alice") + "\n" +
+		`expires="2020-01-01" ` + auth.MarshalPublicKey(oldPub, "old") + "\n"
+	os.WriteFile(path, []byte(data), 0600)
+	keys, err := auth.OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverNonce, _ := protocol.NewNonce()
+	clientNonce, _ := protocol.NewNonce()
+
+	login := NewKeyLogin(keys)
+	identity, err := login.Verify(pub, serverNonce, clientNonce, auth.Sign(priv, serverNonce, clientNonce))
+	if err != nil || identity != auth.Fingerprint(pub) || login.Key == nil {
+		t.Fatalf("expected login to succeed, got %q, %v", identity, err)
+	}
+
+	if _, err := NewKeyLogin(keys).Verify(pub, serverNonce, clientNonce, auth.Sign(oldPriv, serverNonce, clientNonce)); err != protocol.ErrAuthFailed {
+		t.Fatalf("expected a bad signature to fail, got %v", err)
+	}
+	if _, err := NewKeyLogin(keys).Verify(oldPub, serverNonce, clientNonce, auth.Sign(oldPriv, serverNonce, clientNonce)); err != protocol.ErrKeyExpired {
+		t.Fatalf("expected ErrKeyExpired, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+)
+
+var (
+	ErrUnknownSession = errors.New("no tunnel matches the join ticket")
+	ErrJoinIdentity   = errors.New("join ticket belongs to a different client")
+)
+
+// JoinRegistry tracks sessions that accept extra connections, keyed by the
+// ticket handed out in MsgBindOK.
+type JoinRegistry struct {
+	mu       sync.Mutex
+	sessions map[[16]byte]*protocol.Session
+}
+
+func NewJoinRegistry() *JoinRegistry {
+	return &JoinRegistry{
+		sessions: make(map[[16]byte]*protocol.Session),
+	}
+}
+
+// Register issues a ticket for sess. It is forgotten when the session ends.
+func (j *JoinRegistry) Register(sess *protocol.Session) (*protocol.JoinTicket, error) {
+	ticket, err := protocol.NewJoinTicket()
+	if err != nil {
+		return nil, err
+	}
+	sess.Ticket = ticket
+
+	j.mu.Lock()
+	j.sessions[ticket.ID] = sess
+	j.mu.Unlock()
+
+	go func() {
+		<-sess.Done()
+		j.mu.Lock()
+		delete(j.sessions, ticket.ID)
+		j.mu.Unlock()
+	}()
+
+	return ticket, nil
+}
+
+// Join finds the session a connection wants to join. The joining connection
+// must have authenticated as the same client that opened the tunnel.
+func (j *JoinRegistry) Join(encoded []byte, identity string) (*protocol.Session, error) {
+	ticket, err := protocol.DecodeJoinTicket(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	j.mu.Lock()
+	sess, ok := j.sessions[ticket.ID]
+	j.mu.Unlock()
+
+	if !ok || sess.IsClosed() || !sess.Ticket.Matches(ticket) {
+		return nil, ErrUnknownSession
+	}
+	if sess.Identity != identity {
+		return nil, ErrJoinIdentity
+	}
+	if sess.LaneCount() >= protocol.MaxLanes {
+		return nil, protocol.ErrTooManyLanes
+	}
+	return sess, nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+)
+
+func TestJoinRegistry(t *testing.T) {
+	joins := NewJoinRegistry()
+
+	sess := newTestSession()
+	sess.Identity = "alice"
+	ticket, err := joins.Register(sess)
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	if got, err := joins.Join(ticket.Encode(), "alice"); err != nil || got != sess {
+		t.Fatalf("expected join to find the session, got %v", err)
+	}
+	if _, err := joins.Join(ticket.Encode(), "mallory"); !errors.Is(err, ErrJoinIdentity) {
+		t.Fatalf("expected ErrJoinIdentity, got %v", err)
+	}
+
+	other, _ := protocol.NewJoinTicket()
+	if _, err := joins.Join(other.Encode(), "alice"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("expected ErrUnknownSession, got %v", err)
+	}
+
+	sess.Close()
+	if _, err := joins.Join(ticket.Encode(), "alice"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("expected closed session to be unjoinable, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bakare-dev/gotunnel/internal/config"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/ratelimit"
+)
+
+type RateLimiter struct {
+	config config.RateLimitConfig
+	global *ratelimit.Limiter
+
+	mu     sync.Mutex
+	tokens map[string]*ratelimit.Limiter
+}
+
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config: cfg,
+		global: ratelimit.New("server", cfg.Server),
+		tokens: make(map[string]*ratelimit.Limiter),
+	}
+}
+
+func (r *RateLimiter) Attach(sess *protocol.Session) {
+	sess.Limiter = ratelimit.Chain{
+		r.global,
+		r.tokenLimiter(sess.Identity),
+		ratelimit.New(fmt.Sprintf("tunnel:%d", sess.PublicPort), r.config.Tunnel),
+	}
+}
+
+// Token limiters are shared by every session authenticated with the same
+// token (or client certificate identity), so a user cannot get more
+// bandwidth by opening more tunnels.
+func (r *RateLimiter) tokenLimiter(token string) *ratelimit.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l, ok := r.tokens[token]; ok {
+		return l
+	}
+
+	limits, ok := r.config.Tokens[token]
+	if !ok {
+		limits = r.config.Token
+	}
+
+	l := ratelimit.New("token:"+maskToken(token), limits)
+	r.tokens[token] = l
+	return l
+}
+
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return token[:4] + "****"
+}
@@ -1,23 +1,117 @@
 package server
 
 import (
+	"errors"
 	"log"
 	"net"
 	"strconv"
+	"sync"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/tunnel"
 )
 
+var ErrPublicTLSUnavailable = errors.New("server has no public TLS certificates configured")
+
+type PublicConfig struct {
+	Access *AccessList
+	Certs  *CertStore
+
+	// Middleware runs on the streams of every tunnel, ahead of the
+	// tunnel's own middlewares and the built-in request logging.
+	Middleware tunnel.MiddlewareChain
+}
+
 type PublicListener struct {
 	router *Router
+	config PublicConfig
+
+	mu        sync.Mutex
+	listening map[int]bool
+	policies  map[*protocol.Session]*TunnelPolicy
+	streams   map[string]int // open streams per client identity
+}
+
+func NewPublicListener(router *Router, config PublicConfig) *PublicListener {
+	return &PublicListener{
+		router:    router,
+		config:    config,
+		listening: make(map[int]bool),
+		policies:  make(map[*protocol.Session]*TunnelPolicy),
+		streams:   make(map[string]int),
+	}
+}
+
+func (p *PublicListener) TLSEnabled() bool {
+	return p.config.Certs != nil
+}
+
+func (p *PublicListener) Register(sess *protocol.Session, policy *TunnelPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policies[sess] = policy
+}
+
+func (p *PublicListener) Unregister(sess *protocol.Session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.policies, sess)
 }
 
-func NewPublicListener(router *Router) *PublicListener {
-	return &PublicListener{router: router}
+func (p *PublicListener) policy(sess *protocol.Session) *TunnelPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if policy, ok := p.policies[sess]; ok {
+		return policy
+	}
+	return &TunnelPolicy{}
+}
+
+// acquireStream counts a public connection against a client identity,
+// across all of its tunnels, unless max are already open.
+func (p *PublicListener) acquireStream(identity string, max int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.streams[identity] >= max {
+		return false
+	}
+	p.streams[identity]++
+	return true
+}
+
+func (p *PublicListener) releaseStream(identity string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.streams[identity]--; p.streams[identity] <= 0 {
+		delete(p.streams, identity)
+	}
+}
+
+// chain assembles the middlewares for a tunnel's streams.
+func (p *PublicListener) chain(policy *TunnelPolicy) tunnel.MiddlewareChain {
+	chain := append(tunnel.MiddlewareChain{}, p.config.Middleware...)
+	chain = append(chain, policy.Middleware...)
+	return append(chain, tunnel.InspectMiddleware(tunnel.InspectConfig{}))
 }
 
 func (p *PublicListener) Listen(port int) {
+	p.mu.Lock()
+	if p.listening[port] {
+		p.mu.Unlock()
+		return
+	}
+	p.listening[port] = true
+	p.mu.Unlock()
+
 	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		log.Println("failed to bind public port", port, err)
+		p.mu.Lock()
+		delete(p.listening, port)
+		p.mu.Unlock()
 		return
 	}
 
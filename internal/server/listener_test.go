@@ -0,0 +1,23 @@
+package server
+
+import "testing"
+
+func TestStreamLimitSpansTunnels(t *testing.T) {
+	p := NewPublicListener(NewRouter(10000), PublicConfig{})
+
+	// Two tunnels of one client share the limit; another client does not.
+	if !p.acquireStream("alice", 2) || !p.acquireStream("alice", 2) {
+		t.Fatal("expected the first two streams to be allowed")
+	}
+	if p.acquireStream("alice", 2) {
+		t.Fatal("expected the third stream to be rejected")
+	}
+	if !p.acquireStream("bob", 2) {
+		t.Fatal("expected another client to have its own limit")
+	}
+
+	p.releaseStream("alice")
+	if !p.acquireStream("alice", 2) {
+		t.Fatal("expected a released stream to free a slot")
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/config"
+)
+
+const (
+	defaultMaxFailures      = 5
+	defaultLockout          = 30 * time.Second
+	defaultMaxLockout       = time.Hour
+	defaultHandshakeTimeout = 10 * time.Second
+
+	maxGuardEntries = 100000
+)
+
+var ErrLockedOut = errors.New("too many failed authentication attempts")
+
+type lockEntry struct {
+	failures    int
+	lockouts    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type LockoutInfo struct {
+	Key      string    `json:"key"`
+	Until    time.Time `json:"until"`
+	Lockouts int       `json:"lockouts"`
+}
+
+type GuardStats struct {
+	Failures int64         `json:"auth_failures"`
+	Lockouts int64         `json:"lockouts"`
+	Rejected int64         `json:"rejected_while_locked"`
+	Active   []LockoutInfo `json:"active"`
+}
+
+// AuthGuard counts authentication failures per key (client IP or
+// credential) and locks a key out after too many, doubling the lockout each
+// time it happens again. Counters decay once a key has been quiet for the
+// maximum lockout period.
+type AuthGuard struct {
+	maxFailures      int
+	baseLockout      time.Duration
+	maxLockout       time.Duration
+	HandshakeTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lockEntry
+	stats   GuardStats
+	now     func() time.Time
+}
+
+func NewAuthGuard(cfg config.LockoutConfig) *AuthGuard {
+	g := &AuthGuard{
+		maxFailures:      cfg.MaxFailures,
+		baseLockout:      time.Duration(cfg.LockoutSeconds) * time.Second,
+		maxLockout:       time.Duration(cfg.MaxLockoutSeconds) * time.Second,
+		HandshakeTimeout: time.Duration(cfg.HandshakeTimeoutSeconds) * time.Second,
+		entries:          make(map[string]*lockEntry),
+		now:              time.Now,
+	}
+
+	if g.maxFailures <= 0 {
+		g.maxFailures = defaultMaxFailures
+	}
+	if g.baseLockout <= 0 {
+		g.baseLockout = defaultLockout
+	}
+	if g.maxLockout <= 0 {
+		g.maxLockout = defaultMaxLockout
+	}
+	if g.HandshakeTimeout <= 0 {
+		g.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	return g
+}
+
+// Locked reports whether any of the keys is locked out, and counts the
+// rejection if so.
+func (g *AuthGuard) Locked(keys ...string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for _, key := range keys {
+		if e, ok := g.entries[key]; ok && now.Before(e.lockedUntil) {
+			g.stats.Rejected++
+			return true
+		}
+	}
+	return false
+}
+
+func (g *AuthGuard) Failure(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.stats.Failures++
+
+	if len(g.entries) >= maxGuardEntries {
+		g.prune(now)
+	}
+
+	for _, key := range keys {
+		e, ok := g.entries[key]
+		if !ok || now.Sub(e.lastFailure) > g.maxLockout {
+			e = &lockEntry{}
+			g.entries[key] = e
+		}
+
+		e.failures++
+		e.lastFailure = now
+		if e.failures < g.maxFailures {
+			continue
+		}
+
+		duration := g.baseLockout << e.lockouts
+		if duration > g.maxLockout || duration <= 0 {
+			duration = g.maxLockout
+		}
+		e.lockouts++
+		e.failures = 0
+		e.lockedUntil = now.Add(duration)
+		g.stats.Lockouts++
+
+		log.Printf("│ WARN  │ Locked out %s for %v after repeated auth failures", key, duration)
+	}
+}
+
+// Success clears the failure history of credential keys. Client IPs are
+// left alone so a valid login cannot be used to reset guessing from an IP.
+func (g *AuthGuard) Success(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range keys {
+		delete(g.entries, key)
+	}
+}
+
+func (g *AuthGuard) Stats() GuardStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := g.stats
+	stats.Active = []LockoutInfo{}
+
+	now := g.now()
+	for key, e := range g.entries {
+		if now.Before(e.lockedUntil) {
+			stats.Active = append(stats.Active, LockoutInfo{Key: key, Until: e.lockedUntil, Lockouts: e.lockouts})
+		}
+	}
+	sort.Slice(stats.Active, func(i, j int) bool {
+		return stats.Active[i].Key < stats.Active[j].Key
+	})
+	return stats
+}
+
+func (g *AuthGuard) prune(now time.Time) {
+	for key, e := range g.entries {
+		if now.After(e.lockedUntil) && now.Sub(e.lastFailure) > g.maxLockout {
+			delete(g.entries, key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bakare-dev/gotunnel/internal/config"
+)
+
+func TestAuthGuardExponentialLockout(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	guard := NewAuthGuard(config.LockoutConfig{MaxFailures: 3, LockoutSeconds: 10, MaxLockoutSeconds: 30})
+	guard.now = func() time.Time { return now }
+
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			guard.Failure("ip:203.0.113.7")
+		}
+	}
+
+	fail(2)
+	if guard.Locked("ip:203.0.113.7") {
+		t.Fatal("locked before reaching max failures")
+	}
+
+	fail(1)
+	if !guard.Locked("ip:203.0.113.7") {
+		t.Fatal("expected lockout after 3 failures")
+	}
+
+	now = now.Add(11 * time.Second)
+	if guard.Locked("ip:203.0.113.7") {
+		t.Fatal("first lockout should last 10s")
+	}
+
+	// The second lockout doubles, the third is capped at the maximum.
+	fail(3)
+	now = now.Add(11 * time.Second)
+	if !guard.Locked("ip:203.0.113.7") {
+		t.Fatal("second lockout should last 20s")
+	}
+	now = now.Add(10 * time.Second)
+	fail(3)
+	now = now.Add(29 * time.Second)
+	if !guard.Locked("ip:203.0.113.7") {
+		t.Fatal("third lockout should be capped at 30s, not expired")
+	}
+
+	stats := guard.Stats()
+	if stats.Lockouts != 3 || stats.Failures != 9 || len(stats.Active) != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAuthGuardSuccessClearsCredential(t *testing.T) {
+	guard := NewAuthGuard(config.LockoutConfig{MaxFailures: 2})
+
+	guard.Failure("token:abcd", "ip:198.51.100.1")
+	guard.Success("token:abcd")
+	guard.Failure("token:abcd", "ip:198.51.100.1")
+
+	if guard.Locked("token:abcd") {
+		t.Fatal("success should reset the credential counter")
+	}
+	if !guard.Locked("ip:198.51.100.1") {
+		t.Fatal("IP counter must not be reset by a success")
+	}
+}
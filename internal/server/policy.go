@@ -0,0 +1,72 @@
+package server
+
+import (
+	"github.com/bakare-dev/gotunnel/internal/auth"
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+	"github.com/bakare-dev/gotunnel/internal/tunnel"
+)
+
+type TunnelPolicy struct {
+	Access *AccessList
+	Auth   *tunnel.PublicAuth
+	TLS    bool
+
+	// HTTPOnly rejects public connections that do not start with an HTTP
+	// request; MaxStreams caps concurrent public connections across all
+	// tunnels of the client identity.
+	HTTPOnly   bool
+	MaxStreams int
+
+	// HTTP2 offers h2 to HTTPS visitors; the local service speaks h2c.
+	HTTP2 bool
+
+	// Hosts holds host pattern lists (from the client key and its policy);
+	// HTTPS visitors must match every list.
+	Hosts [][]string
+
+	// Middleware runs on this tunnel's streams only.
+	Middleware tunnel.MiddlewareChain
+}
+
+func (p *TunnelPolicy) AllowsHost(name string) bool {
+	for _, patterns := range p.Hosts {
+		if !auth.MatchHost(patterns, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *TunnelPolicy) RequireHosts(patterns []string) {
+	if len(patterns) > 0 {
+		p.Hosts = append(p.Hosts, patterns)
+	}
+}
+
+func NewTunnelPolicy(opts protocol.BindOptions) (*TunnelPolicy, error) {
+	access, err := ParseAccessList(opts.AllowCIDRs, opts.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &TunnelPolicy{
+		Access:   access,
+		TLS:      opts.HTTPS,
+		HTTPOnly: opts.TunnelProtocol() == protocol.ProtoHTTP,
+		HTTP2:    opts.HTTPS && opts.HTTP2,
+	}
+
+	if opts.BasicAuth != "" {
+		if policy.Auth, err = tunnel.ParseBasicAuth(opts.BasicAuth); err != nil {
+			return nil, err
+		}
+	}
+	if opts.BearerToken != "" {
+		if policy.Auth == nil {
+			policy.Auth = &tunnel.PublicAuth{}
+		}
+		policy.Auth.BearerToken = opts.BearerToken
+	}
+
+	return policy, nil
+}
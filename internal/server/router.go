@@ -4,22 +4,30 @@ import (
 	"errors"
 	"log"
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/bakare-dev/gotunnel/internal/protocol"
 )
 
-var ErrNoSessionForPort = errors.New("no session for port")
+var (
+	ErrNoSessionForPort    = errors.New("no session for port")
+	ErrPortInUse           = errors.New("port already has an active tunnel")
+	ErrStandbyInUse        = errors.New("port already has a standby tunnel")
+	ErrStandbyRequiresPort = errors.New("standby tunnels must request a port")
+)
 
 type Router struct {
 	mu       sync.RWMutex
 	sessions map[int]*protocol.Session
+	standby  map[int]*protocol.Session
 	nextPort int
 }
 
 func NewRouter(startPort int) *Router {
 	return &Router{
 		sessions: make(map[int]*protocol.Session),
+		standby:  make(map[int]*protocol.Session),
 		nextPort: startPort,
 	}
 }
@@ -27,42 +35,177 @@ func NewRouter(startPort int) *Router {
 func (r *Router) AllocatePort(sess *protocol.Session) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.allocate(sess)
+}
 
-	port := r.nextPort
-	r.nextPort++
+func (r *Router) Bind(sess *protocol.Session, opts protocol.BindOptions) (int, error) {
+	return r.BindLimited(sess, opts, nil)
+}
+
+// BindLimited binds like Bind once limit accepts the number of tunnels the
+// session's identity already holds. Counting and binding happen under one
+// lock, so concurrent binds cannot both slip under a limit.
+func (r *Router) BindLimited(sess *protocol.Session, opts protocol.BindOptions, limit func(active int) error) (int, error) {
+	if opts.Port == 0 && opts.Standby {
+		return 0, ErrStandbyRequiresPort
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit != nil {
+		if err := limit(r.countIdentity(sess.Identity)); err != nil {
+			return 0, err
+		}
+	}
+
+	if opts.Port == 0 {
+		return r.allocate(sess), nil
+	}
+
+	port := int(opts.Port)
+
+	if opts.Standby {
+		if live(r.standby[port]) {
+			return 0, ErrStandbyInUse
+		}
+		r.standby[port] = sess
+		sess.PublicPort = port
 
+		if !live(r.sessions[port]) {
+			log.Printf("│ INFO  │ Standby on port %d is active (no primary)", port)
+		}
+		return port, nil
+	}
+
+	if live(r.sessions[port]) {
+		return 0, ErrPortInUse
+	}
 	r.sessions[port] = sess
 	sess.PublicPort = port
 
-	return port
+	if live(r.standby[port]) {
+		log.Printf("│ INFO  │ Primary on port %d recovered, standby demoted", port)
+	}
+	return port, nil
 }
 
 func (r *Router) Get(port int) (*protocol.Session, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	sess, ok := r.sessions[port]
-	return sess, ok
+	if sess, ok := r.sessions[port]; ok && !sess.IsClosed() {
+		return sess, true
+	}
+	if sess, ok := r.standby[port]; ok && !sess.IsClosed() {
+		return sess, true
+	}
+	return nil, false
+}
+
+type RouteInfo struct {
+	Port    int
+	Standby bool
+	Session *protocol.Session
+}
+
+func (r *Router) Routes() []RouteInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]RouteInfo, 0, len(r.sessions)+len(r.standby))
+	for port, sess := range r.sessions {
+		routes = append(routes, RouteInfo{Port: port, Session: sess})
+	}
+	for port, sess := range r.standby {
+		routes = append(routes, RouteInfo{Port: port, Standby: true, Session: sess})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Port != routes[j].Port {
+			return routes[i].Port < routes[j].Port
+		}
+		return !routes[i].Standby
+	})
+	return routes
+}
+
+// CountIdentity returns how many tunnels (primary or standby) are bound by
+// sessions authenticated as identity.
+func (r *Router) CountIdentity(identity string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.countIdentity(identity)
 }
 
-func (r *Router) Remove(port int) {
+func (r *Router) countIdentity(identity string) int {
+	n := 0
+	for _, sessions := range []map[int]*protocol.Session{r.sessions, r.standby} {
+		for _, sess := range sessions {
+			if sess.Identity == identity {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (r *Router) Remove(sess *protocol.Session) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	delete(r.sessions, port)
+
+	port := sess.PublicPort
+
+	if r.sessions[port] == sess {
+		delete(r.sessions, port)
+		if live(r.standby[port]) {
+			log.Printf("│ WARN  │ Primary on port %d lost, failing over to standby", port)
+		}
+	}
+	if r.standby[port] == sess {
+		delete(r.standby, port)
+	}
 }
 
 func (r *Router) CloseAll() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	log.Printf("│ INFO  │ Closing %d active sessions...", len(r.sessions))
+	log.Printf("│ INFO  │ Closing %d active sessions...", len(r.sessions)+len(r.standby))
 
 	for port, sess := range r.sessions {
 		sess.Close()
 		log.Printf("│ INFO  │ Closed session on port %d", port)
 	}
+	for port, sess := range r.standby {
+		sess.Close()
+		log.Printf("│ INFO  │ Closed standby session on port %d", port)
+	}
 
 	r.sessions = make(map[int]*protocol.Session)
+	r.standby = make(map[int]*protocol.Session)
+}
+
+func (r *Router) allocate(sess *protocol.Session) int {
+	port := r.nextPort
+	for r.inUse(port) {
+		port++
+	}
+	r.nextPort = port + 1
+
+	r.sessions[port] = sess
+	sess.PublicPort = port
+	return port
+}
+
+func (r *Router) inUse(port int) bool {
+	_, primary := r.sessions[port]
+	_, standby := r.standby[port]
+	return primary || standby
+}
+
+func live(sess *protocol.Session) bool {
+	return sess != nil && !sess.IsClosed()
 }
 
 func ExtractLocalPort(conn net.Conn) int {
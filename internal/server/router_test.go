@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bakare-dev/gotunnel/internal/protocol"
+)
+
+func newTestSession() *protocol.Session {
+	buf := new(bytes.Buffer)
+	return protocol.NewSession(buf, buf)
+}
+
+func TestRouterStandbyFailover(t *testing.T) {
+	r := NewRouter(10000)
+
+	primary := newTestSession()
+	standby := newTestSession()
+
+	if _, err := r.Bind(primary, protocol.BindOptions{Port: 10080}); err != nil {
+		t.Fatalf("primary bind failed: %v", err)
+	}
+	if _, err := r.Bind(standby, protocol.BindOptions{Port: 10080, Standby: true}); err != nil {
+		t.Fatalf("standby bind failed: %v", err)
+	}
+
+	if sess, _ := r.Get(10080); sess != primary {
+		t.Fatalf("expected primary to receive traffic")
+	}
+
+	primary.Close()
+	if sess, _ := r.Get(10080); sess != standby {
+		t.Fatalf("expected standby to receive traffic after primary closed")
+	}
+
+	r.Remove(primary)
+	recovered := newTestSession()
+	if _, err := r.Bind(recovered, protocol.BindOptions{Port: 10080}); err != nil {
+		t.Fatalf("recovered primary bind failed: %v", err)
+	}
+	if sess, _ := r.Get(10080); sess != recovered {
+		t.Fatalf("expected traffic to switch back to recovered primary")
+	}
+}
+
+func TestRouterRejectsDuplicatePrimary(t *testing.T) {
+	r := NewRouter(10000)
+
+	if _, err := r.Bind(newTestSession(), protocol.BindOptions{Port: 10080}); err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	if _, err := r.Bind(newTestSession(), protocol.BindOptions{Port: 10080}); err != ErrPortInUse {
+		t.Fatalf("expected ErrPortInUse, got %v", err)
+	}
+	if _, err := r.Bind(newTestSession(), protocol.BindOptions{Standby: true}); err != ErrStandbyRequiresPort {
+		t.Fatalf("expected ErrStandbyRequiresPort, got %v", err)
+	}
+}
+
+func TestRouterAllocateSkipsReservedPorts(t *testing.T) {
+	r := NewRouter(10000)
+
+	if _, err := r.Bind(newTestSession(), protocol.BindOptions{Port: 10000}); err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+
+	if port := r.AllocatePort(newTestSession()); port != 10001 {
+		t.Fatalf("expected port 10001, got %d", port)
+	}
+}
+
+func TestRouterBindLimitedIsAtomic(t *testing.T) {
+	r := NewRouter(10000)
+	errLimit := errors.New("limit reached")
+	limit := func(active int) error {
+		if active >= 2 {
+			return errLimit
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	bound := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess := newTestSession()
+			sess.Identity = "alice"
+			if _, err := r.BindLimited(sess, protocol.BindOptions{}, limit); err == nil {
+				mu.Lock()
+				bound++
+				mu.Unlock()
+			} else if err != errLimit {
+				t.Errorf("unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if bound != 2 || r.CountIdentity("alice") != 2 {
+		t.Fatalf("expected exactly 2 tunnels, bound %d", bound)
+	}
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+var ErrListenerClosed = errors.New("websocket listener closed")
+
+// WebSocketListener accepts tunnel connections that arrive as WebSocket
+// upgrades. It is an http.Handler on one side and a net.Listener on the
+// other, so the server's accept loop works the same for every transport.
+type WebSocketListener struct {
+	addr    net.Addr
+	trusted []netip.Prefix
+
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+// NewWebSocketListener creates a listener for upgrades served at addr.
+// X-Forwarded-For is only honored on requests from trustedProxies.
+func NewWebSocketListener(addr net.Addr, trustedProxies []string) (*WebSocketListener, error) {
+	l := &WebSocketListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+
+	for _, entry := range trustedProxies {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(entry)
+			if ipErr != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		l.trusted = append(l.trusted, prefix.Masked())
+	}
+
+	return l, nil
+}
+
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	// Drop the HTTP server's timeouts; the tunnel sets its own.
+	conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", Subprotocol) {
+		response += "Sec-WebSocket-Protocol: " + Subprotocol + "\r\n"
+	}
+	if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := newConn(conn, brw.Reader, false)
+	ws.state = r.TLS
+	if remote := l.clientAddr(conn.RemoteAddr(), r); remote != nil {
+		ws.remote = remote
+	}
+
+	select {
+	case l.conns <- ws:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// clientAddr returns the client address reported by a trusted proxy: the
+// right-most X-Forwarded-For entry that is not itself a trusted proxy.
+func (l *WebSocketListener) clientAddr(peer net.Addr, r *http.Request) net.Addr {
+	addrPort, err := netip.ParseAddrPort(peer.String())
+	if err != nil || !l.isTrusted(addrPort.Addr()) {
+		return nil
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return nil
+		}
+		if !l.isTrusted(ip) {
+			return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))
+		}
+	}
+	return nil
+}
+
+func (l *WebSocketListener) isTrusted(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *WebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *WebSocketListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *WebSocketListener) Addr() net.Addr {
+	return l.addr
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var _ net.Listener = (*WebSocketListener)(nil)
@@ -0,0 +1,376 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrProxyAuth = errors.New("proxy: authentication failed")
+
+// ContextDialer is satisfied by *net.Dialer and Dialer.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Dialer opens TCP connections directly or through an HTTP CONNECT or SOCKS5
+// proxy. The zero value dials directly.
+type Dialer struct {
+	Proxy   *url.URL
+	Timeout time.Duration
+}
+
+// ParseProxy validates a proxy URL. Supported schemes are http, https,
+// socks5 and socks5h; credentials go in the userinfo part.
+func ParseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https, socks5 or socks5h)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", raw)
+	}
+	return u, nil
+}
+
+// ProxyFromEnvironment picks the proxy for addr from HTTPS_PROXY, then
+// ALL_PROXY (or their lower-case forms), unless NO_PROXY excludes addr. It
+// returns nil when no proxy applies.
+func ProxyFromEnvironment(addr string) (*url.URL, error) {
+	raw := getenv("HTTPS_PROXY")
+	if raw == "" {
+		raw = getenv("ALL_PROXY")
+	}
+	if raw == "" || bypassProxy(addr, getenv("NO_PROXY")) {
+		return nil, nil
+	}
+	return ParseProxy(raw)
+}
+
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// bypassProxy applies the usual NO_PROXY rules: "*", host names matching
+// themselves and their subdomains (a leading dot is optional), IPs, CIDRs,
+// and an optional :port on any entry.
+func bypassProxy(addr, noProxy string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	ip, ipErr := netip.ParseAddr(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if ipErr == nil && prefix.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+
+		if entryIP, err := netip.ParseAddr(entry); err == nil {
+			if ipErr == nil && entryIP == ip {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	direct := &net.Dialer{}
+	if d.Proxy == nil {
+		return direct.DialContext(ctx, network, addr)
+	}
+
+	proxyAddr := d.Proxy.Host
+	if d.Proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(d.Proxy.Hostname(), defaultProxyPort(d.Proxy.Scheme))
+	}
+
+	conn, err := direct.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	tunnel := conn
+	switch d.Proxy.Scheme {
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.Proxy.Hostname(), MinVersion: tls.VersionTLS12})
+		if err = tlsConn.HandshakeContext(ctx); err == nil {
+			tunnel, err = httpConnect(tlsConn, addr, d.Proxy.User)
+		}
+	case "http":
+		tunnel, err = httpConnect(conn, addr, d.Proxy.User)
+	default:
+		err = socks5Connect(ctx, conn, addr, d.Proxy.User, d.Proxy.Scheme == "socks5h")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+func defaultProxyPort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case "socks5", "socks5h":
+		return "1080"
+	}
+	return "80"
+}
+
+func httpConnect(conn net.Conn, addr string, user *url.Userinfo) (net.Conn, error) {
+	request := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, ErrProxyAuth
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("CONNECT refused: %s", resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksUserPass     = 0x02
+	socksNoAcceptable = 0xFF
+	socksConnect      = 0x01
+
+	socksIPv4   = 0x01
+	socksDomain = 0x03
+	socksIPv6   = 0x04
+)
+
+var socksErrors = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Connect speaks RFC 1928, with RFC 1929 username/password auth when
+// the proxy URL has credentials. socks5h leaves name resolution to the proxy.
+func socks5Connect(ctx context.Context, conn net.Conn, addr string, user *url.Userinfo, remoteDNS bool) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{socksNoAuth}
+	if user != nil {
+		methods = []byte{socksNoAuth, socksUserPass}
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return err
+	}
+	if choice[0] != socksVersion {
+		return errors.New("not a SOCKS5 proxy")
+	}
+
+	switch choice[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if user == nil {
+			return ErrProxyAuth
+		}
+		if err := socks5Auth(conn, user); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		return ErrProxyAuth
+	default:
+		return fmt.Errorf("unsupported SOCKS5 auth method %d", choice[1])
+	}
+
+	request := []byte{socksVersion, socksConnect, 0x00}
+	ip, err := netip.ParseAddr(host)
+	if err != nil && !remoteDNS {
+		ips, lookupErr := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if lookupErr != nil || len(ips) == 0 {
+			return fmt.Errorf("resolve %s: %w", host, lookupErr)
+		}
+		ip, err = ips[0].Unmap(), nil
+	}
+	switch {
+	case err != nil:
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long")
+		}
+		request = append(request, socksDomain, byte(len(host)))
+		request = append(request, host...)
+	case ip.Is4():
+		request = append(request, socksIPv4)
+		request = append(request, ip.AsSlice()...)
+	default:
+		request = append(request, socksIPv6)
+		request = append(request, ip.AsSlice()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		if msg, ok := socksErrors[reply[1]]; ok {
+			return fmt.Errorf("SOCKS5 connect failed: %s", msg)
+		}
+		return fmt.Errorf("SOCKS5 connect failed: code %d", reply[1])
+	}
+
+	// Skip the bound address; the tunnel has no use for it.
+	var skip int
+	switch reply[3] {
+	case socksIPv4:
+		skip = 4
+	case socksIPv6:
+		skip = 16
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unsupported SOCKS5 address type %d", reply[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	password, _ := user.Password()
+	name := user.Username()
+	if len(name) > 255 || len(password) > 255 {
+		return fmt.Errorf("SOCKS5 credentials too long")
+	}
+
+	msg := []byte{0x01, byte(len(name))}
+	msg = append(msg, name...)
+	msg = append(msg, byte(len(password)))
+	msg = append(msg, password...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	var status [2]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return err
+	}
+	if status[1] != 0x00 {
+		return ErrProxyAuth
+	}
+	return nil
+}
+
+// bufferedConn returns bytes the proxy sent along with its response before
+// reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
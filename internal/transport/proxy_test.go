@@ -0,0 +1,216 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// startEcho returns the address of a TCP echo server.
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startProxy runs handle for every connection accepted on a loopback port.
+func startProxy(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func splice(client net.Conn, target string) {
+	defer client.Close()
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go io.Copy(upstream, client)
+	io.Copy(client, upstream)
+}
+
+func connectProxy(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		conn.Close()
+		return
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		conn.Close()
+		return
+	}
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	splice(conn, req.Host)
+}
+
+func socksProxy(conn net.Conn) {
+	buf := make([]byte, 262)
+
+	// Greeting: require username/password.
+	io.ReadFull(conn, buf[:2])
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{socksVersion, socksUserPass})
+
+	io.ReadFull(conn, buf[:2])
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	pass := make([]byte, buf[0])
+	io.ReadFull(conn, pass)
+	if string(user) != "user" || string(pass) != "pass" {
+		conn.Write([]byte{0x01, 0x01})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	io.ReadFull(conn, buf[:4])
+	var host string
+	switch buf[3] {
+	case socksIPv4:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case socksDomain:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+
+	conn.Write([]byte{socksVersion, 0x00, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+	splice(conn, net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+func dialThrough(t *testing.T, proxy, target string) error {
+	t.Helper()
+	u, err := ParseProxy(proxy)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	conn, err := Dialer{Proxy: u}.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo through proxy failed: %q %v", buf, err)
+	}
+	return nil
+}
+
+func TestDialHTTPConnect(t *testing.T) {
+	echo := startEcho(t)
+	proxy := startProxy(t, connectProxy)
+
+	if err := dialThrough(t, "http://user:pass@"+proxy, echo); err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if err := dialThrough(t, "http://user:wrong@"+proxy, echo); !errors.Is(err, ErrProxyAuth) {
+		t.Fatalf("expected ErrProxyAuth, got %v", err)
+	}
+}
+
+func TestDialSOCKS5(t *testing.T) {
+	echo := startEcho(t)
+	proxy := startProxy(t, socksProxy)
+
+	_, port, _ := net.SplitHostPort(echo)
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		if err := dialThrough(t, scheme+"://user:pass@"+proxy, "localhost:"+port); err != nil {
+			t.Fatalf("%s dial failed: %v", scheme, err)
+		}
+	}
+	if err := dialThrough(t, "socks5://user:wrong@"+proxy, echo); !errors.Is(err, ErrProxyAuth) {
+		t.Fatalf("expected ErrProxyAuth, got %v", err)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+	t.Setenv("ALL_PROXY", "socks5://proxy.corp:1080")
+	t.Setenv("NO_PROXY", "localhost,.internal.example.com,10.0.0.0/8,tunnel.example.org:9000")
+
+	tests := []struct {
+		addr  string
+		proxy bool
+	}{
+		{"tunnel.example.com:9000", true},
+		{"localhost:9000", false},
+		{"eu.internal.example.com:443", false},
+		{"internal.example.com:443", false},
+		{"10.1.2.3:9000", false},
+		{"tunnel.example.org:9000", false},
+		{"tunnel.example.org:443", true},
+	}
+
+	for _, tt := range tests {
+		u, err := ProxyFromEnvironment(tt.addr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.addr, err)
+		}
+		if (u != nil) != tt.proxy {
+			t.Errorf("%s: got proxy %v, expected proxy: %v", tt.addr, u, tt.proxy)
+		}
+	}
+
+	t.Setenv("HTTPS_PROXY", "corp-proxy:3128")
+	u, _ := ProxyFromEnvironment("tunnel.example.com:9000")
+	if u == nil || *u != (url.URL{Scheme: "http", Host: "corp-proxy:3128"}) {
+		t.Fatalf("expected HTTPS_PROXY to win, got %v", u)
+	}
+}
+
+func TestParseProxyRejectsUnknownSchemes(t *testing.T) {
+	if _, err := ParseProxy("ftp://proxy:21"); err == nil {
+		t.Fatalf("expected an error for ftp proxy")
+	}
+}
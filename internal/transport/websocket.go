@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Subprotocol is offered by clients and echoed by the server so
+// intermediaries can tell tunnel traffic apart.
+const Subprotocol = "gotunnel"
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const (
+	maxControlPayload = 125
+	closeWriteTimeout = time.Second
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+)
+
+var (
+	ErrInvalidFrame = errors.New("websocket: invalid frame")
+	ErrFrameMasking = errors.New("websocket: frame masked the wrong way for its direction")
+)
+
+// Conn carries a byte stream in binary WebSocket messages. Frame boundaries
+// mean nothing to the tunnel protocol, so reads simply return payload bytes
+// as they arrive.
+type Conn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool
+	remote net.Addr
+	state  *tls.ConnectionState
+
+	// Read state for the frame currently being consumed.
+	remaining int64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	closed    bool
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		Conn:   conn,
+		br:     br,
+		client: client,
+		remote: conn.RemoteAddr(),
+	}
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// ConnectionState reports the TLS state of the connection the upgrade
+// arrived on, so client certificates still identify the peer.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	if c.state == nil {
+		return tls.ConnectionState{}
+	}
+	return *c.state
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := range n {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until it reaches data, answering pings and
+// close frames on the way.
+func (c *Conn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+
+	op := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7F)
+
+	// Frames from clients must be masked and frames from servers must not.
+	if masked == c.client {
+		return c.fail(ErrFrameMasking)
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return c.fail(ErrInvalidFrame)
+		}
+	}
+
+	c.masked = masked
+	c.maskPos = 0
+	if masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case opContinuation, opBinary, opText:
+		c.remaining = length
+		return nil
+	case opClose, opPing, opPong:
+	default:
+		return c.fail(ErrInvalidFrame)
+	}
+
+	if length > maxControlPayload {
+		return c.fail(ErrInvalidFrame)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= c.mask[i&3]
+		}
+	}
+
+	switch op {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		c.closed = true
+		c.closeOnce.Do(func() { c.writeFrame(opClose, payload) })
+		return io.EOF
+	}
+	return nil
+}
+
+// fail answers a protocol violation with a close frame carrying code 1002.
+// Nothing more is read from the connection afterwards.
+func (c *Conn) fail(err error) error {
+	c.closed = true
+	c.sendClose(closeProtocolError)
+	return err
+}
+
+// sendClose writes the close frame, unless one was already sent.
+func (c *Conn) sendClose(code uint16) {
+	c.closeOnce.Do(func() {
+		c.Conn.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+		c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, code))
+	})
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|op)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= maxControlPayload:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	// Clients must mask every frame; the server writes payloads as they are.
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		header = append(header, mask[:]...)
+
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i&3]
+		}
+		payload = masked
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.Conn)
+	return err
+}
+
+// Close sends a close frame, without waiting for the peer's answer, and
+// closes the connection.
+func (c *Conn) Close() error {
+	c.sendClose(closeNormal)
+	return c.Conn.Close()
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startListener(t *testing.T, trusted ...string) (*WebSocketListener, string) {
+	t.Helper()
+
+	ln, err := NewWebSocketListener(&net.TCPAddr{}, trusted)
+	if err != nil {
+		t.Fatalf("listener failed: %v", err)
+	}
+	srv := httptest.NewServer(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		srv.Close()
+	})
+
+	return ln, "ws" + strings.TrimPrefix(srv.URL, "http") + "/tunnel"
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	ln, url := startListener(t)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := DialWebSocket(ctx, Dialer{}, url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Sizes cover the 7-bit, 16-bit and 64-bit length encodings.
+	for _, size := range []int{5, 300, 70000} {
+		payload := bytes.Repeat([]byte{byte(size)}, size)
+		go conn.Write(payload)
+
+		got := make([]byte, size)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("read %d failed: %v", size, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("payload of %d bytes corrupted", size)
+		}
+	}
+}
+
+func TestWebSocketAnswersPing(t *testing.T) {
+	ln, url := startListener(t)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := DialWebSocket(context.Background(), Dialer{}, url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	server := (<-accepted).(*Conn)
+	defer server.Close()
+
+	if err := conn.writeFrame(opPing, []byte("hi")); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	conn.Write([]byte("data"))
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "data" {
+		t.Fatalf("expected data after ping, got %q %v", buf, err)
+	}
+
+	// The pong is consumed by the client's reader; data after it still arrives.
+	server.Write([]byte("ok"))
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || string(buf[:2]) != "ok" {
+		t.Fatalf("expected data after pong, got %q %v", buf[:2], err)
+	}
+}
+
+func TestWebSocketRejectsUnmaskedClientFrames(t *testing.T) {
+	ln, url := startListener(t)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := DialWebSocket(context.Background(), Dialer{}, url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	server := (<-accepted).(*Conn)
+	defer server.Close()
+
+	// Write the way a server would, without a mask.
+	conn.client = false
+	conn.Write([]byte("data"))
+
+	if _, err := server.Read(make([]byte, 4)); err != ErrFrameMasking {
+		t.Fatalf("expected ErrFrameMasking, got %v", err)
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn.br, head); err != nil {
+		t.Fatalf("expected a close frame: %v", err)
+	}
+	if !bytes.Equal(head, []byte{0x80 | opClose, 2, 0x03, 0xEA}) {
+		t.Fatalf("expected close code 1002, got % x", head)
+	}
+}
+
+func TestWebSocketRejectsPlainRequests(t *testing.T) {
+	_, url := startListener(t)
+
+	resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestClientAddrFromTrustedProxy(t *testing.T) {
+	ln, err := NewWebSocketListener(&net.TCPAddr{}, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("listener failed: %v", err)
+	}
+
+	tests := []struct {
+		peer, forwarded, want string
+	}{
+		{"10.0.0.5:4000", "203.0.113.9, 10.0.0.7", "203.0.113.9:0"},
+		{"192.0.2.1:4000", "203.0.113.9", ""},
+		{"10.0.0.5:4000", "", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/tunnel", nil)
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		peer, _ := net.ResolveTCPAddr("tcp", tt.peer)
+
+		got := ln.clientAddr(peer, r)
+		if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("peer %s, forwarded %q: got %v, expected %q", tt.peer, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	tests := map[string]string{
+		"tunnel.example.com:9000":          "tunnel.example.com:9000",
+		"wss://tunnel.example.com/tunnel":  "tunnel.example.com:443",
+		"ws://tunnel.example.com/tunnel":   "tunnel.example.com:80",
+		"wss://tunnel.example.com:8443/ws": "tunnel.example.com:8443",
+	}
+	for in, want := range tests {
+		if got, err := HostPort(in); err != nil || got != want {
+			t.Errorf("HostPort(%q) = %q, %v; expected %q", in, got, err, want)
+		}
+	}
+}
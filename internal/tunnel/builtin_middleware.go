@@ -0,0 +1,175 @@
+package tunnel
+
+import (
+	"log"
+	"time"
+)
+
+// The built-in middlewares can be named in configs and with --middleware.
+func init() {
+	RegisterMiddleware("inspect", InspectMiddleware(InspectConfig{WebSocketEvents: true}))
+	RegisterMiddleware("decode", func() StreamMiddleware {
+		return DecoderMiddleware(DecoderNames())()
+	})
+	RegisterMiddleware("rewrite", HeaderRewriteMiddleware(HeaderRewriteConfig{ForwardedHeaders: true}))
+}
+
+// InspectConfig controls the request logging middleware.
+type InspectConfig struct {
+	// WebSocketEvents logs WebSocket messages and close frames as they
+	// pass, not only the summary when the connection ends.
+	WebSocketEvents bool
+	WebSocketText   bool
+}
+
+// InspectMiddleware logs HTTP/1 and HTTP/2 requests and WebSocket traffic
+// and records them in the stream's metrics.
+func InspectMiddleware(config InspectConfig) MiddlewareFactory {
+	return func() StreamMiddleware {
+		return &inspectMiddleware{config: config}
+	}
+}
+
+type inspectMiddleware struct {
+	Passthrough
+	config  InspectConfig
+	info    *StreamInfo
+	httpLog *HTTPLog
+	h2      *HTTP2Inspector
+	ws      *WebSocketInspector
+
+	firstRequest, firstResponse bool
+}
+
+func (m *inspectMiddleware) Open(info *StreamInfo) error {
+	m.info = info
+	m.httpLog = &HTTPLog{StartTime: time.Now()}
+	m.h2 = NewHTTP2Inspector()
+	m.ws = NewWebSocketInspector(m.config.WebSocketText)
+	m.firstRequest, m.firstResponse = true, true
+	return nil
+}
+
+func (m *inspectMiddleware) Inbound(data []byte) ([]byte, error) {
+	m.logHTTP2(m.h2.Request(data))
+	m.logWebSocket(m.ws.Request(data))
+
+	if m.firstRequest {
+		m.firstRequest = false
+		if !m.h2.Active() {
+			m.httpLog.Request = ParseHTTPRequest(data)
+		}
+	}
+	return data, nil
+}
+
+func (m *inspectMiddleware) Outbound(data []byte) ([]byte, error) {
+	m.logHTTP2(m.h2.Response(data))
+	m.logWebSocket(m.ws.Response(data))
+
+	if m.firstResponse && m.httpLog.Request != nil {
+		m.firstResponse = false
+		m.httpLog.Response = ParseHTTPResponse(data)
+		m.httpLog.Duration = time.Since(m.httpLog.StartTime)
+
+		if m.httpLog.Response != nil {
+			m.info.Metrics.RecordHTTPRequest(m.httpLog.Response.StatusCode, m.httpLog.Duration)
+		}
+
+		if logStr := m.httpLog.String(); logStr != "" {
+			log.Printf("│ HTTP  │ %s", logStr)
+		}
+	}
+	return data, nil
+}
+
+func (m *inspectMiddleware) Close() {
+	if summary := m.ws.Summary(); summary != "" {
+		log.Printf("│ WS    │ [Stream %d] %s", m.info.ID, summary)
+	}
+}
+
+func (m *inspectMiddleware) logHTTP2(logs []*HTTPLog) {
+	for _, httpLog := range logs {
+		m.info.Metrics.RecordHTTPRequest(httpLog.Response.StatusCode, httpLog.Duration)
+		if code, ok := httpLog.Response.GRPCCode(); ok {
+			m.info.Metrics.RecordGRPCStatus(code)
+		}
+		log.Printf("│ HTTP  │ %s", httpLog.String())
+	}
+}
+
+func (m *inspectMiddleware) logWebSocket(events []WebSocketEvent) {
+	if !m.config.WebSocketEvents {
+		return
+	}
+	for _, event := range events {
+		log.Printf("│ WS    │ [Stream %d] %s", m.info.ID, event)
+	}
+}
+
+// DecoderMiddleware logs the database and cache commands of streams that
+// speak one of the named protocols.
+func DecoderMiddleware(names []string) MiddlewareFactory {
+	return func() StreamMiddleware {
+		return &decoderMiddleware{set: NewDecoderSet(names)}
+	}
+}
+
+type decoderMiddleware struct {
+	Passthrough
+	info *StreamInfo
+	set  *DecoderSet
+}
+
+func (m *decoderMiddleware) Open(info *StreamInfo) error {
+	m.info = info
+	return nil
+}
+
+func (m *decoderMiddleware) Inbound(data []byte) ([]byte, error) {
+	m.logCommands(m.set.Request(data))
+	return data, nil
+}
+
+func (m *decoderMiddleware) Outbound(data []byte) ([]byte, error) {
+	m.logCommands(m.set.Response(data))
+	return data, nil
+}
+
+func (m *decoderMiddleware) logCommands(cmds []*Command) {
+	for _, cmd := range cmds {
+		m.info.Metrics.RecordCommand(cmd.Protocol, cmd.Name, cmd.Duration, cmd.Error != "")
+		log.Printf("│ %-5s │ %s", DecoderLabel(cmd.Protocol), cmd)
+	}
+}
+
+// HeaderRewriteMiddleware injects forwarding headers into HTTP/1 requests.
+func HeaderRewriteMiddleware(config HeaderRewriteConfig) MiddlewareFactory {
+	return func() StreamMiddleware {
+		return &rewriteMiddleware{config: config}
+	}
+}
+
+type rewriteMiddleware struct {
+	Passthrough
+	config   HeaderRewriteConfig
+	rewriter *HTTPRewriter
+}
+
+func (m *rewriteMiddleware) Open(info *StreamInfo) error {
+	proto := "http"
+	if info.TLS {
+		proto = "https"
+	}
+	m.rewriter = NewHTTPRewriter(m.config, info.RemoteAddr, proto)
+	return nil
+}
+
+func (m *rewriteMiddleware) Inbound(data []byte) ([]byte, error) {
+	return m.rewriter.Rewrite(data), nil
+}
+
+func (m *rewriteMiddleware) FlushInbound() ([]byte, error) {
+	return m.rewriter.Flush(), nil
+}
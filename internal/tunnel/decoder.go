@@ -0,0 +1,194 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ErrNotRecognized is returned by decoders for streams that do not carry
+// their protocol.
+var ErrNotRecognized = errors.New("tunnel: stream protocol not recognized")
+
+// Command is one request/response exchange recognized by a StreamDecoder.
+// Only the statement or command type is kept, never its arguments.
+type Command struct {
+	Protocol  string
+	Name      string
+	Error     string // error code or class reported by the server
+	StartTime time.Time
+	Duration  time.Duration
+}
+
+func (c *Command) String() string {
+	mark := "✓"
+	if c.Error != "" {
+		mark = "✗"
+	}
+
+	line := fmt.Sprintf("%s %-16s %4dms", mark, c.Name, c.Duration.Milliseconds())
+	if c.Error != "" {
+		line += " " + c.Error
+	}
+	return line
+}
+
+// StreamDecoder follows one tunnel stream and reports the commands it
+// carries. Request and Response take the client and server bytes; any
+// error, including ErrNotRecognized, means the decoder has given up on
+// the stream and gets no more data.
+type StreamDecoder interface {
+	Request(data []byte) ([]*Command, error)
+	Response(data []byte) ([]*Command, error)
+}
+
+type decoderEntry struct {
+	label   string
+	factory func() StreamDecoder
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]decoderEntry{}
+)
+
+// RegisterDecoder makes a decoder available by name; label is the short
+// tag shown in the log.
+func RegisterDecoder(name, label string, factory func() StreamDecoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[name] = decoderEntry{label: label, factory: factory}
+}
+
+func init() {
+	RegisterDecoder("postgres", "PGSQL", func() StreamDecoder { return &postgresDecoder{} })
+	RegisterDecoder("mysql", "MYSQL", func() StreamDecoder { return &mysqlDecoder{} })
+	RegisterDecoder("redis", "REDIS", func() StreamDecoder { return &redisDecoder{} })
+}
+
+// DecoderNames lists the registered decoders.
+func DecoderNames() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseDecoders validates a comma-separated list of decoder names.
+func ParseDecoders(list string) ([]string, error) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := decoders[name]; !ok {
+			return nil, fmt.Errorf("unknown decoder %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// DecoderLabel returns the log tag of a registered decoder.
+func DecoderLabel(name string) string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	return decoders[name].label
+}
+
+// DecoderSet runs several decoders over the same stream, dropping each one
+// as soon as it fails, so a stream ends up decoded by the one protocol it
+// actually speaks. It is safe to feed both directions concurrently.
+type DecoderSet struct {
+	mu       sync.Mutex
+	names    []string
+	decoders []StreamDecoder
+}
+
+func NewDecoderSet(names []string) *DecoderSet {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	s := &DecoderSet{}
+	for _, name := range names {
+		if entry, ok := decoders[name]; ok {
+			s.names = append(s.names, name)
+			s.decoders = append(s.decoders, entry.factory())
+		}
+	}
+	return s
+}
+
+func (s *DecoderSet) Request(data []byte) []*Command {
+	return s.feed(data, StreamDecoder.Request)
+}
+
+func (s *DecoderSet) Response(data []byte) []*Command {
+	return s.feed(data, StreamDecoder.Response)
+}
+
+func (s *DecoderSet) feed(data []byte, fn func(StreamDecoder, []byte) ([]*Command, error)) []*Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var commands []*Command
+	for i := 0; i < len(s.decoders); {
+		cmds, err := fn(s.decoders[i], data)
+		for _, cmd := range cmds {
+			cmd.Protocol = s.names[i]
+		}
+		commands = append(commands, cmds...)
+
+		if err != nil {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			s.decoders = append(s.decoders[:i], s.decoders[i+1:]...)
+			continue
+		}
+		i++
+	}
+	return commands
+}
+
+// statementType returns the leading keyword of a SQL statement, skipping
+// whitespace, comments and opening parentheses.
+func statementType(query string) string {
+	for {
+		query = strings.TrimLeftFunc(query, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '('
+		})
+
+		switch {
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			_, query, _ = strings.Cut(query, "\n")
+			continue
+		case strings.HasPrefix(query, "/*"):
+			_, query, _ = strings.Cut(query, "*/")
+			continue
+		}
+		break
+	}
+
+	end := strings.IndexFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '_'
+	})
+	if end < 0 {
+		end = len(query)
+	}
+	if end == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(query[:min(end, 16)])
+}
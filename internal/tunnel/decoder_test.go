@@ -0,0 +1,259 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// exchange is what one side sends before the other answers.
+type exchange struct {
+	client, server []byte
+}
+
+// replay feeds each exchange to the decoder a byte at a time and returns
+// the commands it reports, as "NAME" or "NAME error".
+func replay(t *testing.T, feed func(fromClient bool, data []byte) []*Command, exchanges []exchange) []string {
+	t.Helper()
+
+	var got []string
+	for _, ex := range exchanges {
+		for _, side := range []struct {
+			fromClient bool
+			data       []byte
+		}{{true, ex.client}, {false, ex.server}} {
+			for _, b := range side.data {
+				for _, cmd := range feed(side.fromClient, []byte{b}) {
+					got = append(got, strings.TrimSpace(cmd.Name+" "+cmd.Error))
+				}
+			}
+		}
+	}
+	return got
+}
+
+func decoderFeed(d StreamDecoder) func(bool, []byte) []*Command {
+	failed := false
+	return func(fromClient bool, data []byte) []*Command {
+		if failed {
+			return nil
+		}
+		var cmds []*Command
+		var err error
+		if fromClient {
+			cmds, err = d.Request(data)
+		} else {
+			cmds, err = d.Response(data)
+		}
+		failed = err != nil
+		return cmds
+	}
+}
+
+func expectCommands(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func pgMessage(typ byte, fields ...string) []byte {
+	var body []byte
+	for _, f := range fields {
+		body = append(append(body, f...), 0)
+	}
+	msg := []byte{typ}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(body)+4))
+	return append(msg, body...)
+}
+
+func pgStartup() []byte {
+	body := binary.BigEndian.AppendUint32(nil, 3<<16)
+	body = append(body, "user\x00app\x00\x00"...)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func postgresSession() []exchange {
+	auth := []byte{'R', 0, 0, 0, 8, 0, 0, 0, 0}
+	ready := []byte{'Z', 0, 0, 0, 5, 'I'}
+
+	return []exchange{
+		{pgStartup(), concat(auth, ready)},
+		{
+			pgMessage('Q', "  select * from users"),
+			concat(pgMessage('T'), pgMessage('D'), pgMessage('C', "SELECT 1"), ready),
+		},
+		{
+			pgMessage('Q', "/* app */ INSERT INTO users VALUES (1)"),
+			concat(pgMessage('E', "SERROR", "C23505", "Mduplicate key", ""), ready),
+		},
+		{
+			concat(
+				pgMessage('P', "s1", "UPDATE users SET name = $1"),
+				pgMessage('B', "", "s1"),
+				pgMessage('E', ""),
+				pgMessage('S'),
+			),
+			concat(pgMessage('1'), pgMessage('2'), pgMessage('C', "UPDATE 1"), ready),
+		},
+	}
+}
+
+func TestPostgresDecoder(t *testing.T) {
+	got := replay(t, decoderFeed(&postgresDecoder{}), postgresSession())
+	expectCommands(t, got, []string{"CONNECT", "SELECT", "INSERT SQLSTATE 23505", "UPDATE"})
+}
+
+func TestPostgresDecoderSSL(t *testing.T) {
+	ssl := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), pgSSLRequest)
+
+	d := &postgresDecoder{}
+	if _, err := d.Request(ssl); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := d.Response([]byte{'S', 0x16, 0x03}); err == nil {
+		t.Fatal("expected the decoder to give up on TLS")
+	}
+}
+
+func mysqlPacket(seq byte, payload []byte) []byte {
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...)
+}
+
+func mysqlSession() []exchange {
+	const caps = 0x0200 | mysqlClientDeprecateEOF // PROTOCOL_41
+
+	greeting := []byte{0x0a}
+	greeting = append(greeting, "8.0.36\x00"...)
+	greeting = append(greeting, 1, 0, 0, 0)
+	greeting = append(greeting, "abcdefgh\x00"...)
+	greeting = binary.LittleEndian.AppendUint16(greeting, caps&0xffff)
+	greeting = append(greeting, 0x21, 2, 0)
+	greeting = binary.LittleEndian.AppendUint16(greeting, caps>>16)
+
+	login := binary.LittleEndian.AppendUint32(nil, caps)
+	login = append(login, make([]byte, 28)...)
+	login = append(login, "root\x00"...)
+
+	ok := []byte{0x00, 0, 0, 2, 0, 0, 0}
+	terminator := []byte{0xfe, 0, 0, 2, 0, 0, 0}
+	errPacket := binary.LittleEndian.AppendUint16([]byte{0xff}, 1146)
+	errPacket = append(errPacket, "#42S02Table doesn't exist"...)
+
+	return []exchange{
+		{nil, mysqlPacket(0, greeting)},
+		{mysqlPacket(1, login), mysqlPacket(2, ok)},
+		{
+			mysqlPacket(0, append([]byte{0x03}, "SELECT id FROM users"...)),
+			concat(
+				mysqlPacket(1, []byte{1}),
+				mysqlPacket(2, []byte("\x03def\x00\x05users\x00\x02id\x00")),
+				mysqlPacket(3, []byte("\x011")),
+				mysqlPacket(4, []byte("\x012")),
+				mysqlPacket(5, terminator),
+			),
+		},
+		{mysqlPacket(0, append([]byte{0x03}, "delete from missing"...)), mysqlPacket(1, errPacket)},
+		{mysqlPacket(0, []byte{0x0e}), mysqlPacket(1, ok)},
+	}
+}
+
+func TestMySQLDecoder(t *testing.T) {
+	got := replay(t, decoderFeed(&mysqlDecoder{}), mysqlSession())
+	expectCommands(t, got, []string{"CONNECT", "SELECT", "DELETE ERR 1146", "PING"})
+}
+
+func redisSession() []exchange {
+	return []exchange{
+		{[]byte("*2\r\n$3\r\nget\r\n$4\r\nlist\r\n"), []byte("-WRONGTYPE Operation against a key\r\n")},
+		{
+			[]byte("*3\r\n$6\r\nCONFIG\r\n$3\r\nGET\r\n$4\r\nsave\r\n"),
+			[]byte("*2\r\n$4\r\nsave\r\n$0\r\n\r\n"),
+		},
+		{
+			[]byte("*4\r\n$4\r\nSCAN\r\n$1\r\n0\r\n$5\r\nCOUNT\r\n$3\r\n100\r\n"),
+			[]byte("*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$-1\r\n"),
+		},
+		{[]byte("PING\r\n"), []byte(">2\r\n+invalidate\r\n*0\r\n+PONG\r\n")},
+		{[]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n"), []byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")},
+		{nil, []byte("*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n")},
+	}
+}
+
+func TestRedisDecoder(t *testing.T) {
+	got := replay(t, decoderFeed(&redisDecoder{}), redisSession())
+	expectCommands(t, got, []string{"GET WRONGTYPE", "CONFIG GET", "SCAN", "PING", "SUBSCRIBE"})
+}
+
+func TestDecoderSetPicksProtocol(t *testing.T) {
+	names, err := ParseDecoders(" Postgres, mysql,redis ")
+	if err != nil || len(names) != 3 {
+		t.Fatalf("unexpected result %v, %v", names, err)
+	}
+	if _, err := ParseDecoders("postgres,mongo"); err == nil {
+		t.Fatal("expected an unknown decoder to be rejected")
+	}
+
+	sessions := map[string][]exchange{
+		"postgres": postgresSession(),
+		"mysql":    mysqlSession(),
+		"redis":    redisSession(),
+		"": {{
+			[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+			[]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+		}},
+	}
+	for protocol, session := range sessions {
+		set := NewDecoderSet(names)
+		seen := map[string]bool{}
+		count := 0
+		replay(t, func(fromClient bool, data []byte) []*Command {
+			var cmds []*Command
+			if fromClient {
+				cmds = set.Request(data)
+			} else {
+				cmds = set.Response(data)
+			}
+			for _, cmd := range cmds {
+				seen[cmd.Protocol] = true
+				count++
+			}
+			return cmds
+		}, session)
+
+		if protocol == "" {
+			if count != 0 {
+				t.Fatalf("expected no commands for HTTP, got %v", seen)
+			}
+			continue
+		}
+		if len(seen) != 1 || !seen[protocol] || count < 4 {
+			t.Fatalf("%s: expected only %s commands, got %d from %v", protocol, protocol, count, seen)
+		}
+	}
+}
+
+func TestStatementType(t *testing.T) {
+	tests := map[string]string{
+		"select 1":                            "SELECT",
+		"\n  -- note\n(SELECT 1) UNION ALL 2": "SELECT",
+		"/* a */ /* b */with x as (select 1)": "WITH",
+		"# mysql comment\nshow tables":        "SHOW",
+		"":                                    "QUERY",
+		"$1":                                  "QUERY",
+	}
+	for query, want := range tests {
+		if got := statementType(query); got != want {
+			t.Errorf("statementType(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
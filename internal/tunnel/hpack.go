@@ -0,0 +1,255 @@
+package tunnel
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	hpackDefaultTableSize = 4096
+	hpackMaxTableSize     = 1 << 20
+	hpackMaxStringLength  = 64 * 1024
+)
+
+var ErrHPACK = errors.New("tunnel: invalid HPACK header block")
+
+type hpackField struct {
+	Name  string
+	Value string
+}
+
+// size is the entry size from RFC 7541 section 4.1.
+func (f hpackField) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// hpackDecoder decodes the header blocks of one direction of an HTTP/2
+// connection. Every block has to pass through it, including ones nobody
+// looks at, or the dynamic table falls out of step with the peer's encoder.
+type hpackDecoder struct {
+	dynamic []hpackField // oldest first
+	size    int
+	maxSize int
+}
+
+func newHPACKDecoder() *hpackDecoder {
+	return &hpackDecoder{maxSize: hpackDefaultTableSize}
+}
+
+// Decode calls emit for every field in a complete header block.
+func (d *hpackDecoder) Decode(block []byte, emit func(hpackField)) error {
+	for len(block) > 0 {
+		b := block[0]
+
+		switch {
+		case b&0x80 != 0: // indexed field
+			idx, rest, err := hpackInteger(block, 7)
+			if err != nil {
+				return err
+			}
+			field, ok := d.at(idx)
+			if !ok {
+				return ErrHPACK
+			}
+			emit(field)
+			block = rest
+
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			field, rest, err := d.literal(block, 6)
+			if err != nil {
+				return err
+			}
+			d.add(field)
+			emit(field)
+			block = rest
+
+		case b&0xe0 == 0x20: // dynamic table size update
+			size, rest, err := hpackInteger(block, 5)
+			if err != nil {
+				return err
+			}
+			if size > hpackMaxTableSize {
+				return ErrHPACK
+			}
+			d.maxSize = int(size)
+			d.evict()
+			block = rest
+
+		default: // literal without indexing or never indexed
+			field, rest, err := d.literal(block, 4)
+			if err != nil {
+				return err
+			}
+			emit(field)
+			block = rest
+		}
+	}
+	return nil
+}
+
+func (d *hpackDecoder) literal(block []byte, prefix uint8) (hpackField, []byte, error) {
+	idx, rest, err := hpackInteger(block, prefix)
+	if err != nil {
+		return hpackField{}, nil, err
+	}
+
+	var field hpackField
+	if idx > 0 {
+		named, ok := d.at(idx)
+		if !ok {
+			return hpackField{}, nil, ErrHPACK
+		}
+		field.Name = named.Name
+	} else if field.Name, rest, err = hpackString(rest); err != nil {
+		return hpackField{}, nil, err
+	}
+
+	if field.Value, rest, err = hpackString(rest); err != nil {
+		return hpackField{}, nil, err
+	}
+	return field, rest, nil
+}
+
+func (d *hpackDecoder) at(idx uint64) (hpackField, bool) {
+	if idx == 0 {
+		return hpackField{}, false
+	}
+	if idx <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[idx-1], true
+	}
+
+	idx -= uint64(len(hpackStaticTable))
+	if idx > uint64(len(d.dynamic)) {
+		return hpackField{}, false
+	}
+	return d.dynamic[len(d.dynamic)-int(idx)], true
+}
+
+func (d *hpackDecoder) add(field hpackField) {
+	d.dynamic = append(d.dynamic, field)
+	d.size += field.size()
+	d.evict()
+}
+
+func (d *hpackDecoder) evict() {
+	n := 0
+	for d.size > d.maxSize && n < len(d.dynamic) {
+		d.size -= d.dynamic[n].size()
+		n++
+	}
+	if n > 0 {
+		d.dynamic = append(d.dynamic[:0], d.dynamic[n:]...)
+	}
+}
+
+// hpackInteger decodes an integer with an N-bit prefix (RFC 7541 section 5.1).
+func hpackInteger(buf []byte, prefix uint8) (uint64, []byte, error) {
+	if len(buf) == 0 {
+		return 0, nil, ErrHPACK
+	}
+
+	mask := uint64(1)<<prefix - 1
+	value := uint64(buf[0]) & mask
+	buf = buf[1:]
+	if value < mask {
+		return value, buf, nil
+	}
+
+	for shift := uint(0); len(buf) > 0 && shift < 63; shift += 7 {
+		b := buf[0]
+		buf = buf[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, buf, nil
+		}
+	}
+	return 0, nil, ErrHPACK
+}
+
+// hpackString decodes a string literal (RFC 7541 section 5.2).
+func hpackString(buf []byte) (string, []byte, error) {
+	if len(buf) == 0 {
+		return "", nil, ErrHPACK
+	}
+
+	huffman := buf[0]&0x80 != 0
+	n, rest, err := hpackInteger(buf, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > hpackMaxStringLength || n > uint64(len(rest)) {
+		return "", nil, ErrHPACK
+	}
+
+	raw, rest := rest[:n], rest[n:]
+	if !huffman {
+		return string(raw), rest, nil
+	}
+
+	s, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, rest, nil
+}
+
+type huffmanNode struct {
+	next [2]uint16 // 0 means no child; the root is never a child
+	sym  int16     // -1 for inner nodes
+}
+
+var (
+	huffmanTree     []huffmanNode
+	huffmanTreeOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanTree = []huffmanNode{{sym: -1}}
+
+	for sym, code := range huffmanCodes {
+		length := huffmanCodeLen[sym]
+		node := 0
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if huffmanTree[node].next[bit] == 0 {
+				huffmanTree = append(huffmanTree, huffmanNode{sym: -1})
+				huffmanTree[node].next[bit] = uint16(len(huffmanTree) - 1)
+			}
+			node = int(huffmanTree[node].next[bit])
+		}
+		huffmanTree[node].sym = int16(sym)
+	}
+}
+
+// huffmanDecode walks the code tree bit by bit. Padding must be a prefix
+// of EOS (all ones) and shorter than a byte; EOS itself is never in the tree.
+func huffmanDecode(src []byte) (string, error) {
+	huffmanTreeOnce.Do(buildHuffmanTree)
+
+	out := make([]byte, 0, len(src)*8/5)
+	node, depth, ones := 0, 0, true
+
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			next := huffmanTree[node].next[bit]
+			if next == 0 {
+				return "", ErrHPACK
+			}
+
+			node = int(next)
+			depth++
+			ones = ones && bit == 1
+
+			if sym := huffmanTree[node].sym; sym >= 0 {
+				out = append(out, byte(sym))
+				node, depth, ones = 0, 0, true
+			}
+		}
+	}
+
+	if depth > 7 || !ones {
+		return "", ErrHPACK
+	}
+	return string(out), nil
+}
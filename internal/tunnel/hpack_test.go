@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func decodeBlock(t *testing.T, d *hpackDecoder, block string) []hpackField {
+	t.Helper()
+
+	raw, err := hex.DecodeString(strings.ReplaceAll(block, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields []hpackField
+	if err := d.Decode(raw, func(f hpackField) { fields = append(fields, f) }); err != nil {
+		t.Fatalf("decode %s: %v", block, err)
+	}
+	return fields
+}
+
+func expectFields(t *testing.T, got []hpackField, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want)/2 {
+		t.Fatalf("expected %d fields, got %v", len(want)/2, got)
+	}
+	for i, f := range got {
+		if f.Name != want[2*i] || f.Value != want[2*i+1] {
+			t.Fatalf("field %d: expected %s: %s, got %s: %s", i, want[2*i], want[2*i+1], f.Name, f.Value)
+		}
+	}
+}
+
+// RFC 7541 C.4: requests with Huffman coding sharing one dynamic table.
+func TestHPACKRequestExamples(t *testing.T) {
+	d := newHPACKDecoder()
+
+	expectFields(t, decodeBlock(t, d, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"),
+		":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com")
+
+	expectFields(t, decodeBlock(t, d, "8286 84be 5886 a8eb 1064 9cbf"),
+		":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com",
+		"cache-control", "no-cache")
+
+	expectFields(t, decodeBlock(t, d, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"),
+		":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com",
+		"custom-key", "custom-value")
+
+	if d.size != 164 {
+		t.Fatalf("expected table size 164, got %d", d.size)
+	}
+}
+
+// RFC 7541 C.6: responses with Huffman coding and a 256-byte table, which
+// forces evictions.
+func TestHPACKResponseExamplesEvict(t *testing.T) {
+	d := newHPACKDecoder()
+	d.maxSize = 256
+
+	expectFields(t, decodeBlock(t, d, "4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3"),
+		":status", "302", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+		"location", "https://www.example.com")
+
+	expectFields(t, decodeBlock(t, d, "4883 640e ff c1 c0 bf"),
+		":status", "307", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+		"location", "https://www.example.com")
+
+	expectFields(t, decodeBlock(t, d, "88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07"),
+		":status", "200", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:22 GMT",
+		"location", "https://www.example.com", "content-encoding", "gzip",
+		"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1")
+
+	if d.size != 215 || len(d.dynamic) != 3 {
+		t.Fatalf("expected 3 entries of 215 bytes, got %d entries of %d", len(d.dynamic), d.size)
+	}
+}
+
+func TestHPACKRejectsBadInput(t *testing.T) {
+	for _, block := range []string{
+		"80",          // index 0
+		"ff00",        // index past both tables
+		"00 85 ff",    // string longer than the block
+		"00 81 fe 00", // Huffman padding that is not all ones
+	} {
+		raw, _ := hex.DecodeString(strings.ReplaceAll(block, " ", ""))
+		if err := newHPACKDecoder().Decode(raw, func(hpackField) {}); err == nil {
+			t.Fatalf("expected %q to be rejected", block)
+		}
+	}
+}
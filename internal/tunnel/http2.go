@@ -0,0 +1,421 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// http2Preface opens every HTTP/2 connection, whether it runs over TLS (h2)
+// or as prior-knowledge cleartext (h2c).
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	http2FrameHeaderLen = 9
+
+	// Header blocks beyond this are not worth following.
+	http2MaxHeaderBlock = 256 * 1024
+
+	// Streams opened past this many unanswered requests are not tracked.
+	http2MaxStreams = 1024
+
+	// Server bytes that arrive before the client preface is seen.
+	http2MaxPending = 64 * 1024
+)
+
+const (
+	http2FrameHeaders      = 0x1
+	http2FrameRSTStream    = 0x3
+	http2FramePushPromise  = 0x5
+	http2FrameContinuation = 0x9
+)
+
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+type http2State int32
+
+const (
+	http2Unknown http2State = iota
+	http2On
+	http2Off
+)
+
+// IsHTTP2Preface reports whether data starts with the HTTP/2 client preface.
+func IsHTTP2Preface(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(http2Preface))
+}
+
+// HTTP2Inspector follows the frames of one HTTP/2 connection carried by a
+// tunnel stream and turns each request/response exchange into an HTTPLog.
+// Request and Response take the client and server bytes respectively and
+// may be called from different goroutines. The first Request call decides
+// whether the stream is HTTP/2 at all; anything unparseable switches the
+// inspector off and leaves the stream alone.
+type HTTP2Inspector struct {
+	mu      sync.Mutex
+	state   http2State
+	pending []byte
+
+	client  *http2Reader
+	server  *http2Reader
+	streams map[uint32]*HTTPLog
+}
+
+func NewHTTP2Inspector() *HTTP2Inspector {
+	return &HTTP2Inspector{
+		client:  &http2Reader{decoder: newHPACKDecoder()},
+		server:  &http2Reader{decoder: newHPACKDecoder()},
+		streams: make(map[uint32]*HTTPLog),
+	}
+}
+
+// Active reports whether the stream was recognized as HTTP/2 and is still
+// being followed.
+func (i *HTTP2Inspector) Active() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.state == http2On
+}
+
+// Request consumes client-to-server bytes and returns the exchanges they
+// completed.
+func (i *HTTP2Inspector) Request(data []byte) []*HTTPLog {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	switch i.state {
+	case http2Off:
+		return nil
+	case http2Unknown:
+		if !IsHTTP2Preface(data) {
+			i.stop()
+			return nil
+		}
+		i.state = http2On
+		data = data[len(http2Preface):]
+	}
+
+	var done []*HTTPLog
+	if i.pending != nil {
+		pending := i.pending
+		i.pending = nil
+		done = i.feed(i.server, pending, done)
+	}
+	return i.feed(i.client, data, done)
+}
+
+// Response consumes server-to-client bytes and returns the exchanges they
+// completed.
+func (i *HTTP2Inspector) Response(data []byte) []*HTTPLog {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	switch i.state {
+	case http2Off:
+		return nil
+	case http2Unknown:
+		// Servers may send SETTINGS before the client preface is through.
+		if len(i.pending)+len(data) > http2MaxPending {
+			i.stop()
+			return nil
+		}
+		i.pending = append(i.pending, data...)
+		return nil
+	}
+
+	return i.feed(i.server, data, nil)
+}
+
+func (i *HTTP2Inspector) stop() {
+	i.state = http2Off
+	i.pending = nil
+	i.streams = nil
+	i.client, i.server = nil, nil
+}
+
+func (i *HTTP2Inspector) feed(r *http2Reader, data []byte, done []*HTTPLog) []*HTTPLog {
+	if i.state != http2On {
+		return done
+	}
+
+	err := r.read(data, func(f *http2Frame) error {
+		var err error
+		if r == i.client {
+			err = i.clientFrame(f)
+		} else {
+			done, err = i.serverFrame(f, done)
+		}
+		return err
+	})
+	if err != nil {
+		i.stop()
+	}
+	return done
+}
+
+func (i *HTTP2Inspector) clientFrame(f *http2Frame) error {
+	switch f.typ {
+	case http2FrameHeaders:
+		exchange, known := i.streams[f.stream]
+		if !known && len(i.streams) < http2MaxStreams {
+			exchange = &HTTPLog{Request: &HTTPRequest{}, StartTime: time.Now()}
+			i.streams[f.stream] = exchange
+		}
+
+		return i.client.decoder.Decode(f.block, func(field hpackField) {
+			if exchange == nil || known {
+				return // trailers or an untracked stream
+			}
+			req := exchange.Request
+			switch field.Name {
+			case ":method":
+				req.Method = field.Value
+			case ":path":
+				req.Path, _, _ = strings.Cut(field.Value, "?")
+			case ":authority":
+				req.Host = field.Value
+			case "host":
+				if req.Host == "" {
+					req.Host = field.Value
+				}
+			case "content-type":
+				req.GRPC = isGRPCContentType(field.Value)
+			}
+		})
+
+	case http2FrameRSTStream:
+		delete(i.streams, f.stream)
+	}
+	return nil
+}
+
+func (i *HTTP2Inspector) serverFrame(f *http2Frame, done []*HTTPLog) ([]*HTTPLog, error) {
+	exchange := i.streams[f.stream]
+
+	switch f.typ {
+	case http2FrameHeaders:
+		var resp HTTPResponse
+		err := i.server.decoder.Decode(f.block, func(field hpackField) {
+			switch field.Name {
+			case ":status":
+				resp.StatusCode, _ = strconv.Atoi(field.Value)
+			case "grpc-status":
+				resp.GRPCStatus = field.Value
+			case "grpc-message":
+				resp.GRPCMessage = grpcMessage(field.Value)
+			}
+		})
+		if err != nil || exchange == nil {
+			return done, err
+		}
+
+		switch {
+		case resp.StatusCode >= 200:
+			resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+			exchange.Response = &resp
+		case resp.StatusCode == 0 && exchange.Response != nil:
+			// Trailers, where gRPC reports the call's outcome.
+			if resp.GRPCStatus != "" {
+				exchange.Response.GRPCStatus = resp.GRPCStatus
+				exchange.Response.GRPCMessage = resp.GRPCMessage
+			}
+		}
+
+	case http2FramePushPromise:
+		// Decoded only to keep the HPACK table in step.
+		return done, i.server.decoder.Decode(f.block, func(hpackField) {})
+
+	case http2FrameRSTStream:
+		return i.finish(f.stream, done), nil
+	}
+
+	if exchange != nil && f.flags&http2FlagEndStream != 0 && f.typ != http2FramePushPromise {
+		done = i.finish(f.stream, done)
+	}
+	return done, nil
+}
+
+// finish closes out an exchange. Streams reset before a response arrived
+// are dropped, like HTTP/1 requests that never get an answer.
+func (i *HTTP2Inspector) finish(stream uint32, done []*HTTPLog) []*HTTPLog {
+	exchange, ok := i.streams[stream]
+	if !ok {
+		return done
+	}
+	delete(i.streams, stream)
+
+	if exchange.Response == nil {
+		return done
+	}
+	exchange.Duration = time.Since(exchange.StartTime)
+	return append(done, exchange)
+}
+
+func isGRPCContentType(value string) bool {
+	return value == "application/grpc" || strings.HasPrefix(value, "application/grpc+") ||
+		strings.HasPrefix(value, "application/grpc;")
+}
+
+// grpcMessage undoes the percent-encoding gRPC applies to grpc-message.
+func grpcMessage(value string) string {
+	if msg, err := url.PathUnescape(value); err == nil {
+		return msg
+	}
+	return value
+}
+
+type http2Frame struct {
+	typ    uint8
+	flags  uint8
+	stream uint32
+
+	// block is the complete header block of a HEADERS or PUSH_PROMISE
+	// frame, CONTINUATIONs included.
+	block []byte
+}
+
+// http2Reader reassembles frames from one direction of the connection.
+// DATA and other frames whose payload is irrelevant are skipped without
+// being buffered.
+type http2Reader struct {
+	decoder *hpackDecoder
+
+	header    [http2FrameHeaderLen]byte
+	headerLen int
+	frame     http2Frame
+	length    int
+	remaining int
+	keep      bool
+	payload   []byte
+
+	// A header block continues until END_HEADERS.
+	continuing bool
+	block      []byte
+	blockFrame http2Frame
+}
+
+func (r *http2Reader) read(data []byte, handle func(*http2Frame) error) error {
+	for len(data) > 0 {
+		if r.headerLen < http2FrameHeaderLen {
+			n := copy(r.header[r.headerLen:], data)
+			r.headerLen += n
+			data = data[n:]
+			if r.headerLen < http2FrameHeaderLen {
+				return nil
+			}
+			if err := r.begin(); err != nil {
+				return err
+			}
+		}
+
+		n := min(r.remaining, len(data))
+		if r.keep {
+			r.payload = append(r.payload, data[:n]...)
+		}
+		r.remaining -= n
+		data = data[n:]
+
+		if r.remaining == 0 {
+			r.headerLen = 0
+			if err := r.end(handle); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *http2Reader) begin() error {
+	r.length = int(r.header[0])<<16 | int(r.header[1])<<8 | int(r.header[2])
+	r.remaining = r.length
+	r.frame = http2Frame{
+		typ:    r.header[3],
+		flags:  r.header[4],
+		stream: binary.BigEndian.Uint32(r.header[5:]) & 0x7fffffff,
+	}
+
+	if r.continuing && (r.frame.typ != http2FrameContinuation || r.frame.stream != r.blockFrame.stream) {
+		return ErrHPACK
+	}
+
+	switch r.frame.typ {
+	case http2FrameHeaders, http2FramePushPromise, http2FrameContinuation:
+		r.keep = true
+		if len(r.block)+r.length > http2MaxHeaderBlock {
+			return ErrHPACK
+		}
+	default:
+		r.keep = false
+	}
+	r.payload = r.payload[:0]
+	return nil
+}
+
+func (r *http2Reader) end(handle func(*http2Frame) error) error {
+	f := r.frame
+
+	switch f.typ {
+	case http2FrameHeaders, http2FramePushPromise:
+		fragment, err := headerFragment(f, r.payload)
+		if err != nil {
+			return err
+		}
+		r.blockFrame = f
+		r.block = append(r.block[:0], fragment...)
+
+	case http2FrameContinuation:
+		if !r.continuing {
+			return ErrHPACK
+		}
+		r.block = append(r.block, r.payload...)
+		r.blockFrame.flags |= f.flags & http2FlagEndHeaders
+
+	default:
+		return handle(&f)
+	}
+
+	r.continuing = r.blockFrame.flags&http2FlagEndHeaders == 0
+	if r.continuing {
+		return nil
+	}
+
+	r.blockFrame.block = r.block
+	return handle(&r.blockFrame)
+}
+
+// headerFragment strips padding, priority and the promised stream ID from
+// a HEADERS or PUSH_PROMISE payload.
+func headerFragment(f http2Frame, payload []byte) ([]byte, error) {
+	pad := 0
+	if f.flags&http2FlagPadded != 0 {
+		if len(payload) < 1 {
+			return nil, ErrHPACK
+		}
+		pad = int(payload[0])
+		payload = payload[1:]
+	}
+
+	skip := 0
+	switch {
+	case f.typ == http2FramePushPromise:
+		skip = 4
+	case f.flags&http2FlagPriority != 0:
+		skip = 5
+	}
+
+	if len(payload) < skip+pad {
+		return nil, ErrHPACK
+	}
+	return payload[skip : len(payload)-pad], nil
+}
@@ -0,0 +1,193 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tappedConn copies what an HTTP/2 client writes and reads so the test can
+// play it through an inspector, the way tunnel streams see it.
+type tappedConn struct {
+	net.Conn
+
+	mu      *sync.Mutex
+	written *bytes.Buffer
+	read    *bytes.Buffer
+}
+
+func (c *tappedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *tappedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func recordH2C(t *testing.T, requests func(*http.Client, string)) (written, read []byte) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/greeter.Greeter/SayHello", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "no such user")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", 40000)))
+	})
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var w, r bytes.Buffer
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &tappedConn{Conn: conn, mu: &mu, written: &w, read: &r}, nil
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	requests(&http.Client{Transport: transport}, srv.URL)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return bytes.Clone(w.Bytes()), bytes.Clone(r.Bytes())
+}
+
+func do(t *testing.T, client *http.Client, req *http.Request) {
+	t.Helper()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+}
+
+func TestHTTP2InspectorH2C(t *testing.T) {
+	written, read := recordH2C(t, func(client *http.Client, url string) {
+		for _, path := range []string{"/", "/missing?q=1", "/"} {
+			req, _ := http.NewRequest("GET", url+path, nil)
+			do(t, client, req)
+		}
+
+		req, _ := http.NewRequest("POST", url+"/greeter.Greeter/SayHello", bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		do(t, client, req)
+	})
+
+	// Replay both directions a few bytes at a time so frames and header
+	// blocks straddle every packet boundary. The preface itself arrives
+	// whole, as the first packet of a stream.
+	inspector := NewHTTP2Inspector()
+	logs := inspector.Request(written[:len(http2Preface)])
+	for data := written[len(http2Preface):]; len(data) > 0; data = data[min(7, len(data)):] {
+		logs = append(logs, inspector.Request(data[:min(7, len(data))])...)
+	}
+	for data := read; len(data) > 0; data = data[min(5, len(data)):] {
+		logs = append(logs, inspector.Response(data[:min(5, len(data))])...)
+	}
+
+	if !inspector.Active() {
+		t.Fatal("expected the inspector to follow the connection")
+	}
+
+	want := []struct {
+		method, path string
+		status       int
+		grpc         string
+	}{
+		{"GET", "/", 200, ""},
+		{"GET", "/missing", 404, ""},
+		{"GET", "/", 200, ""},
+		{"POST", "/greeter.Greeter/SayHello", 200, "5"},
+	}
+	if len(logs) != len(want) {
+		t.Fatalf("expected %d exchanges, got %d", len(want), len(logs))
+	}
+	for i, w := range want {
+		got := logs[i]
+		if got.Request.Method != w.method || got.Request.Path != w.path {
+			t.Fatalf("exchange %d: expected %s %s, got %s %s", i, w.method, w.path, got.Request.Method, got.Request.Path)
+		}
+		if got.Response.StatusCode != w.status || got.Response.GRPCStatus != w.grpc {
+			t.Fatalf("exchange %d: expected %d grpc-status=%q, got %d grpc-status=%q",
+				i, w.status, w.grpc, got.Response.StatusCode, got.Response.GRPCStatus)
+		}
+	}
+
+	grpc := logs[3]
+	if !grpc.Request.GRPC || grpc.Response.GRPCMessage != "no such user" {
+		t.Fatalf("expected a gRPC call with its message, got %+v %+v", grpc.Request, grpc.Response)
+	}
+	if line := grpc.String(); !strings.Contains(line, "grpc-status=5 NOT_FOUND") {
+		t.Fatalf("expected the log line to carry the gRPC status, got %q", line)
+	}
+}
+
+func TestHTTP2InspectorServerSpeaksFirst(t *testing.T) {
+	inspector := NewHTTP2Inspector()
+
+	// An empty SETTINGS frame from the server, ahead of the client preface.
+	if logs := inspector.Response([]byte{0, 0, 0, 4, 0, 0, 0, 0, 0}); logs != nil {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+	inspector.Request([]byte(http2Preface))
+
+	if !inspector.Active() {
+		t.Fatal("expected the inspector to stay on after buffered server bytes")
+	}
+}
+
+func TestHTTP2InspectorIgnoresHTTP1(t *testing.T) {
+	inspector := NewHTTP2Inspector()
+
+	if logs := inspector.Request([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); logs != nil {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+	if inspector.Active() {
+		t.Fatal("expected HTTP/1 streams to switch the inspector off")
+	}
+	if logs := inspector.Response([]byte("HTTP/1.1 200 OK\r\n\r\n")); logs != nil {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+}
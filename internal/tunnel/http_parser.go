@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -12,11 +13,18 @@ type HTTPRequest struct {
 	Method string
 	Path   string
 	Host   string
+
+	// GRPC is set for HTTP/2 requests with a gRPC content type.
+	GRPC bool
 }
 
 type HTTPResponse struct {
 	StatusCode int
 	Status     string
+
+	// GRPCStatus and GRPCMessage come from the trailers of a gRPC call.
+	GRPCStatus  string
+	GRPCMessage string
 }
 
 type HTTPLog struct {
@@ -83,7 +91,7 @@ func (h *HTTPLog) String() string {
 		statusColor = "•"
 	}
 
-	return fmt.Sprintf("%s %-6s %-40s %3d %-15s %4dms",
+	line := fmt.Sprintf("%s %-6s %-40s %3d %-15s %4dms",
 		statusColor,
 		method,
 		path,
@@ -91,4 +99,36 @@ func (h *HTTPLog) String() string {
 		http.StatusText(statusCode),
 		duration,
 	)
+
+	if h.Response.GRPCStatus != "" {
+		line += " grpc-status=" + h.Response.GRPCStatus
+		if name := grpcCodeName(h.Response.GRPCStatus); name != "" {
+			line += " " + name
+		}
+		if h.Response.GRPCMessage != "" {
+			line += fmt.Sprintf(" %q", h.Response.GRPCMessage)
+		}
+	}
+	return line
+}
+
+// GRPCCode returns the numeric grpc-status, if the response carried one.
+func (r *HTTPResponse) GRPCCode() (int, bool) {
+	code, err := strconv.Atoi(r.GRPCStatus)
+	return code, err == nil
+}
+
+var grpcCodeNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func grpcCodeName(status string) string {
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 0 || code >= len(grpcCodeNames) {
+		return ""
+	}
+	return grpcCodeNames[code]
 }
@@ -0,0 +1,342 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const maxRequestHeaderSize = 64 * 1024
+
+type HeaderRewriteConfig struct {
+	ForwardedHeaders bool
+	RewriteHost      string
+}
+
+func (c HeaderRewriteConfig) Enabled() bool {
+	return c.ForwardedHeaders || c.RewriteHost != ""
+}
+
+type rewriteState uint8
+
+const (
+	stateHeaders rewriteState = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateTrailers
+	statePassthrough
+	stateDenied
+)
+
+// HTTPRewriter injects forwarding headers into every request of an HTTP/1.x
+// stream. It tracks message framing (Content-Length and chunked bodies) so
+// that keep-alive connections get rewritten on each request, and falls back
+// to passing bytes through untouched once the stream stops looking like HTTP.
+type HTTPRewriter struct {
+	config   HeaderRewriteConfig
+	clientIP string
+	proto    string
+
+	state     rewriteState
+	pending   []byte
+	remaining int64
+
+	// check, when set, vets every request head. A head it refuses, or bytes
+	// that are not a request, end the stream instead of passing through.
+	check func(head []byte) bool
+}
+
+func NewHTTPRewriter(config HeaderRewriteConfig, remoteAddr, proto string) *HTTPRewriter {
+	clientIP := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		clientIP = host
+	}
+
+	return &HTTPRewriter{
+		config:   config,
+		clientIP: clientIP,
+		proto:    proto,
+	}
+}
+
+func (r *HTTPRewriter) Rewrite(data []byte) []byte {
+	switch r.state {
+	case statePassthrough:
+		return data
+	case stateDenied:
+		return nil
+	}
+
+	var out []byte
+
+	for len(data) > 0 {
+		switch r.state {
+		case stateHeaders:
+			data = r.readHeaders(data, &out)
+
+		case stateBody, stateChunkData:
+			n := int64(len(data))
+			if n > r.remaining {
+				n = r.remaining
+			}
+			out = append(out, data[:n]...)
+			data = data[n:]
+			r.remaining -= n
+
+			if r.remaining == 0 {
+				if r.state == stateBody {
+					r.state = stateHeaders
+				} else {
+					r.state = stateChunkSize
+				}
+			}
+
+		case stateChunkSize, stateTrailers:
+			line, rest, ok := r.readLine(data, &out)
+			data = rest
+			if !ok {
+				break
+			}
+
+			if r.state == stateTrailers {
+				if len(bytes.TrimSpace(line)) == 0 {
+					r.state = stateHeaders
+				}
+				break
+			}
+
+			size, err := parseChunkSize(line)
+			if err != nil {
+				r.state = statePassthrough
+			} else if size == 0 {
+				r.state = stateTrailers
+			} else {
+				r.remaining = size + 2
+				r.state = stateChunkData
+			}
+
+		case statePassthrough:
+			out = append(out, data...)
+			data = nil
+
+		case stateDenied:
+			data = nil
+		}
+	}
+
+	return out
+}
+
+// Flush returns a partial request head still held back, for when the
+// stream ends before the head is complete.
+func (r *HTTPRewriter) Flush() []byte {
+	if r.state != stateHeaders || r.check != nil {
+		return nil // chunk lines are passed on as they arrive
+	}
+	out := r.pending
+	r.pending = nil
+	return out
+}
+
+func (r *HTTPRewriter) readHeaders(data []byte, out *[]byte) []byte {
+	r.pending = append(r.pending, data...)
+
+	idx := bytes.Index(r.pending, []byte("\r\n\r\n"))
+	if idx < 0 {
+		if !looksLikeRequest(r.pending) || len(r.pending) > maxRequestHeaderSize {
+			if r.check != nil {
+				r.deny()
+				return nil
+			}
+			*out = append(*out, r.pending...)
+			r.pending = nil
+			r.state = statePassthrough
+		}
+		return nil
+	}
+
+	head := r.pending[:idx+4]
+	rest := r.pending[idx+4:]
+	r.pending = nil
+
+	if r.check != nil && !r.check(head) {
+		r.deny()
+		return nil
+	}
+
+	*out = append(*out, r.rewriteHead(head)...)
+	return rest
+}
+
+func (r *HTTPRewriter) deny() {
+	r.pending = nil
+	r.state = stateDenied
+}
+
+// readLine collects a CRLF-terminated line that may span several chunks,
+// copying the bytes to out as they are consumed.
+func (r *HTTPRewriter) readLine(data []byte, out *[]byte) ([]byte, []byte, bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		r.pending = append(r.pending, data...)
+		*out = append(*out, data...)
+		return nil, nil, false
+	}
+
+	line := append(r.pending, data[:i+1]...)
+	r.pending = nil
+	*out = append(*out, data[:i+1]...)
+	return line, data[i+1:], true
+}
+
+func (r *HTTPRewriter) rewriteHead(head []byte) []byte {
+	lines := strings.Split(strings.TrimSuffix(string(head), "\r\n\r\n"), "\r\n")
+
+	parts := strings.Fields(lines[0])
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		r.state = statePassthrough
+		return head
+	}
+
+	var (
+		buf           strings.Builder
+		host          string
+		forwardedFor  string
+		forwarded     string
+		contentLength int64
+		chunked       bool
+		upgrade       bool
+	)
+
+	buf.WriteString(lines[0])
+	buf.WriteString("\r\n")
+
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			buf.WriteString(line)
+			buf.WriteString("\r\n")
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(name) {
+		case "host":
+			host = value
+			if r.config.RewriteHost != "" {
+				continue
+			}
+		case "content-length":
+			contentLength, _ = strconv.ParseInt(value, 10, 64)
+		case "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		case "connection":
+			upgrade = strings.Contains(strings.ToLower(value), "upgrade")
+		case "x-forwarded-for":
+			if r.config.ForwardedHeaders {
+				forwardedFor = value
+				continue
+			}
+		case "forwarded":
+			if r.config.ForwardedHeaders {
+				forwarded = value
+				continue
+			}
+		case "x-forwarded-proto", "x-forwarded-host":
+			if r.config.ForwardedHeaders {
+				continue
+			}
+		}
+
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+
+	if r.config.RewriteHost != "" {
+		writeHeader(&buf, "Host", r.config.RewriteHost)
+	}
+
+	if r.config.ForwardedHeaders {
+		writeHeader(&buf, "X-Forwarded-For", joinHeader(forwardedFor, r.clientIP))
+		writeHeader(&buf, "X-Forwarded-Proto", r.proto)
+		if host != "" {
+			writeHeader(&buf, "X-Forwarded-Host", host)
+		}
+		writeHeader(&buf, "Forwarded", joinHeader(forwarded, r.forwardedElement(host)))
+	}
+
+	buf.WriteString("\r\n")
+
+	switch {
+	case upgrade || parts[0] == "CONNECT":
+		r.state = statePassthrough
+	case chunked:
+		r.state = stateChunkSize
+	case contentLength > 0:
+		r.remaining = contentLength
+		r.state = stateBody
+	default:
+		r.state = stateHeaders
+	}
+
+	return []byte(buf.String())
+}
+
+func (r *HTTPRewriter) forwardedElement(host string) string {
+	forIP := r.clientIP
+	if strings.Contains(forIP, ":") {
+		forIP = `"[` + forIP + `]"`
+	}
+
+	elem := "for=" + forIP
+	if host != "" {
+		elem += ";host=" + strconv.Quote(host)
+	}
+	return elem + ";proto=" + r.proto
+}
+
+func writeHeader(buf *strings.Builder, name, value string) {
+	buf.WriteString(name)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
+
+func joinHeader(existing, value string) string {
+	if existing == "" {
+		return value
+	}
+	return existing + ", " + value
+}
+
+// looksLikeRequest reports whether a partial head could still be an HTTP/1.x
+// request or the HTTP/2 preface, so non-HTTP streams are not held back
+// waiting for a blank line.
+func looksLikeRequest(head []byte) bool {
+	for i, c := range head {
+		switch {
+		case c == ' ' && i > 0:
+			line, _, complete := bytes.Cut(head, []byte("\n"))
+			if !complete {
+				return true
+			}
+			line = bytes.TrimRight(line, "\r")
+			return bytes.HasSuffix(line, []byte("HTTP/1.0")) ||
+				bytes.HasSuffix(line, []byte("HTTP/1.1")) ||
+				bytes.Equal(line, []byte("PRI * HTTP/2.0")) // the h2c preface
+		case c < 'A' || c > 'Z':
+			return false
+		}
+	}
+	return true
+}
+
+func parseChunkSize(line []byte) (int64, error) {
+	s := strings.TrimSpace(string(line))
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	return strconv.ParseInt(strings.TrimSpace(s), 16, 64)
+}
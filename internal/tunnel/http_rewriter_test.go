@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPRewriterKeepAlive(t *testing.T) {
+	rw := NewHTTPRewriter(HeaderRewriteConfig{
+		ForwardedHeaders: true,
+		RewriteHost:      "localhost:5173",
+	}, "203.0.113.7:51234", "http")
+
+	stream := "POST /upload HTTP/1.1\r\nHost: demo.example.com\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /chunks HTTP/1.1\r\nHost: demo.example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"GET /next HTTP/1.1\r\nHost: demo.example.com\r\nX-Forwarded-For: 10.0.0.1\r\n\r\n"
+
+	// Feed the stream in small pieces to exercise state carried between writes.
+	var out []byte
+	data := []byte(stream)
+	for len(data) > 0 {
+		n := 7
+		if n > len(data) {
+			n = len(data)
+		}
+		out = append(out, rw.Rewrite(data[:n])...)
+		data = data[n:]
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(out))
+	for i, path := range []string{"/upload", "/chunks", "/next"} {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if req.URL.Path != path {
+			t.Fatalf("request %d: expected path %s, got %s", i, path, req.URL.Path)
+		}
+		if req.Host != "localhost:5173" {
+			t.Fatalf("request %d: host not rewritten: %s", i, req.Host)
+		}
+		if got := req.Header.Get("X-Forwarded-Host"); got != "demo.example.com" {
+			t.Fatalf("request %d: unexpected X-Forwarded-Host %q", i, got)
+		}
+		if got := req.Header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Fatalf("request %d: unexpected X-Forwarded-Proto %q", i, got)
+		}
+		if req.Header.Get("Forwarded") == "" {
+			t.Fatalf("request %d: missing Forwarded header", i)
+		}
+
+		wantXFF := "203.0.113.7"
+		if path == "/next" {
+			wantXFF = "10.0.0.1, 203.0.113.7"
+		}
+		if got := req.Header.Get("X-Forwarded-For"); got != wantXFF {
+			t.Fatalf("request %d: expected X-Forwarded-For %q, got %q", i, wantXFF, got)
+		}
+
+		body := new(bytes.Buffer)
+		body.ReadFrom(req.Body)
+		req.Body.Close()
+	}
+}
+
+func TestHTTPRewriterPassesThroughNonHTTP(t *testing.T) {
+	rw := NewHTTPRewriter(HeaderRewriteConfig{ForwardedHeaders: true}, "203.0.113.7:51234", "http")
+
+	data := []byte("SSH-2.0-OpenSSH_9.6\r\n\r\nbinary")
+	if got := rw.Rewrite(data); !bytes.Equal(got, data) {
+		t.Fatalf("expected passthrough, got %q", got)
+	}
+}
+
+func TestHTTPRewriterDoesNotBufferNonHTTP(t *testing.T) {
+	rw := NewHTTPRewriter(HeaderRewriteConfig{ForwardedHeaders: true}, "203.0.113.7:51234", "http")
+
+	data := []byte("SSH-2.0-OpenSSH_9.6\r\n")
+	if got := rw.Rewrite(data); !bytes.Equal(got, data) {
+		t.Fatalf("expected immediate passthrough, got %q", got)
+	}
+}
+
+func TestHTTPRewriterPassesThroughHTTP2(t *testing.T) {
+	rw := NewHTTPRewriter(HeaderRewriteConfig{ForwardedHeaders: true}, "203.0.113.7:51234", "https")
+
+	// The preface may arrive split; nothing is rewritten or lost.
+	preface := []byte(http2Preface + "\x00\x00\x00\x04\x00\x00\x00\x00\x00")
+	var got []byte
+	got = append(got, rw.Rewrite(preface[:10])...)
+	got = append(got, rw.Rewrite(preface[10:])...)
+	if !bytes.Equal(got, preface) {
+		t.Fatalf("expected the preface to pass unchanged, got %q", got)
+	}
+}
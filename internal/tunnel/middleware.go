@@ -0,0 +1,223 @@
+package tunnel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bakare-dev/gotunnel/internal/metrics"
+)
+
+// StreamInfo describes the stream a middleware is attached to. Inbound
+// data flows from the visitor to the local service, outbound data back.
+type StreamInfo struct {
+	ID         uint32
+	RemoteAddr string // visitor address
+	LocalAddr  string // public endpoint the visitor connected to
+	TLS        bool   // the server terminated TLS for the visitor
+	ServerName string
+	Metrics    *metrics.Metrics
+}
+
+// StreamMiddleware sees the traffic of one stream. Open may reject the
+// stream. Inbound and Outbound return the bytes to pass on: the input, a
+// rewritten copy, or nothing to hold data back until a later call; an
+// error resets the stream. data is only valid during the call. When a
+// direction reaches EOF, FlushInbound or FlushOutbound returns whatever is
+// still held back for it. The hooks of one stream are never called
+// concurrently.
+type StreamMiddleware interface {
+	Open(info *StreamInfo) error
+	Inbound(data []byte) ([]byte, error)
+	Outbound(data []byte) ([]byte, error)
+	FlushInbound() ([]byte, error)
+	FlushOutbound() ([]byte, error)
+	Close()
+}
+
+// MiddlewareFactory creates the middleware state for a new stream.
+type MiddlewareFactory func() StreamMiddleware
+
+// Passthrough implements every hook as a no-op, for embedding in
+// middlewares that only need some of them.
+type Passthrough struct{}
+
+func (Passthrough) Open(*StreamInfo) error               { return nil }
+func (Passthrough) Inbound(data []byte) ([]byte, error)  { return data, nil }
+func (Passthrough) Outbound(data []byte) ([]byte, error) { return data, nil }
+func (Passthrough) FlushInbound() ([]byte, error)        { return nil, nil }
+func (Passthrough) FlushOutbound() ([]byte, error)       { return nil, nil }
+func (Passthrough) Close()                               {}
+
+var (
+	middlewaresMu sync.RWMutex
+	middlewares   = map[string]MiddlewareFactory{}
+)
+
+// RegisterMiddleware makes a middleware available by name to the server
+// config, client policies and the client's --middleware flag.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	middlewares[name] = factory
+}
+
+// MiddlewareNames lists the registered middlewares.
+func MiddlewareNames() []string {
+	middlewaresMu.RLock()
+	defer middlewaresMu.RUnlock()
+
+	names := make([]string, 0, len(middlewares))
+	for name := range middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupMiddlewares resolves registered middleware names, in order.
+func LookupMiddlewares(names []string) (MiddlewareChain, error) {
+	middlewaresMu.RLock()
+	defer middlewaresMu.RUnlock()
+
+	var chain MiddlewareChain
+	for _, name := range names {
+		factory, ok := middlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", name)
+		}
+		chain = append(chain, factory)
+	}
+	return chain, nil
+}
+
+// MiddlewareChain lists the middlewares a tunnel's streams pass through.
+// Inbound data visits them in order and outbound data in reverse, so the
+// first one sits closest to the visitor.
+type MiddlewareChain []MiddlewareFactory
+
+// Open starts a pipeline for a new stream. If a middleware rejects the
+// stream, the ones already opened are closed again.
+func (c MiddlewareChain) Open(info *StreamInfo) (*StreamPipeline, error) {
+	p := &StreamPipeline{}
+	for _, factory := range c {
+		m := factory()
+		if err := m.Open(info); err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.stages = append(p.stages, m)
+	}
+	return p, nil
+}
+
+// StreamPipeline runs one stream through its middlewares. It is safe to
+// feed both directions concurrently. Once closed, it passes nothing on.
+type StreamPipeline struct {
+	mu     sync.Mutex
+	stages []StreamMiddleware
+	closed bool
+}
+
+func (p *StreamPipeline) Inbound(data []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, nil
+	}
+	for i := 0; i < len(p.stages) && len(data) > 0; i++ {
+		var err error
+		if data, err = p.stages[i].Inbound(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (p *StreamPipeline) Outbound(data []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, nil
+	}
+	for i := len(p.stages) - 1; i >= 0 && len(data) > 0; i-- {
+		var err error
+		if data, err = p.stages[i].Outbound(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// FlushInbound ends the inbound direction: each middleware's held-back
+// data runs through the ones after it before they flush in turn.
+func (p *StreamPipeline) FlushInbound() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, nil
+	}
+	var data []byte
+	for i := 0; i < len(p.stages); i++ {
+		var err error
+		if data, err = flushStage(p.stages[i].Inbound, p.stages[i].FlushInbound, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// FlushOutbound ends the outbound direction, in reverse order.
+func (p *StreamPipeline) FlushOutbound() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, nil
+	}
+	var data []byte
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		var err error
+		if data, err = flushStage(p.stages[i].Outbound, p.stages[i].FlushOutbound, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// flushStage passes data flushed by earlier stages through one stage and
+// appends what that stage held back.
+func flushStage(pass func([]byte) ([]byte, error), flush func() ([]byte, error), data []byte) ([]byte, error) {
+	var err error
+	if len(data) > 0 {
+		if data, err = pass(data); err != nil {
+			return nil, err
+		}
+	}
+	rest, err := flush()
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) == 0 {
+		return data, nil
+	}
+	return append(data[:len(data):len(data)], rest...), nil
+}
+
+// Close ends the stream for every middleware, last one first. Data a
+// middleware still holds back is dropped; flush first to keep it.
+func (p *StreamPipeline) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		p.stages[i].Close()
+	}
+}
@@ -0,0 +1,196 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bakare-dev/gotunnel/internal/metrics"
+)
+
+// tagMiddleware appends its name to everything it passes on and records
+// the order hooks ran in.
+type tagMiddleware struct {
+	Passthrough
+	name    string
+	calls   *[]string
+	openErr error
+}
+
+func (m *tagMiddleware) Open(*StreamInfo) error {
+	*m.calls = append(*m.calls, "open "+m.name)
+	return m.openErr
+}
+
+func (m *tagMiddleware) Inbound(data []byte) ([]byte, error) {
+	return append(append([]byte(nil), data...), m.name...), nil
+}
+
+func (m *tagMiddleware) Outbound(data []byte) ([]byte, error) {
+	return append(append([]byte(nil), data...), m.name...), nil
+}
+
+func (m *tagMiddleware) Close() {
+	*m.calls = append(*m.calls, "close "+m.name)
+}
+
+// lineMiddleware holds inbound data back until a full line has arrived
+// and rejects lines containing "deny".
+type lineMiddleware struct {
+	Passthrough
+	pending []byte
+}
+
+func (m *lineMiddleware) FlushInbound() ([]byte, error) {
+	out := m.pending
+	m.pending = nil
+	return out, nil
+}
+
+func (m *lineMiddleware) Inbound(data []byte) ([]byte, error) {
+	m.pending = append(m.pending, data...)
+	i := bytes.LastIndexByte(m.pending, '\n')
+	if i < 0 {
+		return nil, nil
+	}
+	out := m.pending[:i+1]
+	m.pending = append([]byte(nil), m.pending[i+1:]...)
+	if bytes.Contains(out, []byte("deny")) {
+		return nil, errors.New("denied")
+	}
+	return out, nil
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	var calls []string
+	tag := func(name string) MiddlewareFactory {
+		return func() StreamMiddleware { return &tagMiddleware{name: name, calls: &calls} }
+	}
+
+	p, err := MiddlewareChain{tag("a"), tag("b")}.Open(&StreamInfo{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, _ := p.Inbound([]byte("in:")); string(out) != "in:ab" {
+		t.Fatalf("expected inbound data to visit a then b, got %q", out)
+	}
+	if out, _ := p.Outbound([]byte("out:")); string(out) != "out:ba" {
+		t.Fatalf("expected outbound data to visit b then a, got %q", out)
+	}
+
+	p.Close()
+	p.Close()
+	if got := strings.Join(calls, ", "); got != "open a, open b, close b, close a" {
+		t.Fatalf("unexpected hook order: %s", got)
+	}
+
+	// Data racing the close is dropped instead of reaching closed stages.
+	if out, err := p.Inbound([]byte("late")); out != nil || err != nil {
+		t.Fatalf("expected nothing after close, got %q, %v", out, err)
+	}
+	if out, err := p.Outbound([]byte("late")); out != nil || err != nil {
+		t.Fatalf("expected nothing after close, got %q, %v", out, err)
+	}
+}
+
+func TestMiddlewareChainRejectOnOpen(t *testing.T) {
+	var calls []string
+	chain := MiddlewareChain{
+		func() StreamMiddleware { return &tagMiddleware{name: "a", calls: &calls} },
+		func() StreamMiddleware { return &tagMiddleware{name: "b", calls: &calls, openErr: errors.New("no")} },
+		func() StreamMiddleware { return &tagMiddleware{name: "c", calls: &calls} },
+	}
+
+	if _, err := chain.Open(&StreamInfo{}); err == nil {
+		t.Fatal("expected the stream to be rejected")
+	}
+	if got := strings.Join(calls, ", "); got != "open a, open b, close a" {
+		t.Fatalf("unexpected hook order: %s", got)
+	}
+}
+
+func TestMiddlewareBufferAndReject(t *testing.T) {
+	var calls []string
+	chain := MiddlewareChain{
+		func() StreamMiddleware { return &lineMiddleware{} },
+		func() StreamMiddleware { return &tagMiddleware{name: "!", calls: &calls} },
+	}
+	p, err := chain.Open(&StreamInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := p.Inbound([]byte("hel")); err != nil || len(out) != 0 {
+		t.Fatalf("expected data to be held back, got %q, %v", out, err)
+	}
+	if out, _ := p.Inbound([]byte("lo\nwor")); string(out) != "hello\n!" {
+		t.Fatalf("expected the completed line, got %q", out)
+	}
+	if _, err := p.Inbound([]byte("ld deny\n")); err == nil {
+		t.Fatal("expected the stream to be rejected")
+	}
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	var calls []string
+	chain := MiddlewareChain{
+		func() StreamMiddleware { return &lineMiddleware{} },
+		func() StreamMiddleware { return &tagMiddleware{name: "!", calls: &calls} },
+		HeaderRewriteMiddleware(HeaderRewriteConfig{ForwardedHeaders: true}),
+	}
+	p, err := chain.Open(&StreamInfo{RemoteAddr: "203.0.113.7:5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The line is held by the first stage and the partial request head by
+	// the rewriter; both come out at EOF, in order.
+	if out, _ := p.Inbound([]byte("GET / HTTP/1.1\n")); len(out) != 0 {
+		t.Fatalf("expected the rewriter to wait for the full head, got %q", out)
+	}
+	if _, err := p.Inbound([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := p.FlushInbound(); err != nil || string(out) != "GET / HTTP/1.1\n!tail!" {
+		t.Fatalf("expected the held data, got %q, %v", out, err)
+	}
+	if out, err := p.FlushOutbound(); err != nil || len(out) != 0 {
+		t.Fatalf("expected nothing held outbound, got %q, %v", out, err)
+	}
+}
+
+func TestLookupMiddlewares(t *testing.T) {
+	RegisterMiddleware("test-passthrough", func() StreamMiddleware { return Passthrough{} })
+
+	chain, err := LookupMiddlewares([]string{"test-passthrough"})
+	if err != nil || len(chain) != 1 {
+		t.Fatalf("unexpected result %v, %v", chain, err)
+	}
+	if _, err := LookupMiddlewares([]string{"inspect", "decode", "rewrite"}); err != nil {
+		t.Fatalf("expected the built-in middlewares to be registered: %v", err)
+	}
+	if _, err := LookupMiddlewares([]string{"test-passthrough", "missing"}); err == nil {
+		t.Fatal("expected an unknown middleware to be rejected")
+	}
+}
+
+func TestHeaderRewriteMiddleware(t *testing.T) {
+	chain := MiddlewareChain{
+		InspectMiddleware(InspectConfig{}),
+		HeaderRewriteMiddleware(HeaderRewriteConfig{ForwardedHeaders: true}),
+	}
+	p, err := chain.Open(&StreamInfo{ID: 1, RemoteAddr: "203.0.113.7:5000", TLS: true, Metrics: metrics.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	out, _ := p.Inbound([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if !bytes.Contains(out, []byte("X-Forwarded-For: 203.0.113.7\r\n")) ||
+		!bytes.Contains(out, []byte("X-Forwarded-Proto: https\r\n")) {
+		t.Fatalf("expected forwarding headers, got %q", out)
+	}
+}
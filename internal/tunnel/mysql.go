@@ -0,0 +1,367 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	mysqlClientSSL             = 1 << 11
+	mysqlClientDeprecateEOF    = 1 << 24
+	mysqlClientQueryAttributes = 1 << 27
+
+	mysqlMoreResultsExists = 0x0008
+	mysqlMaxPacket         = 0xffffff
+)
+
+var mysqlCommands = map[byte]string{
+	0x02: "USE",
+	0x0e: "PING",
+	0x11: "CHANGE_USER",
+	0x16: "PREPARE",
+	0x17: "EXECUTE",
+	0x1a: "STMT_RESET",
+	0x1f: "RESET",
+}
+
+type mysqlPhase uint8
+
+const (
+	mysqlFirst   mysqlPhase = iota // OK, ERR or a column count
+	mysqlColumns                   // column definitions
+	mysqlEOF                       // EOF after column definitions
+	mysqlRows                      // rows up to the closing EOF/OK
+	mysqlSkip                      // prepare metadata
+)
+
+// mysqlDecoder follows the MySQL client/server protocol: the server
+// greeting, the client's handshake response and the command phase, where
+// each command gets exactly one response.
+type mysqlDecoder struct {
+	client, server msgReader
+
+	greeted    bool
+	serverCaps uint32
+	caps       uint32
+	connect    *Command
+
+	cmd       *Command
+	cmdByte   byte
+	phase     mysqlPhase
+	skip      int
+	preparing string
+	stmts     map[uint32]string
+
+	// A packet of exactly mysqlMaxPacket bytes continues in the next one.
+	clientContinued, serverContinued bool
+
+	done []*Command
+}
+
+func mysqlHeaderSize() int { return 4 }
+
+func mysqlLength(header []byte) int {
+	return int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+}
+
+func (d *mysqlDecoder) Request(data []byte) ([]*Command, error) {
+	if !d.greeted {
+		return nil, ErrNotRecognized // the server speaks first
+	}
+	d.done = nil
+	err := d.client.read(data, mysqlHeaderSize, func(header []byte) (int, int, error) {
+		return mysqlLength(header), 256, nil
+	}, d.endClient)
+	return d.done, err
+}
+
+func (d *mysqlDecoder) Response(data []byte) ([]*Command, error) {
+	d.done = nil
+	err := d.server.read(data, mysqlHeaderSize, d.beginServer, d.endServer)
+	return d.done, err
+}
+
+func (d *mysqlDecoder) endClient(header, payload []byte) error {
+	length := mysqlLength(header)
+	continued := d.clientContinued
+	d.clientContinued = length == mysqlMaxPacket
+	if continued || len(payload) == 0 {
+		return nil
+	}
+
+	if d.caps == 0 {
+		// HandshakeResponse41, or an SSLRequest ahead of TLS.
+		if len(payload) < 4 {
+			return ErrNotRecognized
+		}
+		caps := binary.LittleEndian.Uint32(payload)
+		if caps&mysqlClientSSL != 0 {
+			return ErrNotRecognized // encrypted from here on
+		}
+		d.caps = caps&d.serverCaps | 1 // never zero once set
+		d.connect = &Command{Name: "CONNECT", StartTime: time.Now()}
+		return nil
+	}
+
+	if d.connect != nil || header[3] != 0 {
+		return nil // authentication exchange or a continuation
+	}
+
+	d.cmdByte = payload[0]
+	d.phase = mysqlFirst
+
+	var name string
+	switch d.cmdByte {
+	case 0x01, 0x18, 0x19: // QUIT, STMT_SEND_LONG_DATA, STMT_CLOSE: no response
+		d.cmd = nil
+		return nil
+	case 0x03:
+		name = statementType(string(d.queryText(payload[1:])))
+	case 0x16:
+		name = "PREPARE"
+		d.preparing = statementType(string(payload[1:]))
+	case 0x17:
+		name = "EXECUTE"
+		if len(payload) >= 5 {
+			if stmt, ok := d.stmts[binary.LittleEndian.Uint32(payload[1:])]; ok {
+				name = stmt
+			}
+		}
+	default:
+		name = mysqlCommands[d.cmdByte]
+		if name == "" {
+			name = fmt.Sprintf("COM_%#02x", d.cmdByte)
+		}
+	}
+
+	d.cmd = &Command{Name: name, StartTime: time.Now()}
+	return nil
+}
+
+// queryText skips the query attributes that clients with
+// CLIENT_QUERY_ATTRIBUTES put ahead of the statement.
+func (d *mysqlDecoder) queryText(payload []byte) []byte {
+	if d.caps&mysqlClientQueryAttributes == 0 {
+		return payload
+	}
+
+	count, n := mysqlLenEnc(payload)
+	if n == 0 || count != 0 {
+		return nil // bound parameters come first; the statement is hard to reach
+	}
+	_, m := mysqlLenEnc(payload[n:])
+	return payload[n+m:]
+}
+
+func (d *mysqlDecoder) beginServer(header []byte) (int, int, error) {
+	length := mysqlLength(header)
+	if !d.greeted && (header[3] != 0 || length > 1024) {
+		return 0, 0, ErrNotRecognized // not a greeting
+	}
+	return length, 128, nil
+}
+
+func (d *mysqlDecoder) endServer(header, payload []byte) error {
+	length := mysqlLength(header)
+	continued := d.serverContinued
+	d.serverContinued = length == mysqlMaxPacket
+	if continued {
+		return nil
+	}
+
+	if !d.greeted {
+		// Protocol version 10 greeting.
+		if len(payload) < 1 || payload[0] != 0x0a {
+			return ErrNotRecognized
+		}
+		d.greeted = true
+		d.serverCaps = mysqlServerCaps(payload)
+		d.stmts = make(map[uint32]string)
+		return nil
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if d.connect != nil {
+		switch payload[0] {
+		case 0x00:
+			d.finish(d.connect, "")
+			d.connect = nil
+		case 0xff:
+			d.finish(d.connect, mysqlErrorCode(payload))
+			return errDecoderDone
+		}
+		return nil // auth switch or more auth data
+	}
+
+	if d.cmd == nil {
+		return nil
+	}
+
+	switch d.phase {
+	case mysqlFirst:
+		switch payload[0] {
+		case 0x00:
+			if d.cmdByte == 0x16 {
+				d.prepared(payload)
+				return nil
+			}
+			if mysqlStatus(payload)&mysqlMoreResultsExists == 0 {
+				d.complete("")
+			}
+		case 0xff:
+			d.complete(mysqlErrorCode(payload))
+		case 0xfb: // LOCAL INFILE; the file upload is not followed
+			d.complete("")
+		default:
+			columns, _ := mysqlLenEnc(payload)
+			d.phase, d.skip = mysqlColumns, int(columns)
+		}
+
+	case mysqlColumns:
+		if d.skip--; d.skip == 0 {
+			d.phase = mysqlRows
+			if d.caps&mysqlClientDeprecateEOF == 0 {
+				d.phase = mysqlEOF
+			}
+		}
+
+	case mysqlEOF:
+		d.phase = mysqlRows
+
+	case mysqlRows:
+		switch {
+		case payload[0] == 0xff:
+			d.complete(mysqlErrorCode(payload))
+		case payload[0] == 0xfe && length < mysqlMaxPacket:
+			if mysqlStatus(payload)&mysqlMoreResultsExists != 0 {
+				d.phase = mysqlFirst
+			} else {
+				d.complete("")
+			}
+		}
+
+	case mysqlSkip:
+		if d.skip--; d.skip == 0 {
+			d.complete("")
+		}
+	}
+	return nil
+}
+
+// prepared handles COM_STMT_PREPARE_OK, remembering the statement type for
+// later executes and skipping the parameter and column definitions.
+func (d *mysqlDecoder) prepared(payload []byte) {
+	if len(payload) < 9 {
+		d.complete("")
+		return
+	}
+
+	if len(d.stmts) >= maxTrackedStatements {
+		clear(d.stmts)
+	}
+	d.stmts[binary.LittleEndian.Uint32(payload[1:])] = d.preparing
+
+	columns := int(binary.LittleEndian.Uint16(payload[5:]))
+	params := int(binary.LittleEndian.Uint16(payload[7:]))
+	skip := columns + params
+	if d.caps&mysqlClientDeprecateEOF == 0 {
+		if columns > 0 {
+			skip++
+		}
+		if params > 0 {
+			skip++
+		}
+	}
+
+	if skip == 0 {
+		d.complete("")
+		return
+	}
+	d.phase, d.skip = mysqlSkip, skip
+}
+
+func (d *mysqlDecoder) complete(code string) {
+	d.finish(d.cmd, code)
+	d.cmd = nil
+}
+
+func (d *mysqlDecoder) finish(cmd *Command, code string) {
+	cmd.Error = code
+	cmd.Duration = time.Since(cmd.StartTime)
+	d.done = append(d.done, cmd)
+}
+
+// mysqlServerCaps reads the capability flags from a v10 greeting.
+func mysqlServerCaps(payload []byte) uint32 {
+	i := bytes.IndexByte(payload[1:], 0)
+	if i < 0 {
+		return 0
+	}
+	// version, NUL, connection id, auth data part 1, filler
+	off := 1 + i + 1 + 4 + 8 + 1
+	if len(payload) < off+7 {
+		return 0
+	}
+	p := payload[off:]
+	return uint32(binary.LittleEndian.Uint16(p)) | uint32(binary.LittleEndian.Uint16(p[5:]))<<16
+}
+
+// mysqlStatus reads the status flags of an OK or EOF packet.
+func mysqlStatus(payload []byte) uint16 {
+	p := payload[1:]
+	if payload[0] == 0xfe && len(payload) < 9 {
+		// EOF: warnings, then status
+		if len(p) >= 4 {
+			return binary.LittleEndian.Uint16(p[2:])
+		}
+		return 0
+	}
+
+	_, n := mysqlLenEnc(p) // affected rows
+	p = p[n:]
+	_, n = mysqlLenEnc(p) // last insert id
+	p = p[n:]
+	if n == 0 || len(p) < 2 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(p)
+}
+
+func mysqlErrorCode(payload []byte) string {
+	if len(payload) < 3 {
+		return "error"
+	}
+	return fmt.Sprintf("ERR %d", binary.LittleEndian.Uint16(payload[1:]))
+}
+
+// mysqlLenEnc decodes a length-encoded integer, returning its size in
+// bytes, or 0 if it is truncated.
+func mysqlLenEnc(p []byte) (uint64, int) {
+	if len(p) == 0 {
+		return 0, 0
+	}
+
+	size := 1
+	switch p[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	}
+	if len(p) < size {
+		return 0, 0
+	}
+
+	if size == 1 {
+		return uint64(p[0]), 1
+	}
+	var buf [8]byte
+	copy(buf[:], p[1:size])
+	return binary.LittleEndian.Uint64(buf[:]), size
+}
@@ -0,0 +1,297 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+	pgCancelRequest = 80877102
+
+	// Statements and queued commands kept per connection before the
+	// decoder gives up on it.
+	maxTrackedStatements = 1024
+	maxPendingCommands   = 4096
+)
+
+var errDecoderDone = errors.New("tunnel: decoder finished with stream")
+
+// msgReader splits a stream into length-prefixed messages and keeps only
+// the start of each payload, so large results pass through unbuffered.
+type msgReader struct {
+	header    [8]byte
+	headerLen int
+	inBody    bool
+	remaining int
+	keep      int
+	payload   []byte
+}
+
+// read feeds data through begin, which sizes the message from its header,
+// and end, which gets the header and the kept part of the payload.
+func (m *msgReader) read(data []byte, headerSize func() int,
+	begin func(header []byte) (length, keep int, err error),
+	end func(header, payload []byte) error) error {
+
+	for len(data) > 0 {
+		if !m.inBody {
+			size := headerSize()
+			n := copy(m.header[m.headerLen:size], data)
+			m.headerLen += n
+			data = data[n:]
+			if m.headerLen < size {
+				return nil
+			}
+
+			length, keep, err := begin(m.header[:size])
+			if err != nil {
+				return err
+			}
+			m.inBody, m.remaining, m.keep = true, length, keep
+			m.payload = m.payload[:0]
+		}
+
+		n := min(m.remaining, len(data))
+		if k := min(n, m.keep-len(m.payload)); k > 0 {
+			m.payload = append(m.payload, data[:k]...)
+		}
+		m.remaining -= n
+		data = data[n:]
+
+		if m.remaining == 0 {
+			size := m.headerLen
+			m.inBody, m.headerLen = false, 0
+			if err := end(m.header[:size], m.payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type pgPending struct {
+	cmd    *Command
+	simple bool // a simple query, answered up to ReadyForQuery
+	sync   bool // marks where a Sync's ReadyForQuery ends an extended batch
+}
+
+// postgresDecoder follows the PostgreSQL v3 protocol: the startup packet,
+// simple queries, and the Parse/Bind/Execute/Sync cycle drivers use for
+// prepared statements.
+type postgresDecoder struct {
+	client, server msgReader
+
+	started    bool
+	sslPending bool
+	connect    *Command
+
+	pending    []*pgPending
+	statements map[string]string
+	portals    map[string]string
+	done       []*Command
+}
+
+func (d *postgresDecoder) Request(data []byte) ([]*Command, error) {
+	d.done = nil
+	err := d.client.read(data, d.clientHeaderSize, d.beginClient, d.endClient)
+	return d.done, err
+}
+
+func (d *postgresDecoder) Response(data []byte) ([]*Command, error) {
+	d.done = nil
+
+	if d.sslPending && len(data) > 0 {
+		// The answer to SSLRequest/GSSENCRequest is a single byte.
+		if data[0] != 'N' {
+			return nil, ErrNotRecognized // encrypted from here on
+		}
+		d.sslPending = false
+		data = data[1:]
+	}
+	if !d.started {
+		if len(data) > 0 {
+			return nil, ErrNotRecognized
+		}
+		return nil, nil
+	}
+
+	err := d.server.read(data, func() int { return 5 }, d.beginServer, d.endServer)
+	return d.done, err
+}
+
+func (d *postgresDecoder) clientHeaderSize() int {
+	if !d.started {
+		return 4 // startup-phase packets have no type byte
+	}
+	return 5
+}
+
+func (d *postgresDecoder) beginClient(header []byte) (int, int, error) {
+	if !d.started {
+		length := int(binary.BigEndian.Uint32(header))
+		if length < 8 || length > 10000 || d.sslPending {
+			return 0, 0, ErrNotRecognized
+		}
+		return length - 4, 4, nil
+	}
+
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if length < 4 {
+		return 0, 0, ErrNotRecognized
+	}
+
+	keep := 0
+	switch header[0] {
+	case 'Q', 'B', 'E':
+		keep = 256
+	case 'P':
+		keep = 512
+	}
+	return length - 4, keep, nil
+}
+
+func (d *postgresDecoder) endClient(header, payload []byte) error {
+	if !d.started {
+		switch code := binary.BigEndian.Uint32(payload); {
+		case code == pgSSLRequest || code == pgGSSENCRequest:
+			d.sslPending = true
+		case code == pgCancelRequest:
+			return errDecoderDone
+		case code>>16 == 3:
+			d.started = true
+			d.connect = &Command{Name: "CONNECT", StartTime: time.Now()}
+			d.statements = make(map[string]string)
+			d.portals = make(map[string]string)
+		default:
+			return ErrNotRecognized
+		}
+		return nil
+	}
+
+	fields := bytes.Split(payload, []byte{0})
+	field := func(i int) string {
+		if i < len(fields) {
+			return string(fields[i])
+		}
+		return ""
+	}
+
+	switch header[0] {
+	case 'Q':
+		return d.push(&pgPending{simple: true, cmd: d.command(statementType(field(0)))})
+	case 'P':
+		if len(d.statements) >= maxTrackedStatements {
+			clear(d.statements)
+		}
+		d.statements[field(0)] = statementType(field(1))
+	case 'B':
+		if len(d.portals) >= maxTrackedStatements {
+			clear(d.portals)
+		}
+		d.portals[field(0)] = d.statements[field(1)]
+	case 'E':
+		name := d.portals[field(0)]
+		if name == "" {
+			name = "EXECUTE"
+		}
+		return d.push(&pgPending{cmd: d.command(name)})
+	case 'S':
+		return d.push(&pgPending{sync: true})
+	}
+	return nil
+}
+
+func (d *postgresDecoder) command(name string) *Command {
+	return &Command{Name: name, StartTime: time.Now()}
+}
+
+func (d *postgresDecoder) push(p *pgPending) error {
+	if len(d.pending) >= maxPendingCommands {
+		return errDecoderDone
+	}
+	d.pending = append(d.pending, p)
+	return nil
+}
+
+func (d *postgresDecoder) beginServer(header []byte) (int, int, error) {
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if length < 4 {
+		return 0, 0, ErrNotRecognized
+	}
+
+	keep := 0
+	if header[0] == 'E' {
+		keep = 256
+	}
+	return length - 4, keep, nil
+}
+
+func (d *postgresDecoder) endServer(header, payload []byte) error {
+	switch header[0] {
+	case 'E': // ErrorResponse
+		code := pgErrorCode(payload)
+		if d.connect != nil {
+			d.finish(d.connect, code)
+			d.connect = nil
+			return nil
+		}
+		if len(d.pending) == 0 {
+			return nil
+		}
+		if head := d.pending[0]; head.simple {
+			head.cmd.Error = code
+		} else if !head.sync {
+			d.finish(head.cmd, code)
+			d.pending = d.pending[1:]
+		}
+
+	case 'C', 'I', 's': // CommandComplete, EmptyQueryResponse, PortalSuspended
+		if len(d.pending) > 0 && !d.pending[0].simple && !d.pending[0].sync {
+			d.finish(d.pending[0].cmd, "")
+			d.pending = d.pending[1:]
+		}
+
+	case 'Z': // ReadyForQuery
+		if d.connect != nil {
+			d.finish(d.connect, "")
+			d.connect = nil
+			return nil
+		}
+		// Executes skipped after an error never get an answer of their own.
+		for len(d.pending) > 0 {
+			head := d.pending[0]
+			d.pending = d.pending[1:]
+			if head.simple {
+				d.finish(head.cmd, head.cmd.Error)
+				break
+			}
+			if head.sync {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (d *postgresDecoder) finish(cmd *Command, code string) {
+	cmd.Error = code
+	cmd.Duration = time.Since(cmd.StartTime)
+	d.done = append(d.done, cmd)
+}
+
+// pgErrorCode pulls the SQLSTATE out of an ErrorResponse.
+func pgErrorCode(payload []byte) string {
+	for len(payload) > 1 {
+		typ := payload[0]
+		value, rest, _ := bytes.Cut(payload[1:], []byte{0})
+		if typ == 'C' {
+			return "SQLSTATE " + string(value)
+		}
+		payload = rest
+	}
+	return "error"
+}
@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+type ProxyProtocolVersion int
+
+const (
+	ProxyProtocolOff ProxyProtocolVersion = iota
+	ProxyProtocolV1
+	ProxyProtocolV2
+)
+
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+func ParseProxyProtocolVersion(s string) (ProxyProtocolVersion, error) {
+	switch s {
+	case "", "off":
+		return ProxyProtocolOff, nil
+	case "v1", "1":
+		return ProxyProtocolV1, nil
+	case "v2", "2":
+		return ProxyProtocolV2, nil
+	}
+	return ProxyProtocolOff, fmt.Errorf("unknown PROXY protocol version %q (use v1 or v2)", s)
+}
+
+func ProxyHeader(version ProxyProtocolVersion, remoteAddr, localAddr string) []byte {
+	switch version {
+	case ProxyProtocolV1:
+		return proxyHeaderV1(remoteAddr, localAddr)
+	case ProxyProtocolV2:
+		return proxyHeaderV2(remoteAddr, localAddr)
+	}
+	return nil
+}
+
+func proxyHeaderV1(remoteAddr, localAddr string) []byte {
+	src, dst, ok := proxyAddrs(remoteAddr, localAddr)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	proto := "TCP6"
+	if src.Addr().Is4() {
+		proto = "TCP4"
+	}
+
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n",
+		proto, src.Addr(), dst.Addr(), src.Port(), dst.Port())
+}
+
+func proxyHeaderV2(remoteAddr, localAddr string) []byte {
+	buf := append([]byte{}, proxyV2Signature...)
+
+	src, dst, ok := proxyAddrs(remoteAddr, localAddr)
+	if !ok {
+		// LOCAL command, no address block
+		return append(buf, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	if src.Addr().Is4() {
+		buf = append(buf, 0x21, 0x11)
+		buf = binary.BigEndian.AppendUint16(buf, 12)
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		buf = append(buf, s[:]...)
+		buf = append(buf, d[:]...)
+	} else {
+		buf = append(buf, 0x21, 0x21)
+		buf = binary.BigEndian.AppendUint16(buf, 36)
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		buf = append(buf, s[:]...)
+		buf = append(buf, d[:]...)
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, src.Port())
+	return binary.BigEndian.AppendUint16(buf, dst.Port())
+}
+
+// proxyAddrs parses both endpoints and normalizes them to the same family,
+// since PROXY headers cannot mix IPv4 and IPv6 addresses.
+func proxyAddrs(remoteAddr, localAddr string) (netip.AddrPort, netip.AddrPort, bool) {
+	src, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+	dst, err := netip.ParseAddrPort(localAddr)
+	if err != nil {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcIP.Is4() != dstIP.Is4() {
+		srcIP = netip.AddrFrom16(srcIP.As16())
+		dstIP = netip.AddrFrom16(dstIP.As16())
+	}
+
+	return netip.AddrPortFrom(srcIP, src.Port()), netip.AddrPortFrom(dstIP, dst.Port()), true
+}
@@ -0,0 +1,49 @@
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProxyHeaderV1(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		local  string
+		want   string
+	}{
+		{"ipv4", "203.0.113.7:51234", "10.0.0.1:10000", "PROXY TCP4 203.0.113.7 10.0.0.1 51234 10000\r\n"},
+		{"ipv6", "[2001:db8::1]:51234", "[2001:db8::2]:10000", "PROXY TCP6 2001:db8::1 2001:db8::2 51234 10000\r\n"},
+		{"mixed", "203.0.113.7:51234", "[::1]:10000", "PROXY TCP6 ::ffff:203.0.113.7 ::1 51234 10000\r\n"},
+		{"unknown", "pipe", "pipe", "PROXY UNKNOWN\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(ProxyHeader(ProxyProtocolV1, tt.remote, tt.local))
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderV2IPv4(t *testing.T) {
+	got := ProxyHeader(ProxyProtocolV2, "203.0.113.7:51234", "10.0.0.1:10000")
+
+	want := append([]byte{}, proxyV2Signature...)
+	want = append(want, 0x21, 0x11, 0x00, 0x0C)
+	want = append(want, 203, 0, 113, 7, 10, 0, 0, 1)
+	want = append(want, 0xC8, 0x22, 0x27, 0x10)
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got % x, want % x", got, want)
+	}
+}
+
+func TestProxyHeaderV2Unknown(t *testing.T) {
+	got := ProxyHeader(ProxyProtocolV2, "", "")
+	if len(got) != 16 || got[12] != 0x20 {
+		t.Fatalf("expected LOCAL header, got % x", got)
+	}
+}
@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrRequestHeadTooLarge = errors.New("tunnel: request head too large")
+	ErrNotHTTPRequest      = errors.New("tunnel: not an HTTP request")
+	ErrUnauthorized        = errors.New("tunnel: request without valid credentials")
+)
+
+type PublicAuth struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+func ParseBasicAuth(credentials string) (*PublicAuth, error) {
+	user, pass, ok := strings.Cut(credentials, ":")
+	if !ok || user == "" {
+		return nil, fmt.Errorf("basic auth must be in the form user:password")
+	}
+	return &PublicAuth{Username: user, Password: pass}, nil
+}
+
+func (a *PublicAuth) Enabled() bool {
+	return a != nil && (a.Username != "" || a.BearerToken != "")
+}
+
+func (a *PublicAuth) Authorize(head []byte) bool {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return false
+	}
+
+	if a.Username != "" {
+		if user, pass, ok := req.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(a.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(a.Password)) == 1 {
+			return true
+		}
+	}
+
+	if a.BearerToken != "" {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.BearerToken)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Gate returns a filter that checks the credentials of every request on a
+// keep-alive connection, not just the first one.
+func (a *PublicAuth) Gate() *AuthGate {
+	return &AuthGate{rw: &HTTPRewriter{check: a.Authorize}}
+}
+
+// AuthGate passes visitor data through while each request head carries
+// valid credentials. Upgraded connections (WebSocket, CONNECT) are passed
+// through once the request that upgraded them was authorized.
+type AuthGate struct {
+	rw *HTTPRewriter
+}
+
+// Check returns the data that may be forwarded. Once a request fails the
+// check, or the stream stops being HTTP/1.x, it returns ErrUnauthorized and
+// nothing more is let through.
+func (g *AuthGate) Check(data []byte) ([]byte, error) {
+	out := g.rw.Rewrite(data)
+	if g.rw.state == stateDenied {
+		return out, ErrUnauthorized
+	}
+	return out, nil
+}
+
+func (a *PublicAuth) Challenge() []byte {
+	scheme := `Bearer realm="gotunnel"`
+	if a.Username != "" {
+		scheme = `Basic realm="gotunnel", charset="UTF-8"`
+	}
+
+	body := "401 Unauthorized\n"
+	return fmt.Appendf(nil, "HTTP/1.1 401 Unauthorized\r\n"+
+		"WWW-Authenticate: %s\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Length: %d\r\n"+
+		"Connection: close\r\n"+
+		"\r\n%s", scheme, len(body), body)
+}
+
+// ReadRequestHead reads from r until a complete HTTP request head has been
+// received. It returns every byte read, which may include the start of the
+// body, so the caller can forward the data unchanged.
+func ReadRequestHead(r io.Reader) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+
+		if bytes.Contains(data, []byte("\r\n\r\n")) {
+			return data, nil
+		}
+		if !looksLikeRequest(data) {
+			return data, ErrNotHTTPRequest
+		}
+		if len(data) > maxRequestHeaderSize {
+			return data, ErrRequestHeadTooLarge
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPublicAuthBasic(t *testing.T) {
+	auth, err := ParseBasicAuth("admin:s3cret")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	good := base64.StdEncoding.EncodeToString([]byte("admin:s3cret"))
+	bad := base64.StdEncoding.EncodeToString([]byte("admin:wrong"))
+
+	if !auth.Authorize([]byte("GET / HTTP/1.1\r\nHost: x\r\nAuthorization: Basic " + good + "\r\n\r\n")) {
+		t.Fatalf("expected valid credentials to be accepted")
+	}
+	if auth.Authorize([]byte("GET / HTTP/1.1\r\nHost: x\r\nAuthorization: Basic " + bad + "\r\n\r\n")) {
+		t.Fatalf("expected wrong password to be rejected")
+	}
+	if auth.Authorize([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")) {
+		t.Fatalf("expected missing credentials to be rejected")
+	}
+}
+
+func TestPublicAuthBearer(t *testing.T) {
+	auth := &PublicAuth{BearerToken: "tok-123"}
+
+	if !auth.Authorize([]byte("GET / HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer tok-123\r\n\r\n")) {
+		t.Fatalf("expected valid token to be accepted")
+	}
+	if auth.Authorize([]byte("GET / HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer nope\r\n\r\n")) {
+		t.Fatalf("expected invalid token to be rejected")
+	}
+	if !strings.Contains(string(auth.Challenge()), "401 Unauthorized") {
+		t.Fatalf("expected 401 challenge")
+	}
+}
+
+func TestAuthGateChecksEveryRequest(t *testing.T) {
+	auth := &PublicAuth{BearerToken: "tok-123"}
+
+	good := "POST /a HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer tok-123\r\nContent-Length: 4\r\n\r\nbody"
+	bad := "GET /b HTTP/1.1\r\nHost: x\r\n\r\n"
+
+	gate := auth.Gate()
+	var forwarded []byte
+	// Fed a byte at a time so heads and bodies split across reads.
+	for i := range len(good) {
+		out, err := gate.Check([]byte{good[i]})
+		if err != nil {
+			t.Fatalf("authorized request refused: %v", err)
+		}
+		forwarded = append(forwarded, out...)
+	}
+	if string(forwarded) != good {
+		t.Fatalf("authorized request changed: %q", forwarded)
+	}
+
+	out, err := gate.Check([]byte(good + bad))
+	if err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized for the second request, got %v", err)
+	}
+	if string(out) != good {
+		t.Fatalf("expected only the authorized request to pass, got %q", out)
+	}
+	if out, err := gate.Check([]byte(good)); err != ErrUnauthorized || len(out) > 0 {
+		t.Fatalf("gate let data through after a refusal: %q %v", out, err)
+	}
+
+	// Bytes that stop looking like HTTP are refused, not passed through.
+	if _, err := auth.Gate().Check([]byte("SSH-2.0-OpenSSH_9.6\r\n")); err != ErrUnauthorized {
+		t.Fatalf("expected non-HTTP data to be refused, got %v", err)
+	}
+
+	// An authorized upgrade hands the connection over untouched.
+	gate = auth.Gate()
+	upgrade := "GET /ws HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer tok-123\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"
+	if _, err := gate.Check([]byte(upgrade)); err != nil {
+		t.Fatalf("authorized upgrade refused: %v", err)
+	}
+	if out, err := gate.Check([]byte{0x82, 0x00}); err != nil || len(out) != 2 {
+		t.Fatalf("upgraded data not passed through: %q %v", out, err)
+	}
+}
+
+func TestReadRequestHeadKeepsBody(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nbody"
+	head, err := ReadRequestHead(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(head) != raw {
+		t.Fatalf("expected all bytes to be returned, got %q", head)
+	}
+}
+
+func TestReadRequestHeadRejectsNonHTTP(t *testing.T) {
+	raw := "SSH-2.0-OpenSSH_9.6\r\n"
+	head, err := ReadRequestHead(strings.NewReader(raw))
+	if !errors.Is(err, ErrNotHTTPRequest) {
+		t.Fatalf("expected ErrNotHTTPRequest, got %v", err)
+	}
+	if string(head) != raw {
+		t.Fatalf("expected consumed bytes to be returned, got %q", head)
+	}
+}
+
+func TestReadRequestHeadAcceptsHTTP2Preface(t *testing.T) {
+	raw := http2Preface
+	head, err := ReadRequestHead(iotest.OneByteReader(strings.NewReader(raw)))
+	if err != nil || !strings.HasPrefix(raw, string(head)) {
+		t.Fatalf("expected the preface to be read as HTTP, got %q, %v", head, err)
+	}
+}
@@ -0,0 +1,252 @@
+package tunnel
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	respMaxLine  = 64 * 1024
+	respMaxDepth = 64
+	respKeep     = 32 // bytes kept of each captured element
+)
+
+// Commands whose first argument names a subcommand worth showing.
+var redisContainerCommands = map[string]bool{
+	"ACL": true, "CLIENT": true, "CLUSTER": true, "COMMAND": true, "CONFIG": true,
+	"FUNCTION": true, "MEMORY": true, "OBJECT": true, "SCRIPT": true, "XINFO": true,
+}
+
+// Commands after which the connection stops following request/reply.
+var redisStreamingCommands = map[string]bool{
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true, "MONITOR": true,
+}
+
+// respReader walks RESP2/RESP3 values without buffering them, keeping
+// only the first elements of each top-level array.
+type respReader struct {
+	line  []byte
+	bulk  int // bytes left of a bulk string, including its CRLF
+	grab  bool
+	buf   []byte
+	stack []int // elements left at each nesting level
+	typ   byte
+	parts []string
+}
+
+// read calls done with the type and leading elements of every complete
+// top-level value. Inline commands are accepted when inline is set.
+func (r *respReader) read(data []byte, inline bool, done func(typ byte, parts []string) error) error {
+	for len(data) > 0 {
+		if r.bulk > 0 {
+			n := min(r.bulk, len(data))
+			if r.grab {
+				k := min(n, r.bulk-2, respKeep-len(r.buf))
+				if k > 0 {
+					r.buf = append(r.buf, data[:k]...)
+				}
+			}
+			r.bulk -= n
+			data = data[n:]
+			if r.bulk == 0 {
+				if err := r.value(string(r.buf), done); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if len(r.line)+len(data) > respMaxLine {
+				return ErrNotRecognized
+			}
+			r.line = append(r.line, data...)
+			return nil
+		}
+		r.line = append(r.line, data[:i]...)
+		data = data[i+1:]
+
+		line := bytes.TrimSuffix(r.line, []byte{'\r'})
+		err := r.readLine(line, inline, done)
+		r.line = r.line[:0]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *respReader) readLine(line []byte, inline bool, done func(byte, []string) error) error {
+	if len(line) == 0 {
+		if len(r.stack) == 0 {
+			return nil
+		}
+		return ErrNotRecognized
+	}
+
+	t := line[0]
+	if len(r.stack) == 0 {
+		r.typ = t
+	}
+
+	switch t {
+	case '+', '-', ':', ',', '#', '_', '(':
+		return r.value(string(line[1:min(len(line), respKeep+1)]), done)
+
+	case '$', '=', '!':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return ErrNotRecognized
+		}
+		if n == -1 {
+			return r.value("", done)
+		}
+		r.bulk = n + 2
+		r.grab = len(r.stack) == 0 || (len(r.stack) == 1 && len(r.parts) < 2)
+		r.buf = r.buf[:0]
+		return nil
+
+	case '*', '%', '~', '>', '|':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return ErrNotRecognized
+		}
+		if n <= 0 {
+			return r.value("", done)
+		}
+		if t == '%' || t == '|' {
+			n *= 2
+		}
+		if len(r.stack) >= respMaxDepth {
+			return ErrNotRecognized
+		}
+		r.stack = append(r.stack, n)
+		return nil
+	}
+
+	if len(r.stack) > 0 || !inline {
+		return ErrNotRecognized
+	}
+	// An inline command, as typed into telnet.
+	if bytes.Contains(line, []byte(" HTTP/")) {
+		return ErrNotRecognized
+	}
+	var parts []string
+	for _, f := range bytes.Fields(line) {
+		if len(parts) == 2 {
+			break
+		}
+		parts = append(parts, string(f[:min(len(f), respKeep)]))
+	}
+	r.typ = '*'
+	return done(r.typ, parts)
+}
+
+// value records a finished element and closes any containers it completes.
+func (r *respReader) value(v string, done func(byte, []string) error) error {
+	if len(r.stack) <= 1 && len(r.parts) < 2 {
+		r.parts = append(r.parts, v)
+	}
+
+	for len(r.stack) > 0 {
+		top := len(r.stack) - 1
+		if r.stack[top]--; r.stack[top] > 0 {
+			return nil
+		}
+		r.stack = r.stack[:top]
+	}
+
+	typ, parts := r.typ, r.parts
+	r.parts = nil
+	return done(typ, parts)
+}
+
+// redisDecoder pairs RESP commands with their replies, which Redis sends
+// in order on each connection.
+type redisDecoder struct {
+	client, server respReader
+
+	started bool
+	pending []*Command
+	done    []*Command
+}
+
+func (d *redisDecoder) Request(data []byte) ([]*Command, error) {
+	if !d.started && len(data) > 0 {
+		c := data[0]
+		if c != '*' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return nil, ErrNotRecognized
+		}
+		d.started = true
+	}
+
+	d.done = nil
+	err := d.client.read(data, true, d.request)
+	return d.done, err
+}
+
+func (d *redisDecoder) Response(data []byte) ([]*Command, error) {
+	if !d.started && len(data) > 0 {
+		return nil, ErrNotRecognized // the client speaks first
+	}
+
+	d.done = nil
+	err := d.server.read(data, false, d.reply)
+	return d.done, err
+}
+
+func (d *redisDecoder) request(typ byte, parts []string) error {
+	if len(parts) == 0 {
+		return nil
+	}
+
+	name := strings.ToUpper(parts[0])
+	if redisContainerCommands[name] && len(parts) > 1 {
+		name += " " + strings.ToUpper(parts[1])
+	}
+	if name == "CLIENT REPLY" {
+		return errDecoderDone // replies may be switched off from here on
+	}
+	if len(d.pending) >= maxPendingCommands {
+		return errDecoderDone
+	}
+
+	d.pending = append(d.pending, &Command{Name: name, StartTime: time.Now()})
+	return nil
+}
+
+func (d *redisDecoder) reply(typ byte, parts []string) error {
+	if typ == '|' {
+		return nil // attributes precede the reply they describe
+	}
+	if len(d.pending) == 0 {
+		if typ == '>' {
+			return nil
+		}
+		return ErrNotRecognized
+	}
+
+	cmd := d.pending[0]
+	streaming := redisStreamingCommands[cmd.Name]
+	if typ == '>' && !streaming {
+		return nil // out-of-band push, such as client tracking
+	}
+	d.pending = d.pending[1:]
+
+	if (typ == '-' || typ == '!') && len(parts) > 0 {
+		cmd.Error, _, _ = strings.Cut(parts[0], " ")
+		if cmd.Error == "" {
+			cmd.Error = "error"
+		}
+	}
+	cmd.Duration = time.Since(cmd.StartTime)
+	d.done = append(d.done, cmd)
+
+	if streaming && cmd.Error == "" {
+		return errDecoderDone
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bakare-dev/gotunnel/internal/metrics"
+)
+
+// WebSocket opcodes from RFC 6455 section 5.2.
+const (
+	WSOpContinuation = 0x0
+	WSOpText         = 0x1
+	WSOpBinary       = 0x2
+	WSOpClose        = 0x8
+	WSOpPing         = 0x9
+	WSOpPong         = 0xa
+)
+
+// WebSocketTextLimit caps how much of a text message is kept for display.
+const WebSocketTextLimit = 120
+
+type wsState uint8
+
+const (
+	wsUnknown wsState = iota
+	wsUpgrading
+	wsOn
+	wsOff
+)
+
+// WebSocketStats counts what one side of a WebSocket connection sent.
+type WebSocketStats struct {
+	Text   int64 // complete text messages
+	Binary int64 // complete binary messages
+	Bytes  int64 // payload bytes of data messages
+	Ping   int64
+	Pong   int64
+
+	// CloseCode is 0 until a close frame is seen and 1005 for one without
+	// a status code.
+	CloseCode   int
+	CloseReason string
+}
+
+func (s WebSocketStats) Messages() int64 {
+	return s.Text + s.Binary
+}
+
+func (s WebSocketStats) String() string {
+	var parts []string
+	if s.Text > 0 {
+		parts = append(parts, fmt.Sprintf("%d text", s.Text))
+	}
+	if s.Binary > 0 {
+		parts = append(parts, fmt.Sprintf("%d binary", s.Binary))
+	}
+
+	out := fmt.Sprintf("%d msgs", s.Messages())
+	if len(parts) > 0 {
+		out += " (" + strings.Join(parts, ", ") + ")"
+	}
+	out += " " + metrics.FormatBytes(s.Bytes)
+
+	if s.Ping > 0 || s.Pong > 0 {
+		out += fmt.Sprintf(", %d ping/%d pong", s.Ping, s.Pong)
+	}
+	if s.CloseCode != 0 {
+		out += fmt.Sprintf(", close %d", s.CloseCode)
+	}
+	return out
+}
+
+// WebSocketEvent is a frame worth logging on its own: a finished text
+// message, when text display is on, or a close frame.
+type WebSocketEvent struct {
+	FromClient bool
+	Opcode     byte
+	Size       int64
+
+	Text       string
+	Truncated  bool
+	Compressed bool
+
+	CloseCode   int
+	CloseReason string
+}
+
+func (e WebSocketEvent) String() string {
+	arrow := "←"
+	if e.FromClient {
+		arrow = "→"
+	}
+
+	if e.Opcode == WSOpClose {
+		if e.CloseReason != "" {
+			return fmt.Sprintf("%s close %d %q", arrow, e.CloseCode, e.CloseReason)
+		}
+		return fmt.Sprintf("%s close %d", arrow, e.CloseCode)
+	}
+
+	switch {
+	case e.Compressed:
+		return fmt.Sprintf("%s text %s (compressed)", arrow, metrics.FormatBytes(e.Size))
+	case e.Truncated:
+		return fmt.Sprintf("%s text %s %q…", arrow, metrics.FormatBytes(e.Size), e.Text)
+	}
+	return fmt.Sprintf("%s text %s %q", arrow, metrics.FormatBytes(e.Size), e.Text)
+}
+
+// WebSocketInspector watches an HTTP/1.1 stream for a WebSocket upgrade and,
+// once the server answers 101, decodes the frames in both directions.
+// Like the HTTP log, the upgrade has to be in the first packet each way;
+// streams that are not upgrades switch the inspector off immediately.
+type WebSocketInspector struct {
+	mu       sync.Mutex
+	state    wsState
+	showText bool
+	start    time.Time
+
+	client wsReader
+	server wsReader
+}
+
+// NewWebSocketInspector returns an inspector; showText makes it report each
+// text message, cut to WebSocketTextLimit bytes, as an event.
+func NewWebSocketInspector(showText bool) *WebSocketInspector {
+	w := &WebSocketInspector{showText: showText}
+	w.client.fromClient = true
+	return w
+}
+
+// Upgraded reports whether the stream switched to WebSocket.
+func (w *WebSocketInspector) Upgraded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state == wsOn
+}
+
+// Request consumes client-to-server bytes.
+func (w *WebSocketInspector) Request(data []byte) []WebSocketEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch w.state {
+	case wsUnknown:
+		head, rest, ok := splitHead(data)
+		if !ok || !isWebSocketUpgrade(head) {
+			w.state = wsOff
+			return nil
+		}
+		w.state = wsUpgrading
+		// Frames sent ahead of the 101 are held until the upgrade is confirmed.
+		w.client.pending = append([]byte(nil), rest...)
+		return nil
+
+	case wsUpgrading:
+		if len(w.client.pending)+len(data) > maxRequestHeaderSize {
+			w.state = wsOff
+			return nil
+		}
+		w.client.pending = append(w.client.pending, data...)
+		return nil
+
+	case wsOn:
+		return w.client.read(data, w.showText, nil)
+	}
+	return nil
+}
+
+// Response consumes server-to-client bytes.
+func (w *WebSocketInspector) Response(data []byte) []WebSocketEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch w.state {
+	case wsUpgrading:
+		head, rest, ok := splitHead(data)
+		if !ok || !isSwitchingProtocols(head) {
+			w.state = wsOff
+			w.client.pending = nil
+			return nil
+		}
+		w.state = wsOn
+		w.start = time.Now()
+
+		pending := w.client.pending
+		w.client.pending = nil
+		events := w.client.read(pending, w.showText, nil)
+		return w.server.read(rest, w.showText, events)
+
+	case wsOn:
+		return w.server.read(data, w.showText, nil)
+	}
+	return nil
+}
+
+// Stats returns what the client and the server have sent so far.
+func (w *WebSocketInspector) Stats() (client, server WebSocketStats) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.client.stats, w.server.stats
+}
+
+// Summary describes an upgraded connection, or returns "" if the stream
+// never switched to WebSocket.
+func (w *WebSocketInspector) Summary() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state != wsOn {
+		return ""
+	}
+	return fmt.Sprintf("closed after %s: → %s, ← %s",
+		time.Since(w.start).Round(time.Millisecond), w.client.stats, w.server.stats)
+}
+
+func splitHead(data []byte) (head, rest []byte, ok bool) {
+	i := bytes.Index(data, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil, false
+	}
+	return data[:i+4], data[i+4:], true
+}
+
+func isWebSocketUpgrade(head []byte) bool {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return false
+	}
+	return headerHasToken(req.Header, "Upgrade", "websocket")
+}
+
+func isSwitchingProtocols(head []byte) bool {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), nil)
+	if err != nil {
+		return false
+	}
+	return resp.StatusCode == http.StatusSwitchingProtocols && headerHasToken(resp.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsReader decodes the frames one side sends. Payloads are only kept for
+// close frames and, when text display is on, the start of text messages.
+type wsReader struct {
+	fromClient bool
+	pending    []byte
+	stats      WebSocketStats
+
+	header    [14]byte
+	headerLen int
+	need      int
+
+	opcode    byte
+	fin       bool
+	mask      [4]byte
+	masked    bool
+	remaining int64
+	offset    int64
+	payload   []byte
+
+	// The data message in progress, which may span continuation frames.
+	message    byte
+	size       int64
+	compressed bool
+	text       []byte
+}
+
+func (r *wsReader) read(data []byte, showText bool, events []WebSocketEvent) []WebSocketEvent {
+	for len(data) > 0 {
+		if r.need == 0 {
+			r.need = 2
+		}
+		if r.headerLen < r.need {
+			n := copy(r.header[r.headerLen:r.need], data)
+			r.headerLen += n
+			data = data[n:]
+			if r.headerLen < r.need {
+				return events
+			}
+			if !r.begin() {
+				continue // the header grew; read the rest of it
+			}
+			if r.remaining > 0 {
+				continue
+			}
+		} else {
+			n := int64(len(data))
+			if n > r.remaining {
+				n = r.remaining
+			}
+			r.consume(data[:n], showText)
+			r.remaining -= n
+			data = data[n:]
+			if r.remaining > 0 {
+				return events
+			}
+		}
+
+		events = r.end(showText, events)
+		r.headerLen, r.need = 0, 0
+	}
+	return events
+}
+
+// begin parses a frame header, returning false while more header bytes
+// are needed.
+func (r *wsReader) begin() bool {
+	h := r.header[:]
+
+	length := int64(h[1] & 0x7f)
+	need := 2
+	switch length {
+	case 126:
+		need += 2
+	case 127:
+		need += 8
+	}
+	r.masked = h[1]&0x80 != 0
+	if r.masked {
+		need += 4
+	}
+	if r.headerLen < need {
+		r.need = need
+		return false
+	}
+
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(h[2:]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(h[2:]) & (1<<63 - 1))
+	}
+	if r.masked {
+		copy(r.mask[:], h[need-4:need])
+	}
+
+	r.fin = h[0]&0x80 != 0
+	r.opcode = h[0] & 0x0f
+	r.remaining = length
+	r.offset = 0
+	r.payload = r.payload[:0]
+
+	switch r.opcode {
+	case WSOpText, WSOpBinary:
+		r.message = r.opcode
+		r.size = 0
+		r.compressed = h[0]&0x40 != 0 // RSV1 under permessage-deflate
+		r.text = r.text[:0]
+	}
+	if r.opcode <= WSOpBinary {
+		r.size += length
+	}
+	return true
+}
+
+func (r *wsReader) consume(data []byte, showText bool) {
+	keep := 0
+	switch {
+	case r.opcode == WSOpClose:
+		keep = 125 - len(r.payload)
+	case r.opcode <= WSOpBinary && r.message == WSOpText && showText && !r.compressed:
+		keep = WebSocketTextLimit + utf8.UTFMax - len(r.text) - len(r.payload)
+	}
+
+	if keep > 0 {
+		n := min(keep, len(data))
+		start := len(r.payload)
+		r.payload = append(r.payload, data[:n]...)
+		if r.masked {
+			for i := start; i < len(r.payload); i++ {
+				r.payload[i] ^= r.mask[(r.offset+int64(i-start))%4]
+			}
+		}
+	}
+	r.offset += int64(len(data))
+}
+
+func (r *wsReader) end(showText bool, events []WebSocketEvent) []WebSocketEvent {
+	switch r.opcode {
+	case WSOpPing:
+		r.stats.Ping++
+	case WSOpPong:
+		r.stats.Pong++
+
+	case WSOpClose:
+		code, reason := 1005, ""
+		if len(r.payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(r.payload))
+			reason = string(r.payload[2:])
+		}
+		r.stats.CloseCode, r.stats.CloseReason = code, reason
+		events = append(events, WebSocketEvent{
+			FromClient:  r.fromClient,
+			Opcode:      WSOpClose,
+			CloseCode:   code,
+			CloseReason: reason,
+		})
+
+	case WSOpText, WSOpBinary, WSOpContinuation:
+		if r.message == WSOpText {
+			r.text = append(r.text, r.payload...)
+		}
+		if !r.fin || r.message == 0 {
+			break
+		}
+
+		r.stats.Bytes += r.size
+		if r.message == WSOpBinary {
+			r.stats.Binary++
+		} else {
+			r.stats.Text++
+			if showText {
+				events = append(events, r.textEvent())
+			}
+		}
+		r.message = 0
+	}
+	return events
+}
+
+func (r *wsReader) textEvent() WebSocketEvent {
+	e := WebSocketEvent{
+		FromClient: r.fromClient,
+		Opcode:     WSOpText,
+		Size:       r.size,
+		Compressed: r.compressed,
+	}
+	if r.compressed {
+		return e
+	}
+
+	text := r.text
+	if len(text) > WebSocketTextLimit {
+		// Cut on a rune boundary so the log shows valid UTF-8.
+		text = text[:WebSocketTextLimit]
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(text); i++ {
+			text = text[:len(text)-1]
+		}
+	}
+	e.Text = string(text)
+	e.Truncated = int64(len(text)) < r.size
+	return e
+}
@@ -0,0 +1,143 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+const (
+	wsUpgradeRequest = "GET /chat HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	wsUpgradeResponse = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n"
+)
+
+func wsFrame(fin bool, opcode byte, payload []byte, masked bool) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+
+	var b1 byte
+	if masked {
+		b1 = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, b1|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, b1|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, b1|127), uint64(n))
+	}
+
+	if !masked {
+		return append(frame, payload...)
+	}
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+func TestWebSocketInspector(t *testing.T) {
+	var client []byte
+	client = append(client, wsUpgradeRequest...)
+	client = append(client, wsFrame(false, WSOpText, []byte("hel"), true)...)
+	client = append(client, wsFrame(true, WSOpPing, []byte("p"), true)...)
+	client = append(client, wsFrame(true, WSOpContinuation, []byte("lo"), true)...)
+	client = append(client, wsFrame(true, WSOpBinary, bytes.Repeat([]byte{1}, 200), true)...)
+	client = append(client, wsFrame(true, WSOpClose, closePayload(1000, "bye"), true)...)
+
+	long := strings.Repeat("é", 100) // 200 bytes
+	var server []byte
+	server = append(server, wsUpgradeResponse...)
+	server = append(server, wsFrame(true, WSOpPong, []byte("p"), false)...)
+	server = append(server, wsFrame(true, WSOpText, []byte(long), false)...)
+	server = append(server, wsFrame(true, WSOpClose, closePayload(1001, ""), false)...)
+
+	w := NewWebSocketInspector(true)
+
+	// The request head arrives whole; frames trickle in byte by byte, some
+	// of them before the server has agreed to the upgrade.
+	var events []WebSocketEvent
+	events = append(events, w.Request(client[:len(wsUpgradeRequest)])...)
+	for _, b := range client[len(wsUpgradeRequest) : len(wsUpgradeRequest)+4] {
+		events = append(events, w.Request([]byte{b})...)
+	}
+	events = append(events, w.Response(server[:len(wsUpgradeResponse)+3])...)
+	for _, b := range client[len(wsUpgradeRequest)+4:] {
+		events = append(events, w.Request([]byte{b})...)
+	}
+	for _, b := range server[len(wsUpgradeResponse)+3:] {
+		events = append(events, w.Response([]byte{b})...)
+	}
+
+	if !w.Upgraded() {
+		t.Fatal("expected the stream to be upgraded")
+	}
+
+	want := []string{
+		`→ text 5 B "hello"`,
+		`→ close 1000 "bye"`,
+		`← text 200 B "` + strings.Repeat("é", WebSocketTextLimit/2) + `"…`,
+		`← close 1001`,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %v", len(want), events)
+	}
+	for i, e := range events {
+		if e.String() != want[i] {
+			t.Fatalf("event %d: expected %s, got %s", i, want[i], e.String())
+		}
+	}
+
+	c, s := w.Stats()
+	if c.Text != 1 || c.Binary != 1 || c.Bytes != 205 || c.Ping != 1 || c.CloseCode != 1000 {
+		t.Fatalf("unexpected client stats %+v", c)
+	}
+	if s.Text != 1 || s.Bytes != 200 || s.Pong != 1 || s.CloseCode != 1001 {
+		t.Fatalf("unexpected server stats %+v", s)
+	}
+	if !strings.Contains(w.Summary(), "→ 2 msgs (1 text, 1 binary) 205 B, 1 ping/0 pong, close 1000") {
+		t.Fatalf("unexpected summary %q", w.Summary())
+	}
+}
+
+func TestWebSocketInspectorHidesText(t *testing.T) {
+	w := NewWebSocketInspector(false)
+	w.Request([]byte(wsUpgradeRequest))
+	w.Response([]byte(wsUpgradeResponse))
+
+	if events := w.Request(wsFrame(true, WSOpText, []byte("secret"), true)); len(events) != 0 {
+		t.Fatalf("expected no text events, got %v", events)
+	}
+	if c, _ := w.Stats(); c.Text != 1 || c.Bytes != 6 {
+		t.Fatalf("expected the message to be counted, got %+v", c)
+	}
+}
+
+func TestWebSocketInspectorNotUpgraded(t *testing.T) {
+	plain := NewWebSocketInspector(true)
+	plain.Request([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	plain.Response([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+
+	refused := NewWebSocketInspector(true)
+	refused.Request([]byte(wsUpgradeRequest))
+	refused.Response([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+
+	for _, w := range []*WebSocketInspector{plain, refused} {
+		if w.Upgraded() || w.Summary() != "" {
+			t.Fatal("expected the stream not to be treated as WebSocket")
+		}
+	}
+}
@@ -1,4 +1,6 @@
 #!/bin/bash
+# Prefer `gotunnel cert init` and `gotunnel cert issue`, which need no openssl
+# and support custom hostnames and client certificates.
 
 CERT_DIR="./certs"
 mkdir -p $CERT_DIR